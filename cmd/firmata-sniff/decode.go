@@ -0,0 +1,240 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/empirefox/firmata/pkg/firmata"
+	"github.com/empirefox/firmata/pkg/firmata/device"
+	"github.com/empirefox/firmata/pkg/pb"
+)
+
+// message is a decoded frame of either direction.
+type message struct {
+	// name is the command or sysex name used by --type
+	name string
+	// pins are the Dx of the message, nil if none
+	pins []byte
+	text string
+}
+
+// decoder keeps the analog mapping seen in ANALOG_MAPPING_RESPONSE, so Ax
+// messages can be filtered by Dx.
+type decoder struct {
+	mu     sync.Mutex
+	dxByAx map[byte]byte
+}
+
+func newDecoder() *decoder {
+	return &decoder{dxByAx: make(map[byte]byte)}
+}
+
+func (d *decoder) axPins(ax byte) []byte {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if dx, ok := d.dxByAx[ax]; ok {
+		return []byte{dx}
+	}
+	return nil
+}
+
+func portPins(port byte) []byte {
+	pins := make([]byte, 8)
+	for i := range pins {
+		pins[i] = port*8 + byte(i)
+	}
+	return pins
+}
+
+func (d *decoder) decodeCommand(cmd *device.Command) *message {
+	switch cmd.Type {
+	case firmata.REPORT_VERSION, firmata.SYSTEM_RESET:
+		return &message{name: commandNames[cmd.Type]}
+	case firmata.SET_PIN_MODE:
+		return &message{
+			name: "SET_PIN_MODE",
+			pins: []byte{cmd.Pin},
+			text: fmt.Sprintf("D%d %s", cmd.Pin, nameOf(modeNames, byte(cmd.Value))),
+		}
+	case firmata.SET_DIGITAL_PIN_VALUE:
+		return &message{
+			name: "SET_DIGITAL_PIN_VALUE",
+			pins: []byte{cmd.Pin},
+			text: fmt.Sprintf("D%d=%d", cmd.Pin, cmd.Value),
+		}
+	case firmata.DIGITAL_MESSAGE:
+		return &message{
+			name: "DIGITAL_MESSAGE",
+			pins: portPins(cmd.Pin),
+			text: fmt.Sprintf("port%d=%08b", cmd.Pin, cmd.Value),
+		}
+	case firmata.ANALOG_MESSAGE:
+		return &message{
+			name: "ANALOG_MESSAGE",
+			pins: []byte{cmd.Pin},
+			text: fmt.Sprintf("D%d=%d", cmd.Pin, cmd.Value),
+		}
+	case firmata.REPORT_ANALOG:
+		return &message{
+			name: "REPORT_ANALOG",
+			pins: d.axPins(cmd.Pin),
+			text: fmt.Sprintf("A%d %s", cmd.Pin, onOff(cmd.Value)),
+		}
+	case firmata.REPORT_DIGITAL:
+		return &message{
+			name: "REPORT_DIGITAL",
+			pins: portPins(cmd.Pin),
+			text: fmt.Sprintf("port%d %s", cmd.Pin, onOff(cmd.Value)),
+		}
+	case firmata.START_SYSEX:
+		return d.decodeHostSysex(cmd.Sysex)
+	}
+	return &message{name: nameOf(commandNames, cmd.Type)}
+}
+
+func (d *decoder) decodeHostSysex(data []byte) *message {
+	if len(data) == 0 {
+		return &message{name: "START_SYSEX"}
+	}
+	m := &message{name: nameOf(sysexNames, data[0])}
+	args := data[1:]
+	switch data[0] {
+	case firmata.SAMPLING_INTERVAL:
+		if len(args) >= 2 {
+			m.text = fmt.Sprintf("%dms", uint32(args[0])|uint32(args[1])<<7)
+		}
+	case firmata.PIN_STATE_QUERY:
+		if len(args) >= 1 {
+			m.pins = []byte{args[0]}
+			m.text = fmt.Sprintf("D%d", args[0])
+		}
+	case firmata.EXTENDED_ANALOG:
+		if len(args) >= 1 {
+			var value uint32
+			for i, b := range args[1:] {
+				value |= uint32(b&0x7F) << (7 * uint(i))
+			}
+			m.pins = []byte{args[0]}
+			m.text = fmt.Sprintf("D%d=%d", args[0], value)
+		}
+	case firmata.SERVO_CONFIG:
+		if len(args) >= 5 {
+			m.pins = []byte{args[0]}
+			m.text = fmt.Sprintf("D%d min=%d max=%d", args[0],
+				uint32(args[1])|uint32(args[2])<<7, uint32(args[3])|uint32(args[4])<<7)
+		}
+	case firmata.I2C_REQUEST:
+		if len(args) >= 2 {
+			modes := [4]string{"write", "read", "read-continuously", "stop-reading"}
+			m.text = fmt.Sprintf("addr=0x%02X %s data=% X", args[0],
+				modes[args[1]>>3&0x03], firmata.From14bits(args[2:]))
+		}
+	case firmata.I2C_CONFIG:
+		if len(args) >= 2 {
+			m.text = fmt.Sprintf("delay=%dus", uint32(args[0])|uint32(args[1])<<7)
+		}
+	case firmata.STRING_DATA:
+		m.text = fmt.Sprintf("%q", firmata.From14bits(args))
+	default:
+		if len(args) != 0 {
+			m.text = fmt.Sprintf("% X", args)
+		}
+	}
+	return m
+}
+
+func (d *decoder) decodeFrame(frame *firmata.ReadFrame) *message {
+	switch frame.Type {
+	case firmata.REPORT_VERSION:
+		v := frame.Data.(*firmata.Version)
+		return &message{
+			name: "REPORT_VERSION",
+			text: fmt.Sprintf("%d.%d", v.Server.Major, v.Server.Minor),
+		}
+	case firmata.REPORT_FIRMWARE:
+		v := frame.Data.(*firmata.Version)
+		return &message{
+			name: "REPORT_FIRMWARE",
+			text: fmt.Sprintf("%s %d.%d", v.Server.Name, v.Server.Major, v.Server.Minor),
+		}
+	case firmata.ANALOG_MESSAGE:
+		data := frame.Data.(*firmata.AnalogPinValueFrameData)
+		return &message{
+			name: "ANALOG_MESSAGE",
+			pins: d.axPins(data.Pin),
+			text: fmt.Sprintf("A%d=%d", data.Pin, data.Value),
+		}
+	case firmata.DIGITAL_MESSAGE:
+		data := frame.Data.(*firmata.DigitalPinValueFrameData)
+		return &message{
+			name: "DIGITAL_MESSAGE",
+			pins: portPins(data.Port),
+			text: fmt.Sprintf("port%d=%08b", data.Port, data.Values),
+		}
+	case firmata.CAPABILITY_RESPONSE:
+		data := frame.Data.(*firmata.CapabilityFrameData)
+		pins := make([]string, len(data.Pins))
+		for dx, pin := range data.Pins {
+			modes := make([]string, 0, len(pin.Modes))
+			for mode := byte(0); mode < firmata.TOTAL_PIN_MODES; mode++ {
+				if _, ok := pin.Modes[mode]; ok {
+					modes = append(modes, modeNames[mode])
+				}
+			}
+			pins[dx] = fmt.Sprintf("D%d[%s]", dx, strings.Join(modes, ","))
+		}
+		return &message{name: "CAPABILITY_RESPONSE", text: strings.Join(pins, " ")}
+	case firmata.ANALOG_MAPPING_RESPONSE:
+		data := frame.Data.([]byte)
+		var pins []string
+		d.mu.Lock()
+		for dx, ax := range data {
+			if ax != 127 {
+				d.dxByAx[ax] = byte(dx)
+				pins = append(pins, fmt.Sprintf("A%d=D%d", ax, dx))
+			}
+		}
+		d.mu.Unlock()
+		return &message{name: "ANALOG_MAPPING_RESPONSE", text: strings.Join(pins, " ")}
+	case firmata.PIN_STATE_RESPONSE:
+		data := frame.Data.(*firmata.PinStateFrameData)
+		return &message{
+			name: "PIN_STATE_RESPONSE",
+			pins: []byte{data.Pin},
+			text: fmt.Sprintf("D%d %s state=%d", data.Pin, nameOf(modeNames, data.Mode), data.State),
+		}
+	case firmata.UD_PIN_NAMES_REPLY:
+		data := frame.Data.([]byte)
+		names := make([]string, len(data))
+		for dx, name := range data {
+			names[dx] = fmt.Sprintf("D%d=%s", dx, pb.PinName(name))
+		}
+		return &message{name: "UD_PIN_NAMES_REPLY", text: strings.Join(names, " ")}
+	case firmata.UD_BOARD_SERIAL_REPLY:
+		return &message{name: "UD_BOARD_SERIAL_REPLY", text: fmt.Sprintf("%q", frame.Data.([]byte))}
+	case firmata.I2C_REPLY:
+		data := frame.Data.(*firmata.I2cReply)
+		return &message{
+			name: "I2C_REPLY",
+			text: fmt.Sprintf("addr=0x%02X reg=%d data=% X", data.Address, data.Register, data.Data),
+		}
+	case firmata.STRING_DATA:
+		return &message{name: "STRING_DATA", text: fmt.Sprintf("%q", frame.Data.([]byte))}
+	case firmata.START_SYSEX:
+		data := frame.Data.([]byte)
+		m := &message{name: nameOf(sysexNames, data[0])}
+		if len(data) > 1 {
+			m.text = fmt.Sprintf("% X", data[1:])
+		}
+		return m
+	}
+	return &message{name: nameOf(commandNames, frame.Type)}
+}
+
+func onOff(v uint32) string {
+	if v != 0 {
+		return "on"
+	}
+	return "off"
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/empirefox/firmata/pkg/firmata"
+	"github.com/empirefox/firmata/pkg/firmata/device"
+	"github.com/empirefox/firmata/pkg/pb"
+	"gobot.io/x/gobot/gobottest"
+)
+
+func TestDecodeCommand(t *testing.T) {
+	d := newDecoder()
+	d.dxByAx[1] = 15
+	cases := []struct {
+		cmd  device.Command
+		name string
+		pins []byte
+		text string
+	}{
+		{device.Command{Type: firmata.SYSTEM_RESET}, "SYSTEM_RESET", nil, ""},
+		{device.Command{Type: firmata.SET_PIN_MODE, Pin: 13, Value: uint32(firmata.PIN_MODE_OUTPUT)},
+			"SET_PIN_MODE", []byte{13}, "D13 OUTPUT"},
+		{device.Command{Type: firmata.SET_DIGITAL_PIN_VALUE, Pin: 13, Value: 1},
+			"SET_DIGITAL_PIN_VALUE", []byte{13}, "D13=1"},
+		{device.Command{Type: firmata.DIGITAL_MESSAGE, Pin: 1, Value: 5},
+			"DIGITAL_MESSAGE", portPins(1), "port1=00000101"},
+		{device.Command{Type: firmata.ANALOG_MESSAGE, Pin: 3, Value: 255},
+			"ANALOG_MESSAGE", []byte{3}, "D3=255"},
+		{device.Command{Type: firmata.REPORT_ANALOG, Pin: 1, Value: 1},
+			"REPORT_ANALOG", []byte{15}, "A1 on"},
+		{device.Command{Type: firmata.REPORT_ANALOG, Pin: 2, Value: 0},
+			"REPORT_ANALOG", nil, "A2 off"},
+		{device.Command{Type: firmata.REPORT_DIGITAL, Pin: 0, Value: 1},
+			"REPORT_DIGITAL", portPins(0), "port0 on"},
+		{device.Command{Type: firmata.START_SYSEX, Sysex: []byte{firmata.PIN_STATE_QUERY, 7}},
+			"PIN_STATE_QUERY", []byte{7}, "D7"},
+		{device.Command{Type: firmata.START_SYSEX, Sysex: []byte{firmata.SAMPLING_INTERVAL, 0x68, 0x07}},
+			"SAMPLING_INTERVAL", nil, "1000ms"},
+		{device.Command{Type: firmata.START_SYSEX, Sysex: []byte{firmata.EXTENDED_ANALOG, 20, 0x7F, 0x01}},
+			"EXTENDED_ANALOG", []byte{20}, "D20=255"},
+		{device.Command{Type: firmata.START_SYSEX, Sysex: []byte{firmata.I2C_REQUEST, 0x20, 0x08, 0x01, 0x00}},
+			"I2C_REQUEST", nil, "addr=0x20 read data=01"},
+		{device.Command{Type: firmata.START_SYSEX, Sysex: []byte{0x01, 0x02}},
+			"0x01", nil, "02"},
+		{device.Command{Type: firmata.START_SYSEX}, "START_SYSEX", nil, ""},
+	}
+	for _, c := range cases {
+		cmd := c.cmd
+		m := d.decodeCommand(&cmd)
+		if m.name != c.name || !bytes.Equal(m.pins, c.pins) || m.text != c.text {
+			t.Errorf("%s: got %s %v %q", c.name, m.name, m.pins, m.text)
+		}
+	}
+}
+
+func TestDecodeFrame(t *testing.T) {
+	d := newDecoder()
+	cases := []struct {
+		frame firmata.ReadFrame
+		name  string
+		pins  []byte
+		text  string
+	}{
+		{firmata.ReadFrame{Type: firmata.REPORT_FIRMWARE, Data: &firmata.Version{
+			Server: &pb.Version_Peer{Name: "StandardFirmata", Major: 2, Minor: 5}}},
+			"REPORT_FIRMWARE", nil, "StandardFirmata 2.5"},
+		// learns A0=D2 and A1=D3
+		{firmata.ReadFrame{Type: firmata.ANALOG_MAPPING_RESPONSE, Data: []byte{127, 127, 0, 1}},
+			"ANALOG_MAPPING_RESPONSE", nil, "A0=D2 A1=D3"},
+		{firmata.ReadFrame{Type: firmata.ANALOG_MESSAGE,
+			Data: &firmata.AnalogPinValueFrameData{Pin: 1, Value: 512}},
+			"ANALOG_MESSAGE", []byte{3}, "A1=512"},
+		{firmata.ReadFrame{Type: firmata.DIGITAL_MESSAGE,
+			Data: &firmata.DigitalPinValueFrameData{Port: 0, Values: 0x81}},
+			"DIGITAL_MESSAGE", portPins(0), "port0=10000001"},
+		{firmata.ReadFrame{Type: firmata.PIN_STATE_RESPONSE,
+			Data: &firmata.PinStateFrameData{Pin: 13, Mode: firmata.PIN_MODE_OUTPUT, State: 1}},
+			"PIN_STATE_RESPONSE", []byte{13}, "D13 OUTPUT state=1"},
+		{firmata.ReadFrame{Type: firmata.STRING_DATA, Data: []byte("hi")},
+			"STRING_DATA", nil, `"hi"`},
+		{firmata.ReadFrame{Type: firmata.START_SYSEX, Data: []byte{0x01, 0x02, 0x03}},
+			"0x01", nil, "02 03"},
+	}
+	for _, c := range cases {
+		frame := c.frame
+		m := d.decodeFrame(&frame)
+		if m.name != c.name || !bytes.Equal(m.pins, c.pins) || m.text != c.text {
+			t.Errorf("%s: got %s %v %q", c.name, m.name, m.pins, m.text)
+		}
+	}
+}
+
+func TestPrinterMatch(t *testing.T) {
+	pinMode := &message{name: "SET_PIN_MODE", pins: []byte{13}}
+	port := &message{name: "DIGITAL_MESSAGE", pins: portPins(1)}
+	version := &message{name: "REPORT_VERSION"}
+	cases := []struct {
+		types []string
+		pins  []byte
+		m     *message
+		match bool
+	}{
+		{nil, nil, version, true},
+		{[]string{"SET_PIN_MODE"}, nil, pinMode, true},
+		{[]string{"SET_PIN_MODE"}, nil, port, false},
+		{nil, []byte{13}, pinMode, true},
+		{nil, []byte{12}, pinMode, false},
+		{nil, []byte{9}, port, true},
+		{nil, []byte{9}, version, false},
+		{[]string{"DIGITAL_MESSAGE"}, []byte{3}, port, false},
+	}
+	for i, c := range cases {
+		p := &printer{types: make(map[string]bool), pins: make(map[byte]bool)}
+		for _, name := range c.types {
+			p.types[name] = true
+		}
+		for _, pin := range c.pins {
+			p.pins[pin] = true
+		}
+		if p.match(c.m) != c.match {
+			t.Errorf("case %d: %s should match %v", i, c.m.name, c.match)
+		}
+	}
+}
+
+func TestDecodeCommandsResync(t *testing.T) {
+	// a sysex longer than the reader buffer, then a valid command
+	b := []byte{firmata.START_SYSEX}
+	b = append(b, bytes.Repeat([]byte{0x01}, firmata.MaxRecvSize+1)...)
+	b = append(b, firmata.END_SYSEX, firmata.SET_DIGITAL_PIN_VALUE, 13, 1)
+
+	var names []string
+	decodeCommands(&chunkReader{ch: chunksOf([]chunk{{data: b}})}, newDecoder(), func(e *event) {
+		names = append(names, e.m.name)
+	})
+	gobottest.Assert(t, names, []string{"ERROR", "SET_DIGITAL_PIN_VALUE"})
+}
+
+func TestForwardNotBlocked(t *testing.T) {
+	p := &printer{out: io.Discard}
+	var dst bytes.Buffer
+	src := strings.NewReader(strings.Repeat("x", 4096))
+	// nobody decodes
+	ch := make(chan chunk)
+	err := forward(&dst, &smallReader{src}, p, dirHostToBoard, ch)
+	gobottest.Assert(t, err, io.EOF)
+	gobottest.Assert(t, dst.Len(), 4096)
+}
+
+// smallReader reads 16 bytes at most, so forward sends many chunks.
+type smallReader struct{ r io.Reader }
+
+func (r *smallReader) Read(p []byte) (int, error) {
+	if len(p) > 16 {
+		p = p[:16]
+	}
+	return r.r.Read(p)
+}
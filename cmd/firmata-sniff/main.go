@@ -0,0 +1,296 @@
+// Command firmata-sniff decodes firmata traffic between a host and a board,
+// or of a capture file recorded by planet.
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/empirefox/firmata/pkg/dial"
+	"github.com/empirefox/firmata/pkg/firmata"
+	"github.com/empirefox/firmata/pkg/firmata/device"
+	"github.com/jessevdk/go-flags"
+)
+
+type options struct {
+	Host    string   `short:"H" long:"host"    description:"Host side dial url, like serial:///dev/pts/3"`
+	Listen  string   `short:"l" long:"listen"  description:"Accept the host side on a tcp address instead of --host"`
+	Board   string   `short:"B" long:"board"   description:"Board side dial url, like serial:///dev/ttyUSB0?baud=57600"`
+	Capture string   `short:"c" long:"capture" description:"Decode a capture file instead of sniffing"`
+	Types   []string `short:"t" long:"type"    description:"Only print these messages, like SET_PIN_MODE or I2C_REQUEST"`
+	Pins    []uint   `short:"p" long:"pin"     description:"Only print messages of these Dx, ports and analog pins are expanded"`
+	Raw     bool     `short:"r" long:"raw"     description:"Print raw bytes of both directions too"`
+}
+
+const (
+	dirHostToBoard = "H>B"
+	dirBoardToHost = "B>H"
+)
+
+type printer struct {
+	mu    sync.Mutex
+	out   io.Writer
+	types map[string]bool
+	pins  map[byte]bool
+	raw   bool
+}
+
+func (p *printer) match(m *message) bool {
+	if len(p.types) != 0 && !p.types[m.name] {
+		return false
+	}
+	if len(p.pins) == 0 {
+		return true
+	}
+	for _, pin := range m.pins {
+		if p.pins[pin] {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *printer) print(e *event) {
+	if e.m == nil && !p.raw || e.m != nil && !p.match(e.m) {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if e.m == nil {
+		fmt.Fprintf(p.out, "%s %s raw % X\n", e.at.Format("15:04:05.000000"), e.dir, e.raw)
+		return
+	}
+	fmt.Fprintf(p.out, "%s %s %s %s\n", e.at.Format("15:04:05.000000"), e.dir, e.m.name, e.m.text)
+}
+
+// event is a decoded message, or raw bytes if m is nil.
+type event struct {
+	at  time.Time
+	dir string
+	m   *message
+	raw []byte
+}
+
+type chunk struct {
+	at   time.Time
+	data []byte
+}
+
+// chunkReader reads chunks of one direction, at is the time of the chunk
+// which the last read byte belongs to.
+type chunkReader struct {
+	ch  <-chan chunk
+	cur []byte
+	at  time.Time
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for len(r.cur) == 0 {
+		c, ok := <-r.ch
+		if !ok {
+			return 0, io.EOF
+		}
+		r.cur, r.at = c.data, c.at
+	}
+	n := copy(p, r.cur)
+	r.cur = r.cur[n:]
+	return n, nil
+}
+
+func decodeCommands(r *chunkReader, d *decoder, emit func(*event)) {
+	cr := device.NewCommandReader(r)
+	for {
+		cmd, err := cr.ReadCommand()
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return
+			}
+			// the reader skips data bytes until the next command
+			emit(&event{at: r.at, dir: dirHostToBoard, m: &message{name: "ERROR", text: err.Error()}})
+			continue
+		}
+		emit(&event{at: r.at, dir: dirHostToBoard, m: d.decodeCommand(cmd)})
+	}
+}
+
+func decodeFrames(r *chunkReader, d *decoder, emit func(*event)) {
+	fr := firmata.NewReadFramer(r)
+	for {
+		frame, err := fr.ReadFrame()
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return
+			}
+			emit(&event{at: r.at, dir: dirBoardToHost, m: &message{name: "ERROR", text: err.Error()}})
+			continue
+		}
+		emit(&event{at: r.at, dir: dirBoardToHost, m: d.decodeFrame(frame)})
+	}
+}
+
+func run() error {
+	c := options{}
+	_, err := flags.Parse(&c)
+	if err != nil {
+		// print help
+		return nil
+	}
+
+	p := &printer{
+		out:   os.Stdout,
+		types: make(map[string]bool),
+		pins:  make(map[byte]bool),
+		raw:   c.Raw,
+	}
+	for _, t := range c.Types {
+		p.types[strings.ToUpper(t)] = true
+	}
+	for _, pin := range c.Pins {
+		p.pins[byte(pin)] = true
+	}
+
+	if c.Capture != "" {
+		return decodeCapture(c.Capture, p)
+	}
+	if c.Board == "" || (c.Host == "") == (c.Listen == "") {
+		return errors.New("--board and one of --host or --listen are required without --capture")
+	}
+	return sniff(&c, p)
+}
+
+// decodeCapture decodes both directions one by one, then prints all events
+// in time order. Board to host goes first to learn the analog mapping.
+func decodeCapture(path string, p *printer) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	cr, err := dial.NewCaptureReader(file)
+	if err != nil {
+		return err
+	}
+
+	var events []*event
+	emit := func(e *event) { events = append(events, e) }
+	var reads, writes []chunk
+	for {
+		rec, err := cr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		switch rec.Dir {
+		case dial.CaptureRead:
+			reads = append(reads, chunk{rec.Time, rec.Data})
+			emit(&event{at: rec.Time, dir: dirBoardToHost, raw: rec.Data})
+		case dial.CaptureWrite:
+			writes = append(writes, chunk{rec.Time, rec.Data})
+			emit(&event{at: rec.Time, dir: dirHostToBoard, raw: rec.Data})
+		case dial.CaptureReadError:
+			emit(&event{at: rec.Time, dir: dirBoardToHost,
+				m: &message{name: "DISCONNECTED", text: string(rec.Data)}})
+		}
+	}
+
+	d := newDecoder()
+	decodeFrames(&chunkReader{ch: chunksOf(reads)}, d, emit)
+	decodeCommands(&chunkReader{ch: chunksOf(writes)}, d, emit)
+
+	sort.SliceStable(events, func(i, j int) bool { return events[i].at.Before(events[j].at) })
+	for _, e := range events {
+		p.print(e)
+	}
+	return nil
+}
+
+func chunksOf(chunks []chunk) <-chan chunk {
+	ch := make(chan chunk, len(chunks))
+	for _, c := range chunks {
+		ch <- c
+	}
+	close(ch)
+	return ch
+}
+
+func sniff(c *options, p *printer) error {
+	ctx := context.Background()
+	board, err := dial.Dial(ctx, c.Board)
+	if err != nil {
+		return err
+	}
+	defer board.Close()
+
+	var host io.ReadWriteCloser
+	if c.Listen != "" {
+		lis, err := net.Listen("tcp", c.Listen)
+		if err != nil {
+			return err
+		}
+		host, err = lis.Accept()
+		lis.Close()
+		if err != nil {
+			return err
+		}
+	} else {
+		host, err = dial.Dial(ctx, c.Host)
+		if err != nil {
+			return err
+		}
+	}
+	defer host.Close()
+
+	d := newDecoder()
+	writes := make(chan chunk, 256)
+	reads := make(chan chunk, 256)
+	go decodeCommands(&chunkReader{ch: writes}, d, p.print)
+	go decodeFrames(&chunkReader{ch: reads}, d, p.print)
+
+	errCh := make(chan error, 2)
+	go func() { errCh <- forward(board, host, p, dirHostToBoard, writes) }()
+	go func() { errCh <- forward(host, board, p, dirBoardToHost, reads) }()
+	return <-errCh
+}
+
+func forward(dst io.Writer, src io.Reader, p *printer, dir string, ch chan<- chunk) error {
+	buf := make([]byte, 512)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			if _, werr := dst.Write(buf[:n]); werr != nil {
+				return werr
+			}
+			c := chunk{at: time.Now(), data: append([]byte(nil), buf[:n]...)}
+			p.print(&event{at: c.at, dir: dir, raw: c.data})
+			// never block the traffic, the decoder resyncs after dropped bytes
+			select {
+			case ch <- c:
+			default:
+				p.print(&event{at: c.at, dir: dir, m: &message{name: "ERROR",
+					text: fmt.Sprintf("decoder behind, %d bytes not decoded", n)}})
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func main() {
+	err := run()
+	if err != nil {
+		log.Fatalln(err)
+	}
+}
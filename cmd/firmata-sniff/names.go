@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/empirefox/firmata/pkg/firmata"
+)
+
+var commandNames = map[byte]string{
+	firmata.DIGITAL_MESSAGE:       "DIGITAL_MESSAGE",
+	firmata.ANALOG_MESSAGE:        "ANALOG_MESSAGE",
+	firmata.REPORT_ANALOG:         "REPORT_ANALOG",
+	firmata.REPORT_DIGITAL:        "REPORT_DIGITAL",
+	firmata.SET_PIN_MODE:          "SET_PIN_MODE",
+	firmata.SET_DIGITAL_PIN_VALUE: "SET_DIGITAL_PIN_VALUE",
+	firmata.REPORT_VERSION:        "REPORT_VERSION",
+	firmata.SYSTEM_RESET:          "SYSTEM_RESET",
+}
+
+var sysexNames = map[byte]string{
+	firmata.UD_PIN_NAMES_REQUEST:    "UD_PIN_NAMES_REQUEST",
+	firmata.UD_PIN_NAMES_REPLY:      "UD_PIN_NAMES_REPLY",
+	firmata.UD_BOARD_SERIAL_REQUEST: "UD_BOARD_SERIAL_REQUEST",
+	firmata.UD_BOARD_SERIAL_REPLY:   "UD_BOARD_SERIAL_REPLY",
+	firmata.SERIAL_MESSAGE:          "SERIAL_MESSAGE",
+	firmata.ENCODER_DATA:            "ENCODER_DATA",
+	firmata.ACCELSTEPPER_DATA:       "ACCELSTEPPER_DATA",
+	firmata.REPORT_DIGITAL_PIN:      "REPORT_DIGITAL_PIN",
+	firmata.EXTENDED_REPORT_ANALOG:  "EXTENDED_REPORT_ANALOG",
+	firmata.REPORT_FEATURES:         "REPORT_FEATURES",
+	firmata.SPI_DATA:                "SPI_DATA",
+	firmata.ANALOG_MAPPING_QUERY:    "ANALOG_MAPPING_QUERY",
+	firmata.ANALOG_MAPPING_RESPONSE: "ANALOG_MAPPING_RESPONSE",
+	firmata.CAPABILITY_QUERY:        "CAPABILITY_QUERY",
+	firmata.CAPABILITY_RESPONSE:     "CAPABILITY_RESPONSE",
+	firmata.PIN_STATE_QUERY:         "PIN_STATE_QUERY",
+	firmata.PIN_STATE_RESPONSE:      "PIN_STATE_RESPONSE",
+	firmata.EXTENDED_ANALOG:         "EXTENDED_ANALOG",
+	firmata.SERVO_CONFIG:            "SERVO_CONFIG",
+	firmata.STRING_DATA:             "STRING_DATA",
+	firmata.STEPPER_DATA:            "STEPPER_DATA",
+	firmata.ONEWIRE_DATA:            "ONEWIRE_DATA",
+	firmata.DHTSENSOR_DATA:          "DHTSENSOR_DATA",
+	firmata.SHIFT_DATA:              "SHIFT_DATA",
+	firmata.I2C_REQUEST:             "I2C_REQUEST",
+	firmata.I2C_REPLY:               "I2C_REPLY",
+	firmata.I2C_CONFIG:              "I2C_CONFIG",
+	firmata.REPORT_FIRMWARE:         "REPORT_FIRMWARE",
+	firmata.SAMPLING_INTERVAL:       "SAMPLING_INTERVAL",
+	firmata.SCHEDULER_DATA:          "SCHEDULER_DATA",
+	firmata.ANALOG_CONFIG:           "ANALOG_CONFIG",
+	firmata.FREQUENCY_COMMAND:       "FREQUENCY_COMMAND",
+	firmata.SYSEX_NON_REALTIME:      "SYSEX_NON_REALTIME",
+	firmata.SYSEX_REALTIME:          "SYSEX_REALTIME",
+}
+
+var modeNames = map[byte]string{
+	firmata.PIN_MODE_INPUT:     "INPUT",
+	firmata.PIN_MODE_OUTPUT:    "OUTPUT",
+	firmata.PIN_MODE_ANALOG:    "ANALOG",
+	firmata.PIN_MODE_PWM:       "PWM",
+	firmata.PIN_MODE_SERVO:     "SERVO",
+	firmata.PIN_MODE_SHIFT:     "SHIFT",
+	firmata.PIN_MODE_I2C:       "I2C",
+	firmata.PIN_MODE_ONEWIRE:   "ONEWIRE",
+	firmata.PIN_MODE_STEPPER:   "STEPPER",
+	firmata.PIN_MODE_ENCODER:   "ENCODER",
+	firmata.PIN_MODE_SERIAL:    "SERIAL",
+	firmata.PIN_MODE_PULLUP:    "PULLUP",
+	firmata.PIN_MODE_SPI:       "SPI",
+	firmata.PIN_MODE_SONAR:     "SONAR",
+	firmata.PIN_MODE_TONE:      "TONE",
+	firmata.PIN_MODE_DHT:       "DHT",
+	firmata.PIN_MODE_FREQUENCY: "FREQUENCY",
+	firmata.PIN_MODE_IGNORE:    "IGNORE",
+}
+
+func nameOf(names map[byte]string, b byte) string {
+	if name, ok := names[b]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%02X", b)
+}
@@ -5,8 +5,12 @@ import (
 	"log"
 	"net"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"syscall"
+	"time"
 
+	"github.com/empirefox/firmata/pkg/firmata"
 	"github.com/empirefox/firmata/pkg/grpci"
 	"github.com/empirefox/firmata/pkg/pb"
 	"github.com/empirefox/firmata/pkg/pbload"
@@ -16,16 +20,18 @@ import (
 )
 
 type options struct {
-	JustLoadJson    bool   `env:"PLANET_JUST_LOAD_JSON"   short:"j" long:"just-load-json"                              description:"Print json then exit"`
-	Bind            string `env:"PLANET_BIND"             short:"b" long:"bind"             default:":2525"            description:"Bind address"`
-	BoardsDir       string `env:"PLANET_BOARDS_DIR"       short:"s" long:"boards"           default:"/var/planet"      description:"Boards directory"`
-	EtcDir          string `env:"PLANET_ETC_DIR"          short:"e" long:"etc-dir"          default:"/etc/planet"      description:"Etc directory"`
-	IntegrationName string `env:"PLANET_INTEGRATION_NAME" short:"i" long:"integration-name" default:"integration.json" description:"Integration file name under etc"`
-	ConfigName      string `env:"PLANET_CONFIG_NAME"      short:"c" long:"config-name"      default:"config.json"      description:"Config file name under etc"`
+	JustLoadJson    bool          `env:"PLANET_JUST_LOAD_JSON"   short:"j" long:"just-load-json"                              description:"Print json then exit"`
+	Bind            string        `env:"PLANET_BIND"             short:"b" long:"bind"             default:":2525"            description:"Bind address"`
+	BoardsDir       string        `env:"PLANET_BOARDS_DIR"       short:"s" long:"boards"           default:"/var/planet"      description:"Boards directory"`
+	EtcDir          string        `env:"PLANET_ETC_DIR"          short:"e" long:"etc-dir"          default:"/etc/planet"      description:"Etc directory"`
+	IntegrationName string        `env:"PLANET_INTEGRATION_NAME" short:"i" long:"integration-name" default:"integration.json" description:"Integration file name under etc"`
+	ConfigName      string        `env:"PLANET_CONFIG_NAME"      short:"c" long:"config-name"      default:"config.json"      description:"Config file name under etc"`
+	CacheDir        string        `env:"PLANET_CACHE_DIR"                  long:"cache-dir"        default:"/var/cache/planet" description:"Handshake cache directory, empty to disable"`
+	ShutdownTimeout time.Duration `env:"PLANET_SHUTDOWN_TIMEOUT"            long:"shutdown-timeout" default:"5s"               description:"Max time to drain RPCs on SIGTERM before closing boards"`
 }
 
 func run() error {
-	ctx, cancel := context.WithCancel(context.Background())
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer cancel()
 
 	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
@@ -68,17 +74,61 @@ func run() error {
 		return nil
 	}
 
-	lis, err := net.Listen("tcp", c.Bind)
+	lis, err := sdListener()
 	if err != nil {
 		return err
 	}
+	if lis == nil {
+		lis, err = net.Listen("tcp", c.Bind)
+		if err != nil {
+			return err
+		}
+	}
+
+	var serverOpts grpci.Options
+	if c.CacheDir != "" {
+		serverOpts.HandshakeCache = firmata.FileHandshakeCache{Dir: c.CacheDir}
+	}
 
 	var opts []grpc.ServerOption
 	grpcServer := grpc.NewServer(opts...)
-	pb.RegisterTransportServer(grpcServer, grpci.NewServer(ctx,
-		&logger, pbload.LoadApiVersion(), boards, integration, config,
-	))
-	return grpcServer.Serve(lis)
+	server := grpci.NewServer(ctx,
+		&logger, pbload.LoadApiVersion(), boards, integration, config, serverOpts,
+	)
+	pb.RegisterTransportServer(grpcServer, server)
+
+	served := make(chan error, 1)
+	go func() { served <- grpcServer.Serve(lis) }()
+	logger.Info().Str("listen", lis.Addr().String()).Msg("serving")
+
+	if err := sdNotify("READY=1"); err != nil {
+		logger.Warn().Err(err).Msg("sd_notify")
+	}
+	if interval := sdWatchdogInterval(); interval != 0 {
+		go sdWatchdog(ctx, interval, server.CheckHealth, &logger)
+	}
+
+	select {
+	case err = <-served:
+		cancel()
+	case <-ctx.Done():
+	}
+
+	logger.Info().Msg("shutting down")
+	sdNotify("STOPPING=1")
+	stopped := make(chan struct{})
+	go func() {
+		grpcServer.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(c.ShutdownTimeout):
+		logger.Warn().Msg("drain RPCs timeout")
+		grpcServer.Stop()
+	}
+	server.Shutdown()
+	return err
 }
 
 func main() {
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// sdNotify sends state like READY=1 to systemd if started by a Type=notify
+// unit. It does nothing otherwise.
+func sdNotify(state string) error {
+	addr := os.Getenv("NOTIFY_SOCKET")
+	if addr == "" {
+		return nil
+	}
+	if addr[0] == '@' {
+		// abstract namespace
+		addr = "\x00" + addr[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// sdWatchdogInterval returns the half of WatchdogSec, zero if the watchdog is
+// not enabled for this process.
+func sdWatchdogInterval() time.Duration {
+	pid := os.Getenv("WATCHDOG_PID")
+	if pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond / 2
+}
+
+// sdWatchdog pings the systemd watchdog until ctx is done. A ping is skipped
+// if healthy fails or does not return within interval, so systemd restarts a
+// stuck process.
+func sdWatchdog(ctx context.Context, interval time.Duration, healthy func(context.Context) error, log *zerolog.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		err := checkHealth(ctx, interval, healthy)
+		if err != nil {
+			log.Warn().Err(err).Msg("watchdog not pinged")
+			continue
+		}
+		sdNotify("WATCHDOG=1")
+	}
+}
+
+// checkHealth waits healthy at most timeout, healthy may block forever if
+// the process is stuck.
+func checkHealth(ctx context.Context, timeout time.Duration, healthy func(context.Context) error) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- healthy(ctx) }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// sdListener returns the first socket passed by systemd socket activation,
+// nil if not activated.
+func sdListener() (net.Listener, error) {
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return nil, nil
+	}
+
+	// SD_LISTEN_FDS_START
+	f := os.NewFile(3, "systemd-socket")
+	defer f.Close()
+	return net.FileListener(f)
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"gobot.io/x/gobot/gobottest"
+)
+
+// notifySocket listens on a unixgram socket and sets NOTIFY_SOCKET to it.
+func notifySocket(t *testing.T, name string) *net.UnixConn {
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: name, Net: "unixgram"})
+	gobottest.Assert(t, err, nil)
+	t.Cleanup(func() { conn.Close() })
+	t.Setenv("NOTIFY_SOCKET", name)
+	return conn
+}
+
+func readNotify(t *testing.T, conn *net.UnixConn, timeout time.Duration) (string, bool) {
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	b := make([]byte, 64)
+	n, err := conn.Read(b)
+	if err != nil {
+		return "", false
+	}
+	return string(b[:n]), true
+}
+
+func TestSdNotify(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	gobottest.Assert(t, sdNotify("READY=1"), nil)
+
+	conn := notifySocket(t, filepath.Join(t.TempDir(), "notify"))
+	gobottest.Assert(t, sdNotify("READY=1"), nil)
+	state, ok := readNotify(t, conn, time.Second)
+	gobottest.Assert(t, ok, true)
+	gobottest.Assert(t, state, "READY=1")
+
+	// abstract namespace
+	conn = notifySocket(t, "@planet-test-"+strconv.Itoa(os.Getpid()))
+	gobottest.Assert(t, sdNotify("STOPPING=1"), nil)
+	state, ok = readNotify(t, conn, time.Second)
+	gobottest.Assert(t, ok, true)
+	gobottest.Assert(t, state, "STOPPING=1")
+}
+
+func TestSdWatchdogInterval(t *testing.T) {
+	pid := strconv.Itoa(os.Getpid())
+	cases := []struct {
+		usec, pid string
+		interval  time.Duration
+	}{
+		{"", "", 0},
+		{"30000000", "", 15 * time.Second},
+		{"30000000", pid, 15 * time.Second},
+		{"30000000", "1", 0},
+		{"0", "", 0},
+		{"x", "", 0},
+	}
+	for _, c := range cases {
+		t.Setenv("WATCHDOG_USEC", c.usec)
+		t.Setenv("WATCHDOG_PID", c.pid)
+		if got := sdWatchdogInterval(); got != c.interval {
+			t.Errorf("usec %q pid %q: got %v, want %v", c.usec, c.pid, got, c.interval)
+		}
+	}
+}
+
+func TestSdWatchdog(t *testing.T) {
+	conn := notifySocket(t, filepath.Join(t.TempDir(), "notify"))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var healthy int32 = 1
+	log := zerolog.Nop()
+	go sdWatchdog(ctx, 10*time.Millisecond, func(ctx context.Context) error {
+		switch atomic.LoadInt32(&healthy) {
+		case 1:
+			return nil
+		case 0:
+			return errors.New("unhealthy")
+		}
+		// stuck
+		<-ctx.Done()
+		return nil
+	}, &log)
+
+	state, ok := readNotify(t, conn, time.Second)
+	gobottest.Assert(t, ok, true)
+	gobottest.Assert(t, state, "WATCHDOG=1")
+
+	for _, h := range []int32{0, 2} {
+		atomic.StoreInt32(&healthy, h)
+		// drain a ping sent before the change
+		readNotify(t, conn, 30*time.Millisecond)
+		_, ok = readNotify(t, conn, 100*time.Millisecond)
+		gobottest.Assert(t, ok, false)
+	}
+
+	atomic.StoreInt32(&healthy, 1)
+	_, ok = readNotify(t, conn, time.Second)
+	gobottest.Assert(t, ok, true)
+}
+
+func TestSdListener(t *testing.T) {
+	if os.Getenv("PLANET_TEST_SD_LISTENER") != "" {
+		// the child activated with fd 3
+		os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+		lis, err := sdListener()
+		if err != nil || lis == nil {
+			t.Fatalf("not activated: %v", err)
+		}
+		defer lis.Close()
+		if lis.Addr().String() != os.Getenv("PLANET_TEST_SD_LISTENER") {
+			t.Fatalf("got %s", lis.Addr())
+		}
+		if os.Getenv("LISTEN_FDS") != "" {
+			t.Fatal("LISTEN_FDS is not unset")
+		}
+		return
+	}
+
+	// not activated for another pid
+	t.Setenv("LISTEN_PID", "1")
+	t.Setenv("LISTEN_FDS", "1")
+	lis, err := sdListener()
+	gobottest.Assert(t, err, nil)
+	gobottest.Assert(t, lis, nil)
+	gobottest.Assert(t, os.Getenv("LISTEN_PID"), "")
+
+	tl, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	gobottest.Assert(t, err, nil)
+	defer tl.Close()
+	f, err := tl.File()
+	gobottest.Assert(t, err, nil)
+	defer f.Close()
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestSdListener$")
+	cmd.Env = append(os.Environ(), "LISTEN_FDS=1", "PLANET_TEST_SD_LISTENER="+tl.Addr().String())
+	cmd.ExtraFiles = []*os.File{f}
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+}
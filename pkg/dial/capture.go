@@ -0,0 +1,189 @@
+package dial
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Capture file format:
+//
+//	"FCAP" version(1) start(8, unix nanoseconds, big endian)
+//	records of: dir(1) delta(uvarint, microseconds since the previous record)
+//	            len(uvarint) data(len)
+const (
+	captureMagic   = "FCAP"
+	captureVersion = 1
+)
+
+const (
+	// CaptureRead is the board to host direction.
+	CaptureRead byte = 0
+	// CaptureWrite is the host to board direction.
+	CaptureWrite byte = 1
+	// CaptureReadError records the error which broke the link, data is the
+	// error message.
+	CaptureReadError byte = 2
+)
+
+var ErrInvalidCapture = errors.New("invalid capture file")
+
+type CaptureRecord struct {
+	Dir  byte
+	Time time.Time
+	Data []byte
+}
+
+// CaptureConn records both directions of c to w.
+type CaptureConn struct {
+	c io.ReadWriteCloser
+	w io.WriteCloser
+
+	mu   sync.Mutex
+	bw   *bufio.Writer
+	last time.Time
+	err  error
+	// closed stops recording, errors after Close are not link errors
+	closed bool
+}
+
+// NewCaptureFile creates the capture file at path for c.
+func NewCaptureFile(c io.ReadWriteCloser, path string) (*CaptureConn, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return NewCaptureConn(c, file)
+}
+
+func NewCaptureConn(c io.ReadWriteCloser, w io.WriteCloser) (*CaptureConn, error) {
+	cc := &CaptureConn{
+		c:    c,
+		w:    w,
+		bw:   bufio.NewWriter(w),
+		last: time.Now(),
+	}
+
+	var header [len(captureMagic) + 9]byte
+	copy(header[:], captureMagic)
+	header[len(captureMagic)] = captureVersion
+	binary.BigEndian.PutUint64(header[len(captureMagic)+1:], uint64(cc.last.UnixNano()))
+	cc.bw.Write(header[:])
+	if err := cc.bw.Flush(); err != nil {
+		w.Close()
+		return nil, err
+	}
+	return cc, nil
+}
+
+func (cc *CaptureConn) Read(p []byte) (int, error) {
+	n, err := cc.c.Read(p)
+	if n > 0 {
+		cc.record(CaptureRead, p[:n])
+	}
+	if err != nil {
+		cc.record(CaptureReadError, []byte(err.Error()))
+	}
+	return n, err
+}
+
+func (cc *CaptureConn) Write(p []byte) (int, error) {
+	n, err := cc.c.Write(p)
+	if n > 0 {
+		cc.record(CaptureWrite, p[:n])
+	}
+	return n, err
+}
+
+// Unwrap returns the captured conn, the line control is not captured.
+func (cc *CaptureConn) Unwrap() io.ReadWriteCloser { return cc.c }
+
+func (cc *CaptureConn) Close() error {
+	cc.mu.Lock()
+	if !cc.closed {
+		cc.closed = true
+		cc.bw.Flush()
+		cc.w.Close()
+	}
+	cc.mu.Unlock()
+	return cc.c.Close()
+}
+
+// CaptureErr returns the first error of writing the capture, the link is
+// never broken by capturing.
+func (cc *CaptureConn) CaptureErr() error {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	return cc.err
+}
+
+func (cc *CaptureConn) record(dir byte, data []byte) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if cc.err != nil || cc.closed {
+		return
+	}
+
+	// truncated delta is accumulated to last, so no drift on reading
+	delta := time.Since(cc.last) / time.Microsecond
+	cc.last = cc.last.Add(delta * time.Microsecond)
+
+	var buf [1 + 2*binary.MaxVarintLen64]byte
+	buf[0] = dir
+	n := 1
+	n += binary.PutUvarint(buf[n:], uint64(delta))
+	n += binary.PutUvarint(buf[n:], uint64(len(data)))
+
+	cc.bw.Write(buf[:n])
+	cc.bw.Write(data)
+	cc.err = cc.bw.Flush()
+}
+
+// CaptureReader reads records of a capture file.
+type CaptureReader struct {
+	r    *bufio.Reader
+	last time.Time
+}
+
+func NewCaptureReader(r io.Reader) (*CaptureReader, error) {
+	br := bufio.NewReader(r)
+	var header [len(captureMagic) + 9]byte
+	if _, err := io.ReadFull(br, header[:]); err != nil {
+		return nil, ErrInvalidCapture
+	}
+	if string(header[:len(captureMagic)]) != captureMagic {
+		return nil, ErrInvalidCapture
+	}
+	if v := header[len(captureMagic)]; v != captureVersion {
+		return nil, fmt.Errorf("unsupported capture version: %d", v)
+	}
+	start := int64(binary.BigEndian.Uint64(header[len(captureMagic)+1:]))
+	return &CaptureReader{r: br, last: time.Unix(0, start)}, nil
+}
+
+// Next returns io.EOF after the last record.
+func (cr *CaptureReader) Next() (*CaptureRecord, error) {
+	dir, err := cr.r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	delta, err := binary.ReadUvarint(cr.r)
+	if err != nil {
+		return nil, ErrInvalidCapture
+	}
+	size, err := binary.ReadUvarint(cr.r)
+	if err != nil || size > 1<<20 {
+		return nil, ErrInvalidCapture
+	}
+	data := make([]byte, size)
+	if _, err = io.ReadFull(cr.r, data); err != nil {
+		return nil, ErrInvalidCapture
+	}
+	cr.last = cr.last.Add(time.Duration(delta) * time.Microsecond)
+	return &CaptureRecord{Dir: dir, Time: cr.last, Data: data}, nil
+}
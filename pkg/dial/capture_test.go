@@ -0,0 +1,72 @@
+package dial
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gobot.io/x/gobot/gobottest"
+)
+
+func TestCaptureReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.fcap")
+	host, board := net.Pipe()
+	cc, err := NewCaptureFile(host, path)
+	gobottest.Assert(t, err, nil)
+
+	go func() {
+		board.Write([]byte("abc"))
+		io.ReadFull(board, make([]byte, 3))
+		board.Close()
+	}()
+	b := make([]byte, 3)
+	_, err = io.ReadFull(cc, b)
+	gobottest.Assert(t, err, nil)
+	_, err = cc.Write([]byte("xyz"))
+	gobottest.Assert(t, err, nil)
+	_, err = cc.Read(b)
+	gobottest.Refute(t, err, nil)
+	cc.Close()
+	gobottest.Assert(t, cc.CaptureErr(), nil)
+
+	file, err := os.Open(path)
+	gobottest.Assert(t, err, nil)
+	defer file.Close()
+	cr, err := NewCaptureReader(file)
+	gobottest.Assert(t, err, nil)
+	for _, want := range []struct {
+		dir  byte
+		data string
+	}{{CaptureRead, "abc"}, {CaptureWrite, "xyz"}} {
+		rec, err := cr.Next()
+		gobottest.Assert(t, err, nil)
+		gobottest.Assert(t, rec.Dir, want.dir)
+		gobottest.Assert(t, string(rec.Data), want.data)
+	}
+	rec, err := cr.Next()
+	gobottest.Assert(t, err, nil)
+	gobottest.Assert(t, rec.Dir, CaptureReadError)
+	_, err = cr.Next()
+	gobottest.Assert(t, err, io.EOF)
+
+	// the board side only, and the link error
+	rc, err := Dial(context.Background(), "replay://"+path+"?speed=0")
+	gobottest.Assert(t, err, nil)
+	defer rc.Close()
+	n, err := rc.Write([]byte("discarded"))
+	gobottest.Assert(t, err, nil)
+	gobottest.Assert(t, n, 9)
+	replayed, err := io.ReadAll(rc)
+	gobottest.Assert(t, err, nil)
+	gobottest.Assert(t, string(replayed), "abc")
+}
+
+func TestCaptureInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bad.fcap")
+	gobottest.Assert(t, os.WriteFile(path, []byte("NOPE"), 0644), nil)
+	_, err := NewReplayFile(path, 0)
+	gobottest.Assert(t, err, ErrInvalidCapture)
+}
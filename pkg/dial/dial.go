@@ -7,6 +7,7 @@ import (
 	"net"
 	"net/url"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/tarm/serial"
@@ -14,33 +15,47 @@ import (
 
 // Dial accepts p like:
 // tcp://x.x.x.x:xxx?timeout=2s&keep_alive=1 or
+// udp://x.x.x.x:xxx?timeout=2s or
+// unix:///run/firmata.sock?timeout=2s or
+// tls://x.x.x.x:xxx?ca=ca.pem&cert=client.pem&key=client.key&server_name=x&timeout=2s or
+// ws://x.x.x.x:xxx/path?timeout=2s&keep_alive=1, wss:// accepts the tls params too or
+// rfc2217://x.x.x.x:xxx?baud=57600&size=8&parity=N&stop_bit=1&timeout=2s or
 // serial:///dev/ttyUSB0?baud=4800&size=8&parity=N&stop_bit=1&timeout=2s or
 // serial:///dev/serial/by-path/pci-0000:00:1a.0-usb-0:1.2:1.0-port0?baud=4800
 // by `udevadm info /dev/ttyUSB0`
+// usb://?vid=0483&pid=5740&serial=XYZ&baud=115200 resolves the tty by sysfs,
+// it accepts the serial params too.
+// serial://, usb:// and rfc2217:// accept line params
+// dtr=1&rts=0&flow=rtscts&reset_pulse=100ms, see LineControl.
+// tcp-listen://:3030?expect=<firmware name>&peer=<ip>&timeout=1m accepts a
+// board which connects out, the listener is shared by the same address.
+// replay:///path/to/file.fcap?speed=2 plays a capture of NewCaptureFile back.
+// Any scheme prefixed with fault+ is wrapped in a FaultConn, like:
+// fault+tcp://x.x.x.x:xxx?seed=1&drop=0.001&disconnect_after=4096
+// Other schemes can be added by Register.
 func Dial(ctx context.Context, p string) (io.ReadWriteCloser, error) {
 	u, err := parseDialAddr(p)
 	if err != nil {
 		return nil, err
 	}
 
-	switch u.Scheme {
-	case "tcp":
-		c, err := toTCP(u)
+	if strings.HasPrefix(u.Scheme, faultSchemePrefix) {
+		inner, faults, err := splitFault(u)
 		if err != nil {
 			return nil, err
 		}
-
-		d := net.Dialer{Timeout: c.timeout, KeepAlive: c.keepAlive}
-		return d.DialContext(ctx, "tcp", c.addr)
-	case "serial":
-		c, err := toSerial(u)
+		c, err := Dial(ctx, inner.String())
 		if err != nil {
 			return nil, err
 		}
-		return serial.OpenPort(c)
-	default:
-		return nil, fmt.Errorf("schema not support: %s", u.Scheme)
+		return NewFaultConn(c, faults), nil
 	}
+
+	t, err := lookup(u.Scheme)
+	if err != nil {
+		return nil, err
+	}
+	return t.dial(ctx, u)
 }
 
 func parseDialAddr(p string) (*url.URL, error) {
@@ -65,15 +80,21 @@ func toTCP(u *url.URL) (*tcpConfig, error) {
 		timeout:   0,
 		keepAlive: 0,
 	}
+	err := c.parseTimeouts(u)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
 
-	var err error
+func (c *tcpConfig) parseTimeouts(u *url.URL) (err error) {
 	q := u.Query()
 
 	v := q.Get("timeout")
 	if v != "" {
 		c.timeout, err = time.ParseDuration(v)
 		if err != nil {
-			return nil, fmt.Errorf("invalid timeout: %v", u)
+			return fmt.Errorf("invalid timeout: %v", u)
 		}
 	}
 
@@ -81,11 +102,14 @@ func toTCP(u *url.URL) (*tcpConfig, error) {
 	if v != "" {
 		c.keepAlive, err = time.ParseDuration(v)
 		if err != nil {
-			return nil, fmt.Errorf("invalid keep_alive: %v", u)
+			return fmt.Errorf("invalid keep_alive: %v", u)
 		}
 	}
+	return nil
+}
 
-	return &c, nil
+func (c *tcpConfig) dialer() *net.Dialer {
+	return &net.Dialer{Timeout: c.timeout, KeepAlive: c.keepAlive}
 }
 
 func toSerial(u *url.URL) (c *serial.Config, err error) {
@@ -0,0 +1,204 @@
+package dial
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const faultSchemePrefix = "fault+"
+
+// ErrInjectedDisconnect is returned after Faults.DisconnectAfter bytes read.
+var ErrInjectedDisconnect = errors.New("fault: injected disconnect")
+
+// bootingFrame is the STRING_DATA "Booting" sent by a resetting board.
+var bootingFrame = []byte{0xF0, 0x71,
+	'B', 0, 'o', 0, 'o', 0, 't', 0, 'i', 0, 'n', 0, 'g', 0,
+	0xF7}
+
+// Faults is a seeded script of link faults. Rates are per byte except
+// PartialWrite which is per Write. The same Seed with the same traffic always
+// injects the same faults.
+type Faults struct {
+	Seed int64
+	// Latency is added before every Read and Write, plus [0, Jitter).
+	Latency time.Duration
+	Jitter  time.Duration
+	// Drop, Flip and Booting apply to read bytes.
+	Drop    float64
+	Flip    float64
+	Booting float64
+	// PartialWrite writes a random prefix and fails with io.ErrShortWrite.
+	PartialWrite float64
+	// DisconnectAfter closes the link after that many bytes read if > 0,
+	// mostly in the middle of a frame.
+	DisconnectAfter int64
+}
+
+// FaultConn injects Faults into c.
+type FaultConn struct {
+	c      io.ReadWriteCloser
+	faults Faults
+
+	// every direction has its own rands, so the faults of one direction do
+	// not depend on the timing of the other
+	readMu          sync.Mutex
+	readRand        *rand.Rand
+	readLatencyRand *rand.Rand
+	pending         []byte
+	buf             []byte
+	read            int64
+
+	writeMu          sync.Mutex
+	writeRand        *rand.Rand
+	writeLatencyRand *rand.Rand
+}
+
+func NewFaultConn(c io.ReadWriteCloser, faults *Faults) *FaultConn {
+	return &FaultConn{
+		c:                c,
+		faults:           *faults,
+		readRand:         rand.New(rand.NewSource(faults.Seed)),
+		readLatencyRand:  rand.New(rand.NewSource(faults.Seed + 2)),
+		writeRand:        rand.New(rand.NewSource(faults.Seed + 1)),
+		writeLatencyRand: rand.New(rand.NewSource(faults.Seed + 3)),
+		buf:              make([]byte, 256),
+	}
+}
+
+func (fc *FaultConn) Read(p []byte) (int, error) {
+	fc.readMu.Lock()
+	defer fc.readMu.Unlock()
+	fc.delay(fc.readLatencyRand)
+
+	if fc.faults.DisconnectAfter > 0 && fc.read >= fc.faults.DisconnectAfter {
+		fc.c.Close()
+		return 0, ErrInjectedDisconnect
+	}
+
+	for len(fc.pending) == 0 {
+		n, err := fc.c.Read(fc.buf)
+		for _, b := range fc.buf[:n] {
+			if fc.readRand.Float64() < fc.faults.Booting {
+				fc.pending = append(fc.pending, bootingFrame...)
+			}
+			if fc.readRand.Float64() < fc.faults.Drop {
+				continue
+			}
+			if fc.readRand.Float64() < fc.faults.Flip {
+				b ^= 1 << uint(fc.readRand.Intn(8))
+			}
+			fc.pending = append(fc.pending, b)
+		}
+		if err != nil && len(fc.pending) == 0 {
+			return 0, err
+		}
+	}
+
+	if fc.faults.DisconnectAfter > 0 {
+		if left := fc.faults.DisconnectAfter - fc.read; int64(len(p)) > left {
+			p = p[:left]
+		}
+	}
+	n := copy(p, fc.pending)
+	fc.pending = fc.pending[n:]
+	fc.read += int64(n)
+	return n, nil
+}
+
+func (fc *FaultConn) Write(p []byte) (int, error) {
+	fc.writeMu.Lock()
+	defer fc.writeMu.Unlock()
+	fc.delay(fc.writeLatencyRand)
+
+	if len(p) > 1 && fc.writeRand.Float64() < fc.faults.PartialWrite {
+		n, err := fc.c.Write(p[:fc.writeRand.Intn(len(p))])
+		if err != nil {
+			return n, err
+		}
+		return n, io.ErrShortWrite
+	}
+	return fc.c.Write(p)
+}
+
+// Unwrap returns the conn without faults.
+func (fc *FaultConn) Unwrap() io.ReadWriteCloser { return fc.c }
+
+func (fc *FaultConn) Close() error { return fc.c.Close() }
+
+// delay must be called with the lock of r.
+func (fc *FaultConn) delay(r *rand.Rand) {
+	if fc.faults.Latency <= 0 && fc.faults.Jitter <= 0 {
+		return
+	}
+	d := fc.faults.Latency
+	if fc.faults.Jitter > 0 {
+		d += time.Duration(r.Int63n(int64(fc.faults.Jitter)))
+	}
+	time.Sleep(d)
+}
+
+// splitFault parses fault+<scheme>://...?seed=1&drop=0.01 into the inner url
+// and the Faults. Fault params are removed from the inner url.
+func splitFault(u *url.URL) (*url.URL, *Faults, error) {
+	inner := *u
+	inner.Scheme = strings.TrimPrefix(u.Scheme, faultSchemePrefix)
+	q := u.Query()
+
+	var f Faults
+	var err error
+	parseFloat := func(key string, dst *float64) {
+		v := q.Get(key)
+		q.Del(key)
+		if v == "" || err != nil {
+			return
+		}
+		*dst, err = strconv.ParseFloat(v, 64)
+		if err != nil {
+			err = fmt.Errorf("invalid %s: %v", key, u)
+		}
+	}
+	parseDuration := func(key string, dst *time.Duration) {
+		v := q.Get(key)
+		q.Del(key)
+		if v == "" || err != nil {
+			return
+		}
+		*dst, err = time.ParseDuration(v)
+		if err != nil {
+			err = fmt.Errorf("invalid %s: %v", key, u)
+		}
+	}
+	parseInt := func(key string, dst *int64) {
+		v := q.Get(key)
+		q.Del(key)
+		if v == "" || err != nil {
+			return
+		}
+		*dst, err = strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			err = fmt.Errorf("invalid %s: %v", key, u)
+		}
+	}
+
+	parseInt("seed", &f.Seed)
+	parseDuration("latency", &f.Latency)
+	parseDuration("jitter", &f.Jitter)
+	parseFloat("drop", &f.Drop)
+	parseFloat("flip", &f.Flip)
+	parseFloat("booting", &f.Booting)
+	parseFloat("partial_write", &f.PartialWrite)
+	parseInt("disconnect_after", &f.DisconnectAfter)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	inner.RawQuery = q.Encode()
+	return &inner, &f, nil
+}
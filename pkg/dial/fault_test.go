@@ -0,0 +1,61 @@
+package dial
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/url"
+	"testing"
+
+	"gobot.io/x/gobot/gobottest"
+)
+
+func TestFaultDisconnect(t *testing.T) {
+	host, board := net.Pipe()
+	fc := NewFaultConn(host, &Faults{Seed: 1, DisconnectAfter: 20})
+	go board.Write(bytes.Repeat([]byte{0x55}, 64))
+
+	b, err := io.ReadAll(fc)
+	gobottest.Assert(t, err, ErrInjectedDisconnect)
+	gobottest.Assert(t, len(b), 20)
+}
+
+func TestFaultDeterministic(t *testing.T) {
+	corrupted := func() []byte {
+		host, board := net.Pipe()
+		fc := NewFaultConn(host, &Faults{Seed: 7, Flip: 0.2, Drop: 0.1})
+		go func() {
+			board.Write(bytes.Repeat([]byte{0x55}, 64))
+			board.Close()
+		}()
+		b, _ := io.ReadAll(fc)
+		return b
+	}
+	b := corrupted()
+	gobottest.Assert(t, b, corrupted())
+	gobottest.Refute(t, b, bytes.Repeat([]byte{0x55}, 64))
+}
+
+func TestFaultPartialWrite(t *testing.T) {
+	host, board := net.Pipe()
+	fc := NewFaultConn(host, &Faults{PartialWrite: 1})
+	go io.Copy(io.Discard, board)
+
+	n, err := fc.Write([]byte{1, 2, 3, 4})
+	gobottest.Assert(t, err, io.ErrShortWrite)
+	gobottest.Assert(t, n < 4, true)
+}
+
+func TestSplitFault(t *testing.T) {
+	u, _ := url.Parse("fault+tcp://127.0.0.1:3030?seed=3&drop=0.1&jitter=5ms&timeout=2s")
+	inner, f, err := splitFault(u)
+	gobottest.Assert(t, err, nil)
+	gobottest.Assert(t, inner.String(), "tcp://127.0.0.1:3030?timeout=2s")
+	gobottest.Assert(t, f.Seed, int64(3))
+	gobottest.Assert(t, f.Drop, 0.1)
+	gobottest.Assert(t, f.Jitter.String(), "5ms")
+
+	u, _ = url.Parse("fault+tcp://127.0.0.1:3030?drop=x")
+	_, _, err = splitFault(u)
+	gobottest.Refute(t, err, nil)
+}
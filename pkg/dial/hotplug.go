@@ -0,0 +1,163 @@
+package dial
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrDeviceAbsent is returned by Device when the tty of a device dial is not
+// plugged in.
+var ErrDeviceAbsent = errors.New("device absent")
+
+// HotplugSettle is the max time to wait the /dev node and udev links after
+// the kernel reports a new tty.
+var HotplugSettle = 2 * time.Second
+
+type HotplugAction int
+
+const (
+	HotplugAdd HotplugAction = iota
+	HotplugRemove
+)
+
+func (a HotplugAction) String() string {
+	if a == HotplugAdd {
+		return "add"
+	}
+	return "remove"
+}
+
+// HotplugEvent reports a tty like /dev/ttyACM0 is added or removed.
+type HotplugEvent struct {
+	Action HotplugAction
+	Device string
+}
+
+// IsDeviceDial returns true if p dials a local tty which can be hotplugged.
+func IsDeviceDial(p string) bool {
+	u, err := parseDialAddr(p)
+	if err != nil {
+		return false
+	}
+	for strings.HasPrefix(u.Scheme, faultSchemePrefix) {
+		u, _, err = splitFault(u)
+		if err != nil {
+			return false
+		}
+	}
+	return u.Scheme == "serial" || u.Scheme == "usb"
+}
+
+// Device resolves the tty of a serial:// or usb:// dial to the /dev node,
+// ErrDeviceAbsent is wrapped if it is not plugged in. Other dials return "".
+func Device(p string) (string, error) {
+	u, err := parseDialAddr(p)
+	if err != nil {
+		return "", err
+	}
+	for strings.HasPrefix(u.Scheme, faultSchemePrefix) {
+		u, _, err = splitFault(u)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	switch u.Scheme {
+	case "serial":
+		c, err := toSerial(u)
+		if err != nil {
+			return "", err
+		}
+		dev, err := filepath.EvalSymlinks(c.Name)
+		if os.IsNotExist(err) {
+			return "", fmt.Errorf("%w: %s", ErrDeviceAbsent, c.Name)
+		}
+		return dev, err
+	case "usb":
+		su, err := resolveUSB(SysfsRoot, u)
+		if err != nil {
+			return "", err
+		}
+		return su.Path, nil
+	}
+	return "", nil
+}
+
+// PollHotplug diffs the usb ttys under SysfsRoot every interval. It is the
+// fallback of WatchUevent.
+func PollHotplug(ctx context.Context, interval time.Duration) <-chan HotplugEvent {
+	return pollHotplug(ctx, SysfsRoot, interval)
+}
+
+func pollHotplug(ctx context.Context, root string, interval time.Duration) <-chan HotplugEvent {
+	events := make(chan HotplugEvent, 16)
+	last := listTTYs(root)
+	go func() {
+		defer close(events)
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+				return
+			}
+			current := listTTYs(root)
+			for dev := range last {
+				if !current[dev] && !sendHotplug(ctx, events, HotplugRemove, dev) {
+					return
+				}
+			}
+			for dev := range current {
+				if !last[dev] && !sendHotplug(ctx, events, HotplugAdd, dev) {
+					return
+				}
+			}
+			last = current
+		}
+	}()
+	return events
+}
+
+func listTTYs(root string) map[string]bool {
+	all, _ := listUSBSerial(root)
+	ttys := make(map[string]bool, len(all))
+	for _, d := range all {
+		ttys[d.Device] = true
+	}
+	return ttys
+}
+
+func sendHotplug(ctx context.Context, events chan<- HotplugEvent, action HotplugAction, dev string) bool {
+	select {
+	case events <- HotplugEvent{Action: action, Device: dev}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// waitDevNode waits udev to create the node of dev after the kernel added it.
+func waitDevNode(ctx context.Context, dev string) {
+	deadline := time.Now().Add(HotplugSettle)
+	for time.Now().Before(deadline) {
+		if _, err := os.Stat(dev); err == nil {
+			break
+		}
+		select {
+		case <-time.After(50 * time.Millisecond):
+		case <-ctx.Done():
+			return
+		}
+	}
+	// links like /dev/serial/by-path are created by the same udev event
+	select {
+	case <-time.After(100 * time.Millisecond):
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,95 @@
+package dial
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// WatchUevent listens the kernel uevents of tty by netlink.
+func WatchUevent(ctx context.Context) (<-chan HotplugEvent, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_KOBJECT_UEVENT)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	err = syscall.Bind(fd, &syscall.SockaddrNetlink{
+		Family: syscall.AF_NETLINK,
+		Groups: 1,
+	})
+	if err == nil {
+		// let the runtime poller unblock Read when closed
+		err = syscall.SetNonblock(fd, true)
+	}
+	if err != nil {
+		syscall.Close(fd)
+		return nil, os.NewSyscallError("netlink", err)
+	}
+	f := os.NewFile(uintptr(fd), "uevent")
+
+	events := make(chan HotplugEvent, 16)
+	go func() {
+		<-ctx.Done()
+		f.Close()
+	}()
+	go func() {
+		defer close(events)
+		buf := make([]byte, 8192)
+		for {
+			n, err := f.Read(buf)
+			if err != nil {
+				return
+			}
+			action, dev, ok := parseUevent(buf[:n])
+			if !ok {
+				continue
+			}
+			if action == HotplugAdd {
+				go func() {
+					waitDevNode(ctx, dev)
+					sendHotplug(ctx, events, action, dev)
+				}()
+				continue
+			}
+			if !sendHotplug(ctx, events, action, dev) {
+				return
+			}
+		}
+	}()
+	return events, nil
+}
+
+// parseUevent parses "add@/devices/...\0ACTION=add\0SUBSYSTEM=tty\0DEVNAME=ttyACM0\0".
+func parseUevent(b []byte) (action HotplugAction, dev string, ok bool) {
+	var actionName, subsystem, devName string
+	for _, field := range bytes.Split(b, []byte{0}) {
+		kv := bytes.SplitN(field, []byte{'='}, 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch string(kv[0]) {
+		case "ACTION":
+			actionName = string(kv[1])
+		case "SUBSYSTEM":
+			subsystem = string(kv[1])
+		case "DEVNAME":
+			devName = string(kv[1])
+		}
+	}
+	if subsystem != "tty" || devName == "" {
+		return 0, "", false
+	}
+	switch actionName {
+	case "add":
+		action = HotplugAdd
+	case "remove":
+		action = HotplugRemove
+	default:
+		return 0, "", false
+	}
+	if !filepath.IsAbs(devName) {
+		devName = filepath.Join("/dev", devName)
+	}
+	return action, devName, true
+}
@@ -0,0 +1,19 @@
+package dial
+
+import (
+	"testing"
+
+	"gobot.io/x/gobot/gobottest"
+)
+
+func TestParseUevent(t *testing.T) {
+	action, dev, ok := parseUevent([]byte("add@/devices/pci0000:00/usb1/1-1/1-1:1.0/tty/ttyACM0\x00" +
+		"ACTION=add\x00DEVPATH=/devices/pci0000:00/usb1/1-1/1-1:1.0/tty/ttyACM0\x00" +
+		"SUBSYSTEM=tty\x00MAJOR=166\x00MINOR=0\x00DEVNAME=ttyACM0\x00SEQNUM=4711\x00"))
+	gobottest.Assert(t, ok, true)
+	gobottest.Assert(t, action, HotplugAdd)
+	gobottest.Assert(t, dev, "/dev/ttyACM0")
+
+	_, _, ok = parseUevent([]byte("remove@/devices/pci0000:00/usb1/1-1\x00ACTION=remove\x00SUBSYSTEM=usb\x00DEVNAME=bus/usb/001/002\x00"))
+	gobottest.Assert(t, ok, false)
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package dial
+
+import (
+	"context"
+	"errors"
+)
+
+// WatchUevent is only supported on linux, use PollHotplug instead.
+func WatchUevent(ctx context.Context) (<-chan HotplugEvent, error) {
+	return nil, errors.New("uevent not supported")
+}
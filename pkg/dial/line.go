@@ -0,0 +1,128 @@
+package dial
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// DefaultResetPulse is the low time of DTR and RTS when resetting a board
+// without reset_pulse.
+const DefaultResetPulse = 100 * time.Millisecond
+
+// LineControl drives the modem lines of a serial port. It is implemented by
+// the conns of rfc2217://, and serial:// and usb:// on linux. Wrappers like
+// CaptureConn expose the wrapped conn by Unwrap.
+type LineControl interface {
+	SetDTR(on bool) error
+	SetRTS(on bool) error
+	Break(ctx context.Context, d time.Duration) error
+	// ResetLine pulses DTR and RTS low like the auto reset circuit of Arduino.
+	ResetLine(ctx context.Context) error
+}
+
+// lineConfig of dtr=1&rts=0&flow=rtscts&reset_pulse=100ms, nil dtr or rts
+// keeps the line as opened.
+type lineConfig struct {
+	dtr        *bool
+	rts        *bool
+	rtscts     bool
+	resetPulse time.Duration
+}
+
+func toLineConfig(u *url.URL) (*lineConfig, error) {
+	q := u.Query()
+	c := lineConfig{resetPulse: DefaultResetPulse}
+
+	for _, line := range []struct {
+		name string
+		on   **bool
+	}{{"dtr", &c.dtr}, {"rts", &c.rts}} {
+		v := q.Get(line.name)
+		if v == "" {
+			continue
+		}
+		on, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", line.name, u)
+		}
+		*line.on = &on
+	}
+
+	switch q.Get("flow") {
+	case "", "none":
+	case "rtscts":
+		c.rtscts = true
+	default:
+		return nil, fmt.Errorf("invalid flow: %v", u)
+	}
+	if c.rtscts && c.rts != nil {
+		return nil, fmt.Errorf("rts is driven by flow=rtscts: %v", u)
+	}
+
+	v := q.Get("reset_pulse")
+	if v != "" {
+		var err error
+		c.resetPulse, err = time.ParseDuration(v)
+		if err != nil || c.resetPulse <= 0 {
+			return nil, fmt.Errorf("invalid reset_pulse: %v", u)
+		}
+	}
+	return &c, nil
+}
+
+// apply sets the configured lines after opening.
+func (c *lineConfig) apply(lc LineControl) error {
+	if c.dtr != nil {
+		err := lc.SetDTR(*c.dtr)
+		if err != nil {
+			return err
+		}
+	}
+	if c.rts != nil {
+		return lc.SetRTS(*c.rts)
+	}
+	return nil
+}
+
+// resetLine pulls DTR, and RTS if not used by flow control, low for
+// resetPulse, then restores them to the configured or asserted state.
+func (c *lineConfig) resetLine(ctx context.Context, lc LineControl) error {
+	err := lc.SetDTR(false)
+	if err != nil {
+		return err
+	}
+	if !c.rtscts {
+		err = lc.SetRTS(false)
+		if err != nil {
+			return err
+		}
+	}
+
+	// restore the lines even if canceled
+	waitErr := sleepContext(ctx, c.resetPulse)
+
+	err = lc.SetDTR(c.dtr == nil || *c.dtr)
+	if err != nil {
+		return err
+	}
+	if !c.rtscts {
+		err = lc.SetRTS(c.rts == nil || *c.rts)
+		if err != nil {
+			return err
+		}
+	}
+	return waitErr
+}
+
+// sleepContext waits d or ctx done.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	select {
+	case <-time.After(d):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,107 @@
+package dial
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"testing"
+	"time"
+
+	"gobot.io/x/gobot/gobottest"
+)
+
+func TestToLineConfig(t *testing.T) {
+	on, off := true, false
+	cases := []struct {
+		query  string
+		dtr    *bool
+		rts    *bool
+		rtscts bool
+		pulse  time.Duration
+		ok     bool
+	}{
+		{"", nil, nil, false, DefaultResetPulse, true},
+		{"dtr=1&rts=0", &on, &off, false, DefaultResetPulse, true},
+		{"dtr=false&flow=rtscts", &off, nil, true, DefaultResetPulse, true},
+		{"flow=none&reset_pulse=250ms", nil, nil, false, 250 * time.Millisecond, true},
+		{"dtr=x", nil, nil, false, 0, false},
+		{"rts=2", nil, nil, false, 0, false},
+		{"flow=xonxoff", nil, nil, false, 0, false},
+		{"flow=rtscts&rts=1", nil, nil, false, 0, false},
+		{"reset_pulse=0s", nil, nil, false, 0, false},
+		{"reset_pulse=x", nil, nil, false, 0, false},
+	}
+	for _, c := range cases {
+		u, _ := url.Parse("serial:///dev/ttyUSB0?" + c.query)
+		lc, err := toLineConfig(u)
+		if (err == nil) != c.ok {
+			t.Errorf("%s: unexpected error: %v", c.query, err)
+			continue
+		}
+		if !c.ok {
+			continue
+		}
+		if !equalLine(lc.dtr, c.dtr) || !equalLine(lc.rts, c.rts) ||
+			lc.rtscts != c.rtscts || lc.resetPulse != c.pulse {
+			t.Errorf("%s: got %+v", c.query, lc)
+		}
+	}
+}
+
+func equalLine(a, b *bool) bool {
+	return a == nil && b == nil || a != nil && b != nil && *a == *b
+}
+
+// fakeLine records the line changes.
+type fakeLine struct {
+	changes []string
+}
+
+var _ LineControl = new(fakeLine)
+
+func (l *fakeLine) SetDTR(on bool) error {
+	l.changes = append(l.changes, fmt.Sprintf("dtr=%v", on))
+	return nil
+}
+
+func (l *fakeLine) SetRTS(on bool) error {
+	l.changes = append(l.changes, fmt.Sprintf("rts=%v", on))
+	return nil
+}
+
+func (l *fakeLine) Break(ctx context.Context, d time.Duration) error { return nil }
+
+func (l *fakeLine) ResetLine(ctx context.Context) error { return nil }
+
+func TestResetLine(t *testing.T) {
+	off := false
+	cases := []struct {
+		name    string
+		c       lineConfig
+		changes []string
+	}{
+		{"default", lineConfig{},
+			[]string{"dtr=false", "rts=false", "dtr=true", "rts=true"}},
+		{"configured low", lineConfig{dtr: &off, rts: &off},
+			[]string{"dtr=false", "rts=false", "dtr=false", "rts=false"}},
+		{"rtscts", lineConfig{rtscts: true},
+			[]string{"dtr=false", "dtr=true"}},
+	}
+	for _, c := range cases {
+		c.c.resetPulse = time.Millisecond
+		l := new(fakeLine)
+		err := c.c.resetLine(context.Background(), l)
+		gobottest.Assert(t, err, nil)
+		if fmt.Sprint(l.changes) != fmt.Sprint(c.changes) {
+			t.Errorf("%s: got %v, want %v", c.name, l.changes, c.changes)
+		}
+	}
+
+	// the lines are restored even if canceled
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	l := new(fakeLine)
+	c := lineConfig{resetPulse: time.Hour}
+	gobottest.Assert(t, c.resetLine(ctx, l), context.Canceled)
+	gobottest.Assert(t, l.changes, []string{"dtr=false", "rts=false", "dtr=true", "rts=true"})
+}
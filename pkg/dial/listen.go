@@ -0,0 +1,242 @@
+package dial
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/empirefox/firmata/pkg/firmata"
+)
+
+// DefaultProbeTimeout is the max time to wait REPORT_FIRMWARE of an inbound
+// board when some waiter expects a firmware name.
+const DefaultProbeTimeout = 3 * time.Second
+
+// listenConfig of tcp-listen://:3030?expect=<firmware name>&peer=<ip>
+type listenConfig struct {
+	addr    string
+	expect  string
+	peer    string
+	timeout time.Duration
+}
+
+func toListen(u *url.URL) (*listenConfig, error) {
+	if u.Path != "" {
+		return nil, fmt.Errorf("tcp-listen cannot contain path: %s", u)
+	}
+	q := u.Query()
+	c := listenConfig{
+		addr:   u.Host,
+		expect: q.Get("expect"),
+		peer:   q.Get("peer"),
+	}
+	if c.addr == "" {
+		return nil, fmt.Errorf("empty tcp-listen address: %s", u)
+	}
+	if c.peer != "" && net.ParseIP(c.peer) == nil {
+		return nil, fmt.Errorf("invalid peer ip: %v", u)
+	}
+	v := q.Get("timeout")
+	if v != "" {
+		var err error
+		c.timeout, err = time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timeout: %v", u)
+		}
+	}
+	return &c, nil
+}
+
+// listenWaiter is a Dial waiting the inbound board.
+type listenWaiter struct {
+	config *listenConfig
+	ch     chan net.Conn
+}
+
+func (w *listenWaiter) matchPeer(ip net.IP) bool {
+	return w.config.peer == "" || net.ParseIP(w.config.peer).Equal(ip)
+}
+
+// sharedListener accepts boards for all waiters of the same address. It is
+// closed when no waiter left, so boards are refused until the next Dial.
+type sharedListener struct {
+	lis     net.Listener
+	addr    string
+	mu      sync.Mutex
+	waiters map[*listenWaiter]struct{}
+}
+
+var (
+	listenersMu sync.Mutex
+	listeners   = make(map[string]*sharedListener)
+)
+
+func dialListen(ctx context.Context, c *listenConfig) (net.Conn, error) {
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	w := &listenWaiter{config: c, ch: make(chan net.Conn, 1)}
+	sl, err := addListenWaiter(c.addr, w)
+	if err != nil {
+		return nil, err
+	}
+	defer sl.remove(w)
+
+	select {
+	case conn := <-w.ch:
+		return conn, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func addListenWaiter(addr string, w *listenWaiter) (*sharedListener, error) {
+	listenersMu.Lock()
+	defer listenersMu.Unlock()
+
+	sl := listeners[addr]
+	if sl == nil {
+		lis, err := net.Listen("tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		sl = &sharedListener{
+			lis:     lis,
+			addr:    addr,
+			waiters: make(map[*listenWaiter]struct{}),
+		}
+		listeners[addr] = sl
+		go sl.serve()
+	}
+	sl.mu.Lock()
+	sl.waiters[w] = struct{}{}
+	sl.mu.Unlock()
+	return sl, nil
+}
+
+func (sl *sharedListener) remove(w *listenWaiter) {
+	listenersMu.Lock()
+	defer listenersMu.Unlock()
+
+	sl.mu.Lock()
+	delete(sl.waiters, w)
+	empty := len(sl.waiters) == 0
+	sl.mu.Unlock()
+
+	// a conn may be delivered after ctx done
+	select {
+	case conn := <-w.ch:
+		conn.Close()
+	default:
+	}
+
+	if empty && listeners[sl.addr] == sl {
+		delete(listeners, sl.addr)
+		sl.lis.Close()
+	}
+}
+
+func (sl *sharedListener) serve() {
+	for {
+		conn, err := sl.lis.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			return
+		}
+		go sl.dispatch(conn)
+	}
+}
+
+// dispatch hands conn to the first waiter matched by peer ip and firmware
+// name. The firmware is probed only if some matched waiter expects it.
+func (sl *sharedListener) dispatch(conn net.Conn) {
+	var ip net.IP
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		ip = addr.IP
+	}
+
+	var firmware string
+	probed := false
+	for {
+		if sl.deliver(conn, ip, firmware, probed) {
+			return
+		}
+		if probed || !sl.expecting(ip) {
+			conn.Close()
+			return
+		}
+		var err error
+		firmware, err = probeFirmware(conn, DefaultProbeTimeout)
+		if err != nil {
+			conn.Close()
+			return
+		}
+		probed = true
+	}
+}
+
+// deliver sends conn with mu locked, so remove always sees it.
+func (sl *sharedListener) deliver(conn net.Conn, ip net.IP, firmware string, probed bool) bool {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	for w := range sl.waiters {
+		if !w.matchPeer(ip) {
+			continue
+		}
+		if w.config.expect == "" || probed && w.config.expect == firmware {
+			delete(sl.waiters, w)
+			w.ch <- conn
+			return true
+		}
+	}
+	return false
+}
+
+func (sl *sharedListener) expecting(ip net.IP) bool {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	for w := range sl.waiters {
+		if w.matchPeer(ip) && w.config.expect != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// probeFirmware queries REPORT_FIRMWARE, other frames before it are dropped.
+// The handshake queries the firmware again after dispatching.
+func probeFirmware(conn net.Conn, timeout time.Duration) (string, error) {
+	conn.SetDeadline(time.Now().Add(timeout))
+	defer conn.SetDeadline(time.Time{})
+
+	_, err := conn.Write([]byte{firmata.START_SYSEX, firmata.REPORT_FIRMWARE, firmata.END_SYSEX})
+	if err != nil {
+		return "", err
+	}
+	fr := firmata.NewReadFramer(conn)
+	for {
+		frame, err := fr.ReadFrame()
+		if err != nil {
+			_, isNetErr := err.(net.Error)
+			if isNetErr || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return "", err
+			}
+			// unsupported frame, keep reading
+			continue
+		}
+		if frame.Type == firmata.REPORT_FIRMWARE {
+			return frame.Data.(*firmata.Version).Server.Name, nil
+		}
+	}
+}
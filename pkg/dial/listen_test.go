@@ -0,0 +1,95 @@
+package dial
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/empirefox/firmata/pkg/firmata/device"
+	"gobot.io/x/gobot/gobottest"
+)
+
+// listenBoard connects to addr and answers every REPORT_FIRMWARE as name.
+func listenBoard(t *testing.T, addr string, name string) {
+	conn, err := net.Dial("tcp", addr)
+	gobottest.Assert(t, err, nil)
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		query := make([]byte, 3)
+		for {
+			if _, err := io.ReadFull(conn, query); err != nil {
+				return
+			}
+			conn.Write(device.FirmwareReply(name, 2, 5))
+		}
+	}()
+}
+
+// waitListenWaiters waits until n waiters are registered on addr.
+func waitListenWaiters(t *testing.T, addr string, n int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		listenersMu.Lock()
+		sl := listeners[addr]
+		listenersMu.Unlock()
+		if sl != nil {
+			sl.mu.Lock()
+			waiters := len(sl.waiters)
+			sl.mu.Unlock()
+			if waiters == n {
+				return
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d waiters not registered", n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestListenExpect(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	gobottest.Assert(t, err, nil)
+	addr := lis.Addr().String()
+	lis.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	type result struct {
+		name string
+		conn net.Conn
+		err  error
+	}
+	results := make(chan result, 2)
+	for _, name := range []string{"BoardA", "BoardB"} {
+		name := name
+		go func() {
+			conn, err := dialListen(ctx, &listenConfig{addr: addr, expect: name})
+			results <- result{name, conn, err}
+		}()
+	}
+	waitListenWaiters(t, addr, 2)
+
+	listenBoard(t, addr, "BoardB")
+	listenBoard(t, addr, "BoardA")
+
+	for i := 0; i < 2; i++ {
+		r := <-results
+		gobottest.Assert(t, r.err, nil)
+		// the probe was answered by the expected board
+		r.conn.Write([]byte{0xF0, 0x79, 0xF7})
+		b := make([]byte, len(device.FirmwareReply(r.name, 2, 5)))
+		_, err := io.ReadFull(r.conn, b)
+		gobottest.Assert(t, err, nil)
+		gobottest.Assert(t, b, device.FirmwareReply(r.name, 2, 5))
+		r.conn.Close()
+	}
+
+	// the listener is closed without waiters
+	listenersMu.Lock()
+	gobottest.Assert(t, listeners[addr], (*sharedListener)(nil))
+	listenersMu.Unlock()
+}
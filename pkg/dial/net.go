@@ -0,0 +1,127 @@
+package dial
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strconv"
+)
+
+// maxDatagram is the largest udp payload.
+const maxDatagram = 64 << 10
+
+// datagramConn reads a whole datagram at once, the part not fitting p is
+// returned by the next reads instead of being dropped.
+type datagramConn struct {
+	net.Conn
+	buf  []byte
+	rest []byte
+}
+
+func newDatagramConn(conn net.Conn) *datagramConn {
+	return &datagramConn{Conn: conn, buf: make([]byte, maxDatagram)}
+}
+
+func (c *datagramConn) Read(p []byte) (int, error) {
+	for len(c.rest) == 0 {
+		n, err := c.Conn.Read(c.buf)
+		if err != nil {
+			return 0, err
+		}
+		c.rest = c.buf[:n]
+	}
+	n := copy(p, c.rest)
+	c.rest = c.rest[n:]
+	return n, nil
+}
+
+func toUnix(u *url.URL) (*tcpConfig, error) {
+	if u.Host != "" {
+		return nil, fmt.Errorf("unix cannot contain host: %s", u)
+	}
+	if u.Path == "" {
+		return nil, fmt.Errorf("empty unix path: %s", u)
+	}
+	c := tcpConfig{addr: u.Path}
+	err := c.parseTimeouts(u)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// toTLS accepts ca, cert, key, server_name and insecure params besides the
+// tcp ones.
+func toTLS(u *url.URL) (*tcpConfig, *tls.Config, error) {
+	c, err := toTCP(u)
+	if err != nil {
+		return nil, nil, err
+	}
+	tc, err := toTLSConfig(u)
+	if err != nil {
+		return nil, nil, err
+	}
+	return c, tc, nil
+}
+
+func toTLSConfig(u *url.URL) (*tls.Config, error) {
+	q := u.Query()
+	tc := &tls.Config{
+		ServerName: q.Get("server_name"),
+	}
+	if tc.ServerName == "" {
+		tc.ServerName = u.Hostname()
+	}
+
+	v := q.Get("insecure")
+	if v != "" {
+		insecure, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid insecure: %v", u)
+		}
+		tc.InsecureSkipVerify = insecure
+	}
+
+	v = q.Get("ca")
+	if v != "" {
+		pem, err := ioutil.ReadFile(v)
+		if err != nil {
+			return nil, err
+		}
+		tc.RootCAs = x509.NewCertPool()
+		if !tc.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("invalid ca: %v", u)
+		}
+	}
+
+	cert, key := q.Get("cert"), q.Get("key")
+	if (cert == "") != (key == "") {
+		return nil, fmt.Errorf("cert and key must be set together: %v", u)
+	}
+	if cert != "" {
+		pair, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			return nil, err
+		}
+		tc.Certificates = []tls.Certificate{pair}
+	}
+	return tc, nil
+}
+
+func dialTLS(ctx context.Context, c *tcpConfig, tc *tls.Config) (net.Conn, error) {
+	raw, err := c.dialer().DialContext(ctx, "tcp", c.addr)
+	if err != nil {
+		return nil, err
+	}
+	conn := tls.Client(raw, tc)
+	err = conn.HandshakeContext(ctx)
+	if err != nil {
+		raw.Close()
+		return nil, err
+	}
+	return conn, nil
+}
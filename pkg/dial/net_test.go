@@ -0,0 +1,110 @@
+package dial
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gobot.io/x/gobot/gobottest"
+)
+
+func TestUDPDatagram(t *testing.T) {
+	board, err := net.ListenPacket("udp", "127.0.0.1:0")
+	gobottest.Assert(t, err, nil)
+	defer board.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c, err := Dial(ctx, "udp://"+board.LocalAddr().String())
+	gobottest.Assert(t, err, nil)
+	defer c.Close()
+
+	_, err = c.Write([]byte{0xF9})
+	gobottest.Assert(t, err, nil)
+	b := make([]byte, 16)
+	_, addr, err := board.ReadFrom(b)
+	gobottest.Assert(t, err, nil)
+	datagram := []byte{0xF9, 2, 5, 0xF0, 0x79, 2, 5, 0xF7}
+	_, err = board.WriteTo(datagram, addr)
+	gobottest.Assert(t, err, nil)
+
+	// read by small buffers, nothing is dropped
+	got := make([]byte, len(datagram))
+	for n := 0; n < len(got); {
+		m, err := c.Read(got[n:min(n+3, len(got))])
+		gobottest.Assert(t, err, nil)
+		n += m
+	}
+	gobottest.Assert(t, got, datagram)
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func TestToUnix(t *testing.T) {
+	cases := []struct {
+		url     string
+		addr    string
+		timeout time.Duration
+		ok      bool
+	}{
+		{"unix:///run/firmata.sock", "/run/firmata.sock", 0, true},
+		{"unix:///run/firmata.sock?timeout=2s", "/run/firmata.sock", 2 * time.Second, true},
+		{"unix://host/run/firmata.sock", "", 0, false},
+		{"unix://", "", 0, false},
+		{"unix:///run/firmata.sock?timeout=x", "", 0, false},
+	}
+	for _, c := range cases {
+		u, err := url.Parse(c.url)
+		gobottest.Assert(t, err, nil)
+		tc, err := toUnix(u)
+		if (err == nil) != c.ok {
+			t.Errorf("%s: unexpected error: %v", c.url, err)
+			continue
+		}
+		if c.ok && (tc.addr != c.addr || tc.timeout != c.timeout) {
+			t.Errorf("%s: got %+v", c.url, tc)
+		}
+	}
+}
+
+func TestToTLSConfig(t *testing.T) {
+	bad := filepath.Join(t.TempDir(), "bad.pem")
+	gobottest.Assert(t, os.WriteFile(bad, []byte("not a pem"), 0600), nil)
+
+	cases := []struct {
+		url        string
+		serverName string
+		insecure   bool
+		ok         bool
+	}{
+		{"tls://board.local:3030", "board.local", false, true},
+		{"tls://10.0.0.2:3030?server_name=board", "board", false, true},
+		{"tls://10.0.0.2:3030?insecure=1", "10.0.0.2", true, true},
+		{"tls://10.0.0.2:3030?insecure=x", "", false, false},
+		{"tls://10.0.0.2:3030?cert=client.pem", "", false, false},
+		{"tls://10.0.0.2:3030?key=client.key", "", false, false},
+		{"tls://10.0.0.2:3030?ca=" + bad, "", false, false},
+		{"tls://10.0.0.2:3030?ca=" + bad + ".missing", "", false, false},
+	}
+	for _, c := range cases {
+		u, err := url.Parse(c.url)
+		gobottest.Assert(t, err, nil)
+		tc, err := toTLSConfig(u)
+		if (err == nil) != c.ok {
+			t.Errorf("%s: unexpected error: %v", c.url, err)
+			continue
+		}
+		if c.ok && (tc.ServerName != c.serverName || tc.InsecureSkipVerify != c.insecure) {
+			t.Errorf("%s: got server_name %q insecure %v", c.url, tc.ServerName, tc.InsecureSkipVerify)
+		}
+	}
+}
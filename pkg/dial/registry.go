@@ -0,0 +1,223 @@
+package dial
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// DialFunc opens the transport of u.
+type DialFunc func(ctx context.Context, u *url.URL) (io.ReadWriteCloser, error)
+
+// ValidateFunc checks u without connecting, so bad config fails at loading.
+type ValidateFunc func(u *url.URL) error
+
+type transport struct {
+	dial     DialFunc
+	validate ValidateFunc
+}
+
+var (
+	transportsMu sync.RWMutex
+	transports   = make(map[string]*transport)
+)
+
+func init() {
+	Register("tcp", func(ctx context.Context, u *url.URL) (io.ReadWriteCloser, error) {
+		c, err := toTCP(u)
+		if err != nil {
+			return nil, err
+		}
+		return c.dialer().DialContext(ctx, "tcp", c.addr)
+	})
+	RegisterValidator("tcp", func(u *url.URL) error {
+		_, err := toTCP(u)
+		return err
+	})
+
+	Register("udp", func(ctx context.Context, u *url.URL) (io.ReadWriteCloser, error) {
+		c, err := toTCP(u)
+		if err != nil {
+			return nil, err
+		}
+		conn, err := c.dialer().DialContext(ctx, "udp", c.addr)
+		if err != nil {
+			return nil, err
+		}
+		return newDatagramConn(conn), nil
+	})
+	RegisterValidator("udp", func(u *url.URL) error {
+		_, err := toTCP(u)
+		return err
+	})
+
+	Register("unix", func(ctx context.Context, u *url.URL) (io.ReadWriteCloser, error) {
+		c, err := toUnix(u)
+		if err != nil {
+			return nil, err
+		}
+		return c.dialer().DialContext(ctx, "unix", c.addr)
+	})
+	RegisterValidator("unix", func(u *url.URL) error {
+		_, err := toUnix(u)
+		return err
+	})
+
+	Register("tls", func(ctx context.Context, u *url.URL) (io.ReadWriteCloser, error) {
+		c, tc, err := toTLS(u)
+		if err != nil {
+			return nil, err
+		}
+		return dialTLS(ctx, c, tc)
+	})
+	RegisterValidator("tls", func(u *url.URL) error {
+		_, _, err := toTLS(u)
+		return err
+	})
+
+	for _, scheme := range []string{"ws", "wss"} {
+		Register(scheme, func(ctx context.Context, u *url.URL) (io.ReadWriteCloser, error) {
+			return dialWebsocket(ctx, u)
+		})
+		RegisterValidator(scheme, validateWebsocket)
+	}
+
+	Register("rfc2217", func(ctx context.Context, u *url.URL) (io.ReadWriteCloser, error) {
+		return dialRFC2217(ctx, u)
+	})
+	RegisterValidator("rfc2217", func(u *url.URL) error {
+		_, err := toRFC2217(u)
+		return err
+	})
+
+	Register("serial", func(ctx context.Context, u *url.URL) (io.ReadWriteCloser, error) {
+		c, err := toSerial(u)
+		if err != nil {
+			return nil, err
+		}
+		line, err := toLineConfig(u)
+		if err != nil {
+			return nil, err
+		}
+		return openSerial(c, line)
+	})
+	RegisterValidator("serial", func(u *url.URL) error {
+		_, err := toSerial(u)
+		if err != nil {
+			return err
+		}
+		_, err = toLineConfig(u)
+		return err
+	})
+
+	Register("usb", func(ctx context.Context, u *url.URL) (io.ReadWriteCloser, error) {
+		su, err := resolveUSB(SysfsRoot, u)
+		if err != nil {
+			return nil, err
+		}
+		c, err := toSerial(su)
+		if err != nil {
+			return nil, err
+		}
+		line, err := toLineConfig(u)
+		if err != nil {
+			return nil, err
+		}
+		return openSerial(c, line)
+	})
+	RegisterValidator("usb", func(u *url.URL) error {
+		_, err := toUSB(u)
+		if err != nil {
+			return err
+		}
+		_, err = toLineConfig(u)
+		return err
+	})
+
+	Register("tcp-listen", func(ctx context.Context, u *url.URL) (io.ReadWriteCloser, error) {
+		c, err := toListen(u)
+		if err != nil {
+			return nil, err
+		}
+		return dialListen(ctx, c)
+	})
+	RegisterValidator("tcp-listen", func(u *url.URL) error {
+		_, err := toListen(u)
+		return err
+	})
+
+	Register("replay", func(ctx context.Context, u *url.URL) (io.ReadWriteCloser, error) {
+		path, speed, err := toReplay(u)
+		if err != nil {
+			return nil, err
+		}
+		return NewReplayFile(path, speed)
+	})
+	RegisterValidator("replay", func(u *url.URL) error {
+		_, _, err := toReplay(u)
+		return err
+	})
+}
+
+// Register adds or replaces the transport of scheme. Schemes prefixed with
+// fault+ are reserved.
+func Register(scheme string, dial DialFunc) {
+	if scheme == "" || strings.HasPrefix(scheme, faultSchemePrefix) {
+		panic("dial: invalid scheme: " + scheme)
+	}
+	if dial == nil {
+		panic("dial: Register dial is nil")
+	}
+	transportsMu.Lock()
+	defer transportsMu.Unlock()
+	transports[scheme] = &transport{dial: dial}
+}
+
+// RegisterValidator sets the validate hook of a registered scheme.
+func RegisterValidator(scheme string, validate ValidateFunc) {
+	transportsMu.Lock()
+	defer transportsMu.Unlock()
+	t, ok := transports[scheme]
+	if !ok {
+		panic("dial: RegisterValidator before Register: " + scheme)
+	}
+	transports[scheme] = &transport{dial: t.dial, validate: validate}
+}
+
+func lookup(scheme string) (*transport, error) {
+	transportsMu.RLock()
+	defer transportsMu.RUnlock()
+	t, ok := transports[scheme]
+	if !ok {
+		return nil, fmt.Errorf("schema not support: %s", scheme)
+	}
+	return t, nil
+}
+
+// Validate checks p like Dial does without connecting.
+func Validate(p string) error {
+	u, err := parseDialAddr(p)
+	if err != nil {
+		return err
+	}
+
+	if strings.HasPrefix(u.Scheme, faultSchemePrefix) {
+		inner, _, err := splitFault(u)
+		if err != nil {
+			return err
+		}
+		return Validate(inner.String())
+	}
+
+	t, err := lookup(u.Scheme)
+	if err != nil {
+		return err
+	}
+	if t.validate == nil {
+		return nil
+	}
+	return t.validate(u)
+}
@@ -0,0 +1,95 @@
+package dial
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/url"
+	"testing"
+
+	"gobot.io/x/gobot/gobottest"
+)
+
+func assertPanic(t *testing.T, name string, fn func()) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("%s should panic", name)
+		}
+	}()
+	fn()
+}
+
+func TestRegisterDuplicate(t *testing.T) {
+	errFirst, errSecond := errors.New("first"), errors.New("second")
+	Register("test-dup", func(ctx context.Context, u *url.URL) (io.ReadWriteCloser, error) {
+		return nil, errFirst
+	})
+	RegisterValidator("test-dup", func(u *url.URL) error { return errFirst })
+	gobottest.Assert(t, Validate("test-dup://x"), errFirst)
+
+	// the later one replaces the dial and drops the old validator
+	Register("test-dup", func(ctx context.Context, u *url.URL) (io.ReadWriteCloser, error) {
+		return nil, errSecond
+	})
+	_, err := Dial(context.Background(), "test-dup://x")
+	gobottest.Assert(t, err, errSecond)
+	gobottest.Assert(t, Validate("test-dup://x"), nil)
+
+	assertPanic(t, "fault+ scheme", func() {
+		Register("fault+test", func(ctx context.Context, u *url.URL) (io.ReadWriteCloser, error) {
+			return nil, nil
+		})
+	})
+	assertPanic(t, "nil dial", func() { Register("test-nil", nil) })
+	assertPanic(t, "validator before register", func() {
+		RegisterValidator("test-none", func(u *url.URL) error { return nil })
+	})
+}
+
+func TestUnknownScheme(t *testing.T) {
+	gobottest.Refute(t, Validate("nope://127.0.0.1:3030"), nil)
+	_, err := Dial(context.Background(), "nope://127.0.0.1:3030")
+	gobottest.Refute(t, err, nil)
+	gobottest.Refute(t, Validate("fault+nope://127.0.0.1:3030?seed=1"), nil)
+}
+
+func TestValidateFault(t *testing.T) {
+	cases := []struct {
+		dial string
+		ok   bool
+	}{
+		{"fault+tcp://127.0.0.1:3030?seed=1&drop=0.1", true},
+		{"fault+tcp://127.0.0.1:3030?drop=x", false},
+		// the inner validators still run
+		{"fault+tcp://127.0.0.1:3030/path?seed=1", false},
+		{"fault+rfc2217://127.0.0.1:3030?seed=1&parity=X", false},
+		{"fault+serial:///dev/ttyUSB0?seed=1&flow=x", false},
+		{"fault+usb://?seed=1", false},
+		{"fault+fault+tcp://127.0.0.1:3030?seed=1", true},
+	}
+	for _, c := range cases {
+		err := Validate(c.dial)
+		if (err == nil) != c.ok {
+			t.Errorf("%s: unexpected error: %v", c.dial, err)
+		}
+	}
+}
+
+func TestDialFault(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	gobottest.Assert(t, err, nil)
+	defer lis.Close()
+	go func() {
+		conn, err := lis.Accept()
+		if err == nil {
+			conn.Close()
+		}
+	}()
+
+	c, err := Dial(context.Background(), "fault+tcp://"+lis.Addr().String()+"?seed=1")
+	gobottest.Assert(t, err, nil)
+	defer c.Close()
+	_, ok := c.(*FaultConn)
+	gobottest.Assert(t, ok, true)
+}
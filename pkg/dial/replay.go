@@ -0,0 +1,116 @@
+package dial
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ReplayConn plays the board side of a capture back. Writes are discarded.
+// Read fails at the recorded link error, or blocks until Close like an idle
+// board if the capture ends without one.
+type ReplayConn struct {
+	f     io.Closer
+	cr    *CaptureReader
+	speed float64
+
+	mu      sync.Mutex
+	start   time.Time
+	first   time.Time
+	pending []byte
+
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+// NewReplayFile opens the capture at path. speed 1 is the original timing,
+// 2 is twice faster and 0 plays without any delay.
+func NewReplayFile(path string, speed float64) (*ReplayConn, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	cr, err := NewCaptureReader(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &ReplayConn{
+		f:     file,
+		cr:    cr,
+		speed: speed,
+		start: time.Now(),
+		first: cr.last,
+		done:  make(chan struct{}),
+	}, nil
+}
+
+func (rc *ReplayConn) Read(p []byte) (int, error) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	for len(rc.pending) == 0 {
+		rec, err := rc.cr.Next()
+		if err == io.EOF {
+			<-rc.done
+			return 0, io.ErrClosedPipe
+		}
+		if err != nil {
+			return 0, err
+		}
+		if rec.Dir == CaptureWrite {
+			continue
+		}
+		if rc.speed > 0 {
+			at := rc.start.Add(time.Duration(float64(rec.Time.Sub(rc.first)) / rc.speed))
+			select {
+			case <-time.After(time.Until(at)):
+			case <-rc.done:
+				return 0, io.ErrClosedPipe
+			}
+		}
+		if rec.Dir == CaptureReadError {
+			return 0, io.EOF
+		}
+		rc.pending = rec.Data
+	}
+
+	n := copy(p, rc.pending)
+	rc.pending = rc.pending[n:]
+	return n, nil
+}
+
+func (rc *ReplayConn) Write(p []byte) (int, error) {
+	select {
+	case <-rc.done:
+		return 0, io.ErrClosedPipe
+	default:
+		return len(p), nil
+	}
+}
+
+func (rc *ReplayConn) Close() error {
+	rc.closeOnce.Do(func() { close(rc.done) })
+	return rc.f.Close()
+}
+
+// toReplay accepts replay:///abs/file.fcap or replay://rel/file.fcap.
+func toReplay(u *url.URL) (path string, speed float64, err error) {
+	path = u.Host + u.Path
+	if path == "" {
+		return "", 0, fmt.Errorf("empty replay path: %s", u)
+	}
+	speed = 1
+	v := u.Query().Get("speed")
+	if v != "" {
+		speed, err = strconv.ParseFloat(v, 64)
+		if err != nil || speed < 0 {
+			return "", 0, fmt.Errorf("invalid speed: %v", u)
+		}
+	}
+	return path, speed, nil
+}
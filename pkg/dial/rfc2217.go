@@ -0,0 +1,325 @@
+package dial
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// telnet and RFC2217 constants
+const (
+	telnetSE   byte = 240
+	telnetSB   byte = 250
+	telnetWILL byte = 251
+	telnetWONT byte = 252
+	telnetDO   byte = 253
+	telnetDONT byte = 254
+	telnetIAC  byte = 255
+
+	telnetOptBinary  byte = 0
+	telnetOptSGA     byte = 3
+	telnetOptComPort byte = 44
+
+	comPortSetBaudRate byte = 1
+	comPortSetDataSize byte = 2
+	comPortSetParity   byte = 3
+	comPortSetStopSize byte = 4
+	comPortSetControl  byte = 5
+
+	// values of SET-CONTROL
+	comPortFlowHardware byte = 3
+	comPortBreakOn      byte = 5
+	comPortBreakOff     byte = 6
+	comPortDTROn        byte = 8
+	comPortDTROff       byte = 9
+	comPortRTSOn        byte = 11
+	comPortRTSOff       byte = 12
+)
+
+var comPortParity = map[byte]byte{'N': 1, 'O': 2, 'E': 3, 'M': 4, 'S': 5}
+
+type rfc2217Config struct {
+	tcpConfig
+	baud     uint32
+	size     byte
+	parity   byte
+	stopSize byte
+	line     *lineConfig
+}
+
+// toRFC2217 accepts the serial and line params besides the tcp ones, zero
+// values keep the settings of the gateway.
+func toRFC2217(u *url.URL) (*rfc2217Config, error) {
+	tc, err := toTCP(u)
+	if err != nil {
+		return nil, err
+	}
+	line, err := toLineConfig(u)
+	if err != nil {
+		return nil, err
+	}
+	c := rfc2217Config{tcpConfig: *tc, line: line}
+	q := u.Query()
+
+	v := q.Get("baud")
+	if v != "" {
+		baud, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid baud: %v", u)
+		}
+		c.baud = uint32(baud)
+	}
+
+	v = q.Get("size")
+	if v != "" {
+		size, err := strconv.ParseUint(v, 10, 8)
+		if err != nil || size < 5 || size > 8 {
+			return nil, fmt.Errorf("invalid size: %v", u)
+		}
+		c.size = byte(size)
+	}
+
+	v = q.Get("parity")
+	if v != "" {
+		if len(v) != 1 || comPortParity[v[0]] == 0 {
+			return nil, fmt.Errorf("invalid parity: %v", u)
+		}
+		c.parity = comPortParity[v[0]]
+	}
+
+	v = q.Get("stop_bit")
+	switch v {
+	case "":
+	case "1":
+		c.stopSize = 1
+	case "2":
+		c.stopSize = 2
+	case "15":
+		c.stopSize = 3
+	default:
+		return nil, fmt.Errorf("invalid stop_bit: %v", u)
+	}
+	return &c, nil
+}
+
+func dialRFC2217(ctx context.Context, u *url.URL) (*RFC2217Conn, error) {
+	c, err := toRFC2217(u)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := c.dialer().DialContext(ctx, "tcp", c.addr)
+	if err != nil {
+		return nil, err
+	}
+	rc := &RFC2217Conn{conn: conn, r: bufio.NewReader(conn), line: c.line}
+	err = rc.negotiate(c)
+	if err == nil {
+		err = c.line.apply(rc)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return rc, nil
+}
+
+// RFC2217Conn is a serial port of a telnet COM port control gateway.
+type RFC2217Conn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	wmu  sync.Mutex
+	line *lineConfig
+}
+
+var _ LineControl = new(RFC2217Conn)
+
+func (rc *RFC2217Conn) negotiate(c *rfc2217Config) error {
+	b := []byte{
+		telnetIAC, telnetWILL, telnetOptBinary,
+		telnetIAC, telnetDO, telnetOptBinary,
+		telnetIAC, telnetWILL, telnetOptSGA,
+		telnetIAC, telnetDO, telnetOptSGA,
+		telnetIAC, telnetWILL, telnetOptComPort,
+	}
+	if c.baud != 0 {
+		var baud [4]byte
+		binary.BigEndian.PutUint32(baud[:], c.baud)
+		b = append(b, comPortCommand(comPortSetBaudRate, baud[:]...)...)
+	}
+	if c.size != 0 {
+		b = append(b, comPortCommand(comPortSetDataSize, c.size)...)
+	}
+	if c.parity != 0 {
+		b = append(b, comPortCommand(comPortSetParity, c.parity)...)
+	}
+	if c.stopSize != 0 {
+		b = append(b, comPortCommand(comPortSetStopSize, c.stopSize)...)
+	}
+	if c.line.rtscts {
+		b = append(b, comPortCommand(comPortSetControl, comPortFlowHardware)...)
+	}
+	return rc.writeRaw(b)
+}
+
+// comPortCommand escapes IAC in value.
+func comPortCommand(cmd byte, value ...byte) []byte {
+	b := []byte{telnetIAC, telnetSB, telnetOptComPort, cmd}
+	for _, v := range value {
+		b = append(b, v)
+		if v == telnetIAC {
+			b = append(b, telnetIAC)
+		}
+	}
+	return append(b, telnetIAC, telnetSE)
+}
+
+// SetControl sends SET-CONTROL of RFC2217, like 8/9 for DTR on/off.
+func (rc *RFC2217Conn) SetControl(value byte) error {
+	return rc.writeRaw(comPortCommand(comPortSetControl, value))
+}
+
+func (rc *RFC2217Conn) SetDTR(on bool) error {
+	if on {
+		return rc.SetControl(comPortDTROn)
+	}
+	return rc.SetControl(comPortDTROff)
+}
+
+func (rc *RFC2217Conn) SetRTS(on bool) error {
+	if on {
+		return rc.SetControl(comPortRTSOn)
+	}
+	return rc.SetControl(comPortRTSOff)
+}
+
+// Break holds the tx line of the gateway low for d.
+func (rc *RFC2217Conn) Break(ctx context.Context, d time.Duration) error {
+	err := rc.SetControl(comPortBreakOn)
+	if err != nil {
+		return err
+	}
+	waitErr := sleepContext(ctx, d)
+	err = rc.SetControl(comPortBreakOff)
+	if err != nil {
+		return err
+	}
+	return waitErr
+}
+
+func (rc *RFC2217Conn) ResetLine(ctx context.Context) error {
+	return rc.line.resetLine(ctx, rc)
+}
+
+func (rc *RFC2217Conn) writeRaw(b []byte) error {
+	rc.wmu.Lock()
+	defer rc.wmu.Unlock()
+	_, err := rc.conn.Write(b)
+	return err
+}
+
+func (rc *RFC2217Conn) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if n > 0 && rc.r.Buffered() == 0 {
+			break
+		}
+		b, err := rc.r.ReadByte()
+		if err != nil {
+			if n > 0 {
+				return n, nil
+			}
+			return 0, err
+		}
+		if b != telnetIAC {
+			p[n] = b
+			n++
+			continue
+		}
+
+		data, err := rc.readCommand()
+		if err != nil {
+			return n, err
+		}
+		if data {
+			p[n] = telnetIAC
+			n++
+		}
+	}
+	return n, nil
+}
+
+// readCommand handles the command after IAC, data is true for escaped IAC.
+func (rc *RFC2217Conn) readCommand() (data bool, err error) {
+	cmd, err := rc.r.ReadByte()
+	if err != nil {
+		return false, err
+	}
+	switch cmd {
+	case telnetIAC:
+		return true, nil
+	case telnetDO, telnetWILL:
+		opt, err := rc.r.ReadByte()
+		if err != nil {
+			return false, err
+		}
+		switch opt {
+		case telnetOptBinary, telnetOptSGA:
+			return false, nil
+		case telnetOptComPort:
+			if cmd == telnetDO {
+				return false, nil
+			}
+		}
+		refuse := telnetDONT
+		if cmd == telnetDO {
+			refuse = telnetWONT
+		}
+		return false, rc.writeRaw([]byte{telnetIAC, refuse, opt})
+	case telnetDONT, telnetWONT:
+		_, err = rc.r.ReadByte()
+		return false, err
+	case telnetSB:
+		// notifications of the gateway are ignored
+		for {
+			b, err := rc.r.ReadByte()
+			if err != nil {
+				return false, err
+			}
+			if b != telnetIAC {
+				continue
+			}
+			b, err = rc.r.ReadByte()
+			if err != nil {
+				return false, err
+			}
+			if b == telnetSE {
+				return false, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// Write escapes IAC.
+func (rc *RFC2217Conn) Write(p []byte) (int, error) {
+	b := make([]byte, 0, len(p)+8)
+	for _, v := range p {
+		b = append(b, v)
+		if v == telnetIAC {
+			b = append(b, telnetIAC)
+		}
+	}
+	err := rc.writeRaw(b)
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (rc *RFC2217Conn) Close() error { return rc.conn.Close() }
@@ -0,0 +1,64 @@
+package dial
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"testing"
+
+	"gobot.io/x/gobot/gobottest"
+)
+
+func TestRFC2217(t *testing.T) {
+	host, board := net.Pipe()
+	defer board.Close()
+	rc := &RFC2217Conn{conn: host, r: bufio.NewReader(host), line: &lineConfig{}}
+	defer rc.Close()
+
+	// 115200 is 0x0001c200
+	errc := make(chan error, 1)
+	go func() { errc <- rc.negotiate(&rfc2217Config{baud: 115200, line: rc.line}) }()
+	want := []byte{
+		telnetIAC, telnetWILL, telnetOptBinary,
+		telnetIAC, telnetDO, telnetOptBinary,
+		telnetIAC, telnetWILL, telnetOptSGA,
+		telnetIAC, telnetDO, telnetOptSGA,
+		telnetIAC, telnetWILL, telnetOptComPort,
+		telnetIAC, telnetSB, telnetOptComPort, comPortSetBaudRate,
+		0x00, 0x01, 0xC2, 0x00,
+		telnetIAC, telnetSE,
+	}
+	b := make([]byte, len(want))
+	_, err := io.ReadFull(board, b)
+	gobottest.Assert(t, err, nil)
+	gobottest.Assert(t, b, want)
+	gobottest.Assert(t, <-errc, nil)
+
+	// IAC in a value is escaped
+	gobottest.Assert(t, comPortCommand(comPortSetBaudRate, 0, 0, 0xFF, 0),
+		[]byte{telnetIAC, telnetSB, telnetOptComPort, comPortSetBaudRate,
+			0, 0, 0xFF, 0xFF, 0, telnetIAC, telnetSE})
+
+	// host to board
+	go func() {
+		_, err := rc.Write([]byte{1, 0xFF, 2})
+		errc <- err
+	}()
+	b = make([]byte, 4)
+	_, err = io.ReadFull(board, b)
+	gobottest.Assert(t, err, nil)
+	gobottest.Assert(t, b, []byte{1, 0xFF, 0xFF, 2})
+	gobottest.Assert(t, <-errc, nil)
+
+	// board to host, with a LINESTATE notification inside the data
+	go board.Write([]byte{
+		1, telnetIAC, telnetIAC, 2,
+		telnetIAC, telnetSB, telnetOptComPort, 107, telnetIAC, telnetIAC, telnetIAC, telnetSE,
+		telnetIAC, telnetDO, telnetOptComPort,
+		3,
+	})
+	b = make([]byte, 4)
+	_, err = io.ReadFull(rc, b)
+	gobottest.Assert(t, err, nil)
+	gobottest.Assert(t, b, []byte{1, 0xFF, 2, 3})
+}
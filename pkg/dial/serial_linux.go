@@ -0,0 +1,92 @@
+package dial
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/tarm/serial"
+	"golang.org/x/sys/unix"
+)
+
+// SerialConn is a serial port with LineControl. The modem lines belong to
+// the tty, so they are driven by ioctl on a second fd of it.
+type SerialConn struct {
+	*serial.Port
+	ctl  *os.File
+	line *lineConfig
+}
+
+var _ LineControl = new(SerialConn)
+
+func openSerial(c *serial.Config, line *lineConfig) (*SerialConn, error) {
+	port, err := serial.OpenPort(c)
+	if err != nil {
+		return nil, err
+	}
+	ctl, err := os.OpenFile(c.Name, os.O_RDWR|unix.O_NOCTTY|unix.O_NONBLOCK, 0)
+	if err != nil {
+		port.Close()
+		return nil, err
+	}
+	sc := &SerialConn{Port: port, ctl: ctl, line: line}
+
+	if line.rtscts {
+		err = sc.setRTSCTS()
+	}
+	if err == nil {
+		err = line.apply(sc)
+	}
+	if err != nil {
+		sc.Close()
+		return nil, err
+	}
+	return sc, nil
+}
+
+func (sc *SerialConn) setRTSCTS() error {
+	fd := int(sc.ctl.Fd())
+	t, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err != nil {
+		return os.NewSyscallError("TCGETS", err)
+	}
+	t.Cflag |= unix.CRTSCTS
+	return os.NewSyscallError("TCSETS", unix.IoctlSetTermios(fd, unix.TCSETS, t))
+}
+
+func (sc *SerialConn) setModemBit(bit int, on bool) error {
+	req, name := uint(unix.TIOCMBIC), "TIOCMBIC"
+	if on {
+		req, name = unix.TIOCMBIS, "TIOCMBIS"
+	}
+	return os.NewSyscallError(name, unix.IoctlSetPointerInt(int(sc.ctl.Fd()), req, bit))
+}
+
+func (sc *SerialConn) SetDTR(on bool) error { return sc.setModemBit(unix.TIOCM_DTR, on) }
+
+func (sc *SerialConn) SetRTS(on bool) error { return sc.setModemBit(unix.TIOCM_RTS, on) }
+
+// Break holds the tx line low for d.
+func (sc *SerialConn) Break(ctx context.Context, d time.Duration) error {
+	fd := int(sc.ctl.Fd())
+	err := unix.IoctlSetInt(fd, unix.TIOCSBRK, 0)
+	if err != nil {
+		return os.NewSyscallError("TIOCSBRK", err)
+	}
+	waitErr := sleepContext(ctx, d)
+	err = unix.IoctlSetInt(fd, unix.TIOCCBRK, 0)
+	if err != nil {
+		return os.NewSyscallError("TIOCCBRK", err)
+	}
+	return waitErr
+}
+
+func (sc *SerialConn) ResetLine(ctx context.Context) error {
+	return sc.line.resetLine(ctx, sc)
+}
+
+func (sc *SerialConn) Close() error {
+	err := sc.Port.Close()
+	sc.ctl.Close()
+	return err
+}
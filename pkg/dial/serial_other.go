@@ -0,0 +1,29 @@
+//go:build !linux
+// +build !linux
+
+package dial
+
+import (
+	"errors"
+
+	"github.com/tarm/serial"
+)
+
+var errLineControl = errors.New("serial line control is only supported on linux")
+
+// SerialConn is a serial port, LineControl is only supported on linux.
+type SerialConn struct {
+	*serial.Port
+	line *lineConfig
+}
+
+func openSerial(c *serial.Config, line *lineConfig) (*SerialConn, error) {
+	if line.dtr != nil || line.rts != nil || line.rtscts {
+		return nil, errLineControl
+	}
+	port, err := serial.OpenPort(c)
+	if err != nil {
+		return nil, err
+	}
+	return &SerialConn{Port: port, line: line}, nil
+}
@@ -0,0 +1,158 @@
+package dial
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// SysfsRoot is walked by usb:// and ListUSBSerial, tests point it to a fake
+// tree.
+var SysfsRoot = "/sys"
+
+// USBSerial is an attached usb tty with the attributes of its usb device.
+type USBSerial struct {
+	// Device like /dev/ttyACM0
+	Device string
+	// Vid and Pid are lower case hex like 0483
+	Vid          string
+	Pid          string
+	Serial       string
+	Manufacturer string
+	Product      string
+	// BusPath is the usb device name like 1-1.2, which changes with the port.
+	BusPath string
+}
+
+func (d *USBSerial) String() string {
+	return fmt.Sprintf("%s(vid=%s pid=%s serial=%s path=%s)", d.Device, d.Vid, d.Pid, d.Serial, d.BusPath)
+}
+
+// ListUSBSerial enumerates the usb serial devices under SysfsRoot.
+func ListUSBSerial() ([]*USBSerial, error) {
+	return listUSBSerial(SysfsRoot)
+}
+
+func listUSBSerial(root string) ([]*USBSerial, error) {
+	classDir := filepath.Join(root, "class", "tty")
+	entries, err := ioutil.ReadDir(classDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var found []*USBSerial
+	for _, e := range entries {
+		device, err := filepath.EvalSymlinks(filepath.Join(classDir, e.Name(), "device"))
+		if err != nil {
+			// virtual tty
+			continue
+		}
+		usbDir := findUSBDevice(root, device)
+		if usbDir == "" {
+			continue
+		}
+		found = append(found, &USBSerial{
+			Device:       filepath.Join("/dev", e.Name()),
+			Vid:          readSysfsAttr(usbDir, "idVendor"),
+			Pid:          readSysfsAttr(usbDir, "idProduct"),
+			Serial:       readSysfsAttr(usbDir, "serial"),
+			Manufacturer: readSysfsAttr(usbDir, "manufacturer"),
+			Product:      readSysfsAttr(usbDir, "product"),
+			BusPath:      filepath.Base(usbDir),
+		})
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].Device < found[j].Device })
+	return found, nil
+}
+
+// findUSBDevice walks up from the tty device to the usb device which owns
+// idVendor. ttyACM links to the interface, ttyUSB to a port under it.
+func findUSBDevice(root, dir string) string {
+	root = filepath.Clean(root)
+	for dir != root && dir != "/" && dir != "." {
+		_, err := os.Stat(filepath.Join(dir, "idVendor"))
+		if err == nil {
+			return dir
+		}
+		dir = filepath.Dir(dir)
+	}
+	return ""
+}
+
+func readSysfsAttr(dir, name string) string {
+	b, err := ioutil.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// usbConfig of usb://?vid=0483&pid=5740&serial=XYZ&baud=115200, the serial
+// params are the same as serial://.
+type usbConfig struct {
+	vid    string
+	pid    string
+	serial string
+}
+
+func toUSB(u *url.URL) (*usbConfig, error) {
+	if u.Host != "" || u.Path != "" {
+		return nil, fmt.Errorf("usb cannot contain host or path: %s", u)
+	}
+	q := u.Query()
+	c := usbConfig{
+		vid:    strings.ToLower(q.Get("vid")),
+		pid:    strings.ToLower(q.Get("pid")),
+		serial: q.Get("serial"),
+	}
+	if c.vid == "" && c.pid == "" && c.serial == "" {
+		return nil, fmt.Errorf("usb requires vid, pid or serial: %s", u)
+	}
+	_, err := toSerial(&url.URL{RawQuery: u.RawQuery})
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (c *usbConfig) match(d *USBSerial) bool {
+	return (c.vid == "" || c.vid == d.Vid) &&
+		(c.pid == "" || c.pid == d.Pid) &&
+		(c.serial == "" || c.serial == d.Serial)
+}
+
+// resolveUSB returns the serial:// url of the only device matching u,
+// ErrDeviceAbsent is wrapped if none matches.
+func resolveUSB(root string, u *url.URL) (*url.URL, error) {
+	c, err := toUSB(u)
+	if err != nil {
+		return nil, err
+	}
+	all, err := listUSBSerial(root)
+	if err != nil {
+		return nil, err
+	}
+
+	var matched []*USBSerial
+	for _, d := range all {
+		if c.match(d) {
+			matched = append(matched, d)
+		}
+	}
+	switch len(matched) {
+	case 0:
+		return nil, fmt.Errorf("%w: no usb serial matches %s", ErrDeviceAbsent, u)
+	case 1:
+		return &url.URL{Scheme: "serial", Path: matched[0].Device, RawQuery: u.RawQuery}, nil
+	}
+
+	candidates := make([]string, len(matched))
+	for i, d := range matched {
+		candidates[i] = d.String()
+	}
+	return nil, fmt.Errorf("ambiguous usb serial %s, candidates: %s", u, strings.Join(candidates, ", "))
+}
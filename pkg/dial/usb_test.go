@@ -0,0 +1,116 @@
+package dial
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gobot.io/x/gobot/gobottest"
+)
+
+// fakeUSBTree builds /sys/class/tty links of a ttyACM and two ttyUSB with the
+// same vid:pid, plus a virtual tty.
+func fakeUSBTree(t *testing.T) string {
+	root := t.TempDir()
+	usb := filepath.Join(root, "devices", "pci0000:00", "usb1")
+	devices := []struct {
+		tty, bus, port string
+		attrs          map[string]string
+	}{
+		{"ttyACM0", "1-1", "1-1:1.0", map[string]string{"idVendor": "0483", "idProduct": "5740", "serial": "XYZ", "product": "STM32"}},
+		{"ttyUSB0", "1-2", "1-2:1.0/ttyUSB0", map[string]string{"idVendor": "1a86", "idProduct": "7523"}},
+		{"ttyUSB1", "1-3", "1-3:1.0/ttyUSB1", map[string]string{"idVendor": "1a86", "idProduct": "7523"}},
+	}
+	for _, d := range devices {
+		busDir := filepath.Join(usb, d.bus)
+		portDir := filepath.Join(busDir, d.port)
+		gobottest.Assert(t, os.MkdirAll(portDir, 0755), nil)
+		for k, v := range d.attrs {
+			gobottest.Assert(t, ioutil.WriteFile(filepath.Join(busDir, k), []byte(v+"\n"), 0644), nil)
+		}
+		classDir := filepath.Join(root, "class", "tty", d.tty)
+		gobottest.Assert(t, os.MkdirAll(classDir, 0755), nil)
+		gobottest.Assert(t, os.Symlink(portDir, filepath.Join(classDir, "device")), nil)
+	}
+	gobottest.Assert(t, os.MkdirAll(filepath.Join(root, "class", "tty", "tty0"), 0755), nil)
+	return root
+}
+
+func TestListUSBSerial(t *testing.T) {
+	all, err := listUSBSerial(fakeUSBTree(t))
+	gobottest.Assert(t, err, nil)
+	gobottest.Assert(t, len(all), 3)
+	gobottest.Assert(t, *all[0], USBSerial{
+		Device:  "/dev/ttyACM0",
+		Vid:     "0483",
+		Pid:     "5740",
+		Serial:  "XYZ",
+		Product: "STM32",
+		BusPath: "1-1",
+	})
+	gobottest.Assert(t, all[2].Device, "/dev/ttyUSB1")
+	gobottest.Assert(t, all[2].BusPath, "1-3")
+}
+
+func TestDeviceUSB(t *testing.T) {
+	sysfs := SysfsRoot
+	SysfsRoot = fakeUSBTree(t)
+	defer func() { SysfsRoot = sysfs }()
+
+	dev, err := Device("usb://?serial=XYZ")
+	gobottest.Assert(t, err, nil)
+	gobottest.Assert(t, dev, "/dev/ttyACM0")
+
+	// fails like dialing, instead of taking the first one
+	_, err = Device("usb://?vid=1a86&pid=7523")
+	gobottest.Refute(t, err, nil)
+	gobottest.Assert(t, errors.Is(err, ErrDeviceAbsent), false)
+	gobottest.Assert(t, strings.Contains(err.Error(), "ambiguous"), true)
+
+	_, err = Device("fault+usb://?serial=none&seed=1")
+	gobottest.Assert(t, errors.Is(err, ErrDeviceAbsent), true)
+}
+
+func TestResolveUSB(t *testing.T) {
+	root := fakeUSBTree(t)
+
+	u, _ := url.Parse("usb://?vid=0483&pid=5740&serial=XYZ&baud=115200")
+	su, err := resolveUSB(root, u)
+	gobottest.Assert(t, err, nil)
+	gobottest.Assert(t, su.String(), "serial:///dev/ttyACM0?vid=0483&pid=5740&serial=XYZ&baud=115200")
+
+	u, _ = url.Parse("usb://?vid=1A86")
+	_, err = resolveUSB(root, u)
+	gobottest.Refute(t, err, nil)
+	gobottest.Assert(t, strings.Contains(err.Error(), "/dev/ttyUSB0"), true)
+	gobottest.Assert(t, strings.Contains(err.Error(), "/dev/ttyUSB1"), true)
+
+	u, _ = url.Parse("usb://?serial=none")
+	_, err = resolveUSB(root, u)
+	gobottest.Assert(t, errors.Is(err, ErrDeviceAbsent), true)
+
+	gobottest.Refute(t, Validate("usb://?baud=115200"), nil)
+	gobottest.Assert(t, Validate("usb://?vid=0483&baud=115200"), nil)
+}
+
+func TestPollHotplug(t *testing.T) {
+	root := fakeUSBTree(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := pollHotplug(ctx, root, 10*time.Millisecond)
+
+	gobottest.Assert(t, os.RemoveAll(filepath.Join(root, "class", "tty", "ttyUSB1")), nil)
+	gobottest.Assert(t, <-events, HotplugEvent{Action: HotplugRemove, Device: "/dev/ttyUSB1"})
+
+	classDir := filepath.Join(root, "class", "tty", "ttyUSB1")
+	gobottest.Assert(t, os.MkdirAll(classDir, 0755), nil)
+	portDir := filepath.Join(root, "devices", "pci0000:00", "usb1", "1-3", "1-3:1.0", "ttyUSB1")
+	gobottest.Assert(t, os.Symlink(portDir, filepath.Join(classDir, "device")), nil)
+	gobottest.Assert(t, <-events, HotplugEvent{Action: HotplugAdd, Device: "/dev/ttyUSB1"})
+}
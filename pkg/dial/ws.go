@@ -0,0 +1,114 @@
+package dial
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+
+	"golang.org/x/net/websocket"
+)
+
+// wsParams are consumed by Dial, others are sent to the server.
+var wsParams = []string{"timeout", "keep_alive", "origin",
+	"ca", "cert", "key", "server_name", "insecure"}
+
+func toWebsocket(u *url.URL) (*tcpConfig, error) {
+	if u.Host == "" {
+		return nil, fmt.Errorf("empty websocket host: %s", u)
+	}
+	c := tcpConfig{addr: u.Host}
+	err := c.parseTimeouts(u)
+	if err != nil {
+		return nil, err
+	}
+	if u.Port() == "" {
+		port := "80"
+		if u.Scheme == "wss" {
+			port = "443"
+		}
+		c.addr = net.JoinHostPort(u.Hostname(), port)
+	}
+	return &c, nil
+}
+
+func validateWebsocket(u *url.URL) error {
+	_, err := toWebsocket(u)
+	if err == nil && u.Scheme == "wss" {
+		_, err = toTLSConfig(u)
+	}
+	return err
+}
+
+// dialWebsocket sends firmata bytes in binary frames.
+func dialWebsocket(ctx context.Context, u *url.URL) (*websocket.Conn, error) {
+	c, err := toWebsocket(u)
+	if err != nil {
+		return nil, err
+	}
+
+	q := u.Query()
+	origin := q.Get("origin")
+	if origin == "" {
+		origin = "http://" + u.Host
+	}
+	location := *u
+	for _, p := range wsParams {
+		q.Del(p)
+	}
+	location.RawQuery = q.Encode()
+
+	config, err := websocket.NewConfig(location.String(), origin)
+	if err != nil {
+		return nil, err
+	}
+
+	var conn net.Conn
+	if u.Scheme == "wss" {
+		var tc *tls.Config
+		tc, err = toTLSConfig(u)
+		if err != nil {
+			return nil, err
+		}
+		conn, err = dialTLS(ctx, c, tc)
+	} else {
+		conn, err = c.dialer().DialContext(ctx, "tcp", c.addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// the websocket handshake has no context
+	stop := closeOnDone(ctx, conn)
+	ws, err := websocket.NewClient(config, conn)
+	if !stop() && err == nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	ws.PayloadType = websocket.BinaryFrame
+	return ws, nil
+}
+
+// closeOnDone closes conn if ctx is done before stop called. stop returns
+// false if conn has been closed.
+func closeOnDone(ctx context.Context, conn net.Conn) (stop func() bool) {
+	done := make(chan struct{})
+	closed := make(chan bool, 1)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+			closed <- true
+		case <-done:
+			closed <- false
+		}
+	}()
+	return func() bool {
+		close(done)
+		return !<-closed
+	}
+}
@@ -0,0 +1,35 @@
+package dial
+
+import (
+	"net/url"
+	"testing"
+
+	"gobot.io/x/gobot/gobottest"
+)
+
+func TestToWebsocket(t *testing.T) {
+	cases := []struct {
+		url  string
+		addr string
+		ok   bool
+	}{
+		{"ws://10.0.0.2/firmata", "10.0.0.2:80", true},
+		{"wss://10.0.0.2/firmata", "10.0.0.2:443", true},
+		{"ws://10.0.0.2:8080/firmata?timeout=2s", "10.0.0.2:8080", true},
+		{"ws://[fe80::1]/firmata", "[fe80::1]:80", true},
+		{"ws:///firmata", "", false},
+		{"ws://10.0.0.2/firmata?keep_alive=x", "", false},
+	}
+	for _, c := range cases {
+		u, err := url.Parse(c.url)
+		gobottest.Assert(t, err, nil)
+		tc, err := toWebsocket(u)
+		if (err == nil) != c.ok {
+			t.Errorf("%s: unexpected error: %v", c.url, err)
+			continue
+		}
+		if c.ok && tc.addr != c.addr {
+			t.Errorf("%s: got addr %s", c.url, tc.addr)
+		}
+	}
+}
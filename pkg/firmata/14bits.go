@@ -24,6 +24,34 @@ func From14bits(in []byte) (out []byte) {
 	return out
 }
 
+// Encode7bit packs in to 7-bit bytes like Encoder7Bit.writeBinary of
+// firmata.
+func Encode7bit(in []byte) (out []byte) {
+	out = make([]byte, 0, (len(in)*8+6)/7)
+	var shift uint
+	var previous byte
+	for _, b := range in {
+		if shift == 0 {
+			out = append(out, b&0x7F)
+			shift++
+			previous = b >> 7
+		} else {
+			out = append(out, ((b<<shift)&0x7F)|previous)
+			if shift == 6 {
+				out = append(out, b>>1)
+				shift = 0
+			} else {
+				shift++
+				previous = b >> (8 - shift)
+			}
+		}
+	}
+	if shift > 0 {
+		out = append(out, previous)
+	}
+	return out
+}
+
 func To14bits(in []byte) (out []byte) {
 	lenIn := len(in)
 	out = make([]byte, lenIn*2)
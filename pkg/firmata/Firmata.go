@@ -2,9 +2,11 @@ package firmata
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"sync"
+	"time"
 
 	"github.com/empirefox/firmata/pkg/pb"
 )
@@ -41,8 +43,47 @@ type Firmata struct {
 
 	// TODO report?
 	PortConfigInputs_l [16]byte
+
+	// pinStateQueries_l are the PIN_STATE_QUERY waiting responses by pin.
+	pinStateQueries_l map[byte][]*pinStateQuery
+
+	// loadingCache_l is true while HandshakeCache.Load is running.
+	loadingCache_l bool
+	// pendingCachedStates_l counts PIN_STATE_RESPONSE before a cached handshake is done.
+	pendingCachedStates_l int
+	// cacheTimer_l falls back to the full handshake if the cached one stalls.
+	cacheTimer_l *time.Timer
+	// verifyingCache_l is true until the fresh CAPABILITY_RESPONSE after a cached handshake.
+	verifyingCache_l bool
+	// awaitingSerial_l is true after UD_BOARD_SERIAL_REQUEST sent.
+	awaitingSerial_l bool
+	// hardResets_l are closed when the handshake after HardReset is done.
+	hardResets_l []chan struct{}
+}
+
+// LineResetter is implemented by conns which can reset the board by the
+// serial lines, like dial.SerialConn. Wrapper conns like dial.CaptureConn are
+// unwrapped by Unwrap() io.ReadWriteCloser to find it.
+type LineResetter interface {
+	ResetLine(ctx context.Context) error
+}
+
+// lineResetterOf unwraps c until a LineResetter is found.
+func lineResetterOf(c io.Closer) (LineResetter, bool) {
+	for {
+		if r, ok := c.(LineResetter); ok {
+			return r, true
+		}
+		u, ok := c.(interface{ Unwrap() io.ReadWriteCloser })
+		if !ok {
+			return nil, false
+		}
+		c = u.Unwrap()
+	}
 }
 
+var ErrNoLineResetter = errors.New("conn cannot reset the board")
+
 type Config struct {
 	OnConnected      func(f *Firmata)
 	OnAnalogMessage  func(f *Firmata, pin *Pin)
@@ -53,6 +94,20 @@ type Config struct {
 	OnSysexResponse  func(f *Firmata, buf []byte)
 	Data             interface{}
 	SamplingInterval uint32
+
+	// HandshakeCache skips capability, analog mapping and pin names queries
+	// on reconnecting if not nil.
+	HandshakeCache HandshakeCache
+	// CacheId identifies the board in the HandshakeCache key, like the board
+	// serial or the firmata name, boards must not share it.
+	CacheId                     string
+	OnHandshakeCacheInvalidated func(f *Firmata, key string)
+	// OnHandshakeCacheError is called with the failures of the best effort
+	// HandshakeCache, maybe out of the loop.
+	OnHandshakeCacheError func(f *Firmata, key string, err error)
+
+	// Identity is verified at handshake if not nil.
+	Identity *pb.Firmata_Identity
 }
 
 func Connect(ctx context.Context, c io.ReadWriteCloser, config *Config) (*Firmata, error) {
@@ -105,7 +160,11 @@ func (f *Firmata) Handshake(ctx context.Context) (err error) {
 	select {
 	case <-f.handshakeOK:
 	case <-f.doneServing:
-		err = ErrClosed
+		// written before doneServing closed
+		err = f.ClosedError_l
+		if err == nil {
+			err = ErrClosed
+		}
 	case <-ctx.Done():
 		err = ctx.Err()
 	}
@@ -113,19 +172,35 @@ func (f *Firmata) Handshake(ctx context.Context) (err error) {
 }
 
 func (f *Firmata) WaitLoop(fn func() error) (err error) {
-	var wg sync.WaitGroup
-	wg.Add(1)
+	return f.WaitLoopContext(context.Background(), fn)
+}
 
-	err1 := f.Loop(func() {
-		defer wg.Done()
-		err = fn()
-	})
-	if err1 != nil {
-		return err1
+// WaitLoopContext runs fn in the serve loop and waits for its result. It
+// returns ctx.Err() if ctx is done before fn returns, but fn may still run
+// later if it was already queued.
+func (f *Firmata) WaitLoopContext(ctx context.Context, fn func() error) error {
+	done := make(chan error, 1)
+	select {
+	case f.loopCh <- func() { done <- fn() }:
+	case <-f.doneServing:
+		return ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
 	}
 
-	wg.Wait()
-	return err
+	select {
+	case err := <-done:
+		return err
+	case <-f.doneServing:
+		select {
+		case err := <-done:
+			return err
+		default:
+			return ErrClosed
+		}
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 func (f *Firmata) SnapshotPin(pin byte) (p *Pin, ok bool, err error) {
@@ -183,6 +258,59 @@ func (f *Firmata) Reset_l() (err error) {
 		return
 	}
 
+	f.clearHandshake_l()
+	err = f.reportInit_l()
+	return err
+}
+
+// HardReset pulses the reset line of the conn, then handshakes again like
+// Reset_l. OnConnected is called again when done, the Firmata is closed if
+// failed after resetting. ErrNoLineResetter is returned without touching the
+// Firmata if the conn cannot reset the board.
+func (f *Firmata) HardReset(ctx context.Context) (err error) {
+	r, ok := lineResetterOf(f.closer)
+	if !ok {
+		return ErrNoLineResetter
+	}
+
+	done := make(chan struct{})
+	err = f.WaitLoopContext(ctx, func() error {
+		f.clearHandshake_l()
+		// Booting of the resetting board must not close it
+		f.handshaking_l = true
+		f.hardResets_l = append(f.hardResets_l, done)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+		}
+	}()
+
+	err = r.ResetLine(ctx)
+	if err != nil {
+		return err
+	}
+	err = f.WaitLoopContext(ctx, f.reportInit_l)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case <-done:
+		return nil
+	case <-f.doneServing:
+		return ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// clearHandshake_l forgets the board, so that reportInit_l starts over.
+func (f *Firmata) clearHandshake_l() {
 	f.ClosedError_l = nil
 	f.VersionInfo = VersionInfo{}
 	f.DxByName = nil
@@ -192,10 +320,13 @@ func (f *Firmata) Reset_l() (err error) {
 	f.TotalPins = 0
 	f.TotalAnalogPins = 0
 	f.PortConfigInputs_l = [16]byte{}
+	f.pinStateQueries_l = nil
+	f.loadingCache_l = false
+	f.pendingCachedStates_l = 0
+	f.stopCacheTimer_l()
+	f.verifyingCache_l = false
+	f.awaitingSerial_l = false
 	f.connectedOnce = sync.Once{}
-
-	err = f.reportInit_l()
-	return err
 }
 
 // SetPinMode sets the pin to mode.
@@ -243,16 +374,25 @@ func (f *Firmata) SetDigitalPinValue_l(pin byte, value byte) error {
 	if value > 1 {
 		return fmt.Errorf("SetDigitalPinValue pin %d accept 0/1, but got: %d", pin, value)
 	}
-	if f.Pins[pin].Value_l == uint32(value) {
+	p := f.Pins[pin]
+	if p.Value_l == uint32(value) {
 		return nil
 	}
 	err := f.writer.SetDigitalPinValue(pin, value)
 	if err == nil {
-		f.Pins[pin].Value_l = uint32(value)
+		f.setDigitalPinValue_l(p, value)
 	}
 	return err
 }
 
+// src/DigitalOutputFirmata.cpp setPinValue
+func (f *Firmata) setDigitalPinValue_l(p *Pin, value byte) {
+	p.Value_l = uint32(value)
+	if p.Mode_l == PIN_MODE_OUTPUT {
+		p.State_l = uint32(value)
+	}
+}
+
 // SetDigitalPinHigh sets the pin to 1.
 func (f *Firmata) SetDigitalPinHigh_l(pin byte) error {
 	return f.SetDigitalPinValue_l(pin, 1)
@@ -341,14 +481,17 @@ func (f *Firmata) AnalogWrite_l(pin byte, value uint32) (err error) {
 		err = f.writer.AnalogWrite(pin, value)
 	}
 	if err == nil {
+		// src/AnalogOutputFirmata.cpp
 		f.Pins[pin].Value_l = value
+		f.Pins[pin].State_l = value
 	}
 	return err
 }
 
 // PinStateQuery sends a PinStateQuery for pin.
 func (f *Firmata) PinStateQuery_l(pin byte) error {
-	return f.writer.PinStateQuery(pin)
+	_, err := f.queryPinState_l(pin, nil)
+	return err
 }
 
 // PinState_l sends a PinStateQuery for pin.
@@ -483,6 +626,55 @@ func (f *Firmata) StringWrite_l(data []byte) error {
 	return f.writer.StringWrite(data)
 }
 
+// SysexWrite_l writes a raw sysex message, data[0] is the sysex command and
+// every byte must be 7 bits.
+func (f *Firmata) SysexWrite_l(data []byte) error {
+	if len(data) == 0 || len(data) > MaxRecvSize-2 {
+		return fmt.Errorf("invalid sysex size: %d", len(data))
+	}
+	for _, b := range data {
+		if b&0x80 != 0 {
+			return fmt.Errorf("sysex byte is not 7 bits: %#x", b)
+		}
+	}
+	return f.writer.Sysex(data)
+}
+
+// schedulerChunk is the raw bytes of every SCHEDULER_ADD_TO_TASK, which fit
+// in the 64 bytes sysex buffer of AVR boards after encoding.
+const schedulerChunk = 42
+
+// UploadTask_l replaces the scheduler task id with raw firmata messages, the
+// task is not scheduled.
+func (f *Firmata) UploadTask_l(id byte, messages []byte) error {
+	err := f.writer.SchedulerDeleteTask(id)
+	if err != nil {
+		return err
+	}
+	err = f.writer.SchedulerCreateTask(id, len(messages))
+	if err != nil {
+		return err
+	}
+	for len(messages) > 0 {
+		n := len(messages)
+		if n > schedulerChunk {
+			n = schedulerChunk
+		}
+		err = f.writer.SchedulerAddToTask(id, messages[:n])
+		if err != nil {
+			return err
+		}
+		messages = messages[n:]
+	}
+	return nil
+}
+
+// ScheduleTask_l runs the task id after ms, calling it again postpones the
+// task.
+func (f *Firmata) ScheduleTask_l(id byte, ms uint32) error {
+	return f.writer.SchedulerScheduleTask(id, ms)
+}
+
 // SamplingInterval sets how often analog data and i2c data is reported to the
 // client. The default for the arduino implementation is 19ms. This means that
 // every 19ms analog data will be reported and any i2c devices with read
@@ -40,6 +40,8 @@ const (
 	// 0x00-0x0F reserved for user-defined commands
 	UD_PIN_NAMES_REQUEST    byte = 0x06
 	UD_PIN_NAMES_REPLY      byte = 0x07
+	UD_BOARD_SERIAL_REQUEST byte = 0x08
+	UD_BOARD_SERIAL_REPLY   byte = 0x09
 	SERIAL_MESSAGE          byte = 0x60 // communicate with serial devices, including other boards
 	ENCODER_DATA            byte = 0x61 // reply with encoders current positions
 	ACCELSTEPPER_DATA       byte = 0x62 // control a stepper motor
@@ -92,6 +94,14 @@ const (
 	PIN_MODE_FREQUENCY byte = 0x10 // pin configured for frequency measurement
 
 	PIN_MODE_IGNORE byte = 0x7F // pin configured to be ignored by digitalWrite and capabilityResponse
+
+	// sub commands of SCHEDULER_DATA
+	SCHEDULER_CREATE_TASK   byte = 0x00 // create a task of the length
+	SCHEDULER_DELETE_TASK   byte = 0x01 // delete a task
+	SCHEDULER_ADD_TO_TASK   byte = 0x02 // append 7-bit encoded messages to a task
+	SCHEDULER_DELAY_TASK    byte = 0x03 // delay the running task
+	SCHEDULER_SCHEDULE_TASK byte = 0x04 // run a task after ms
+	SCHEDULER_RESET         byte = 0x07 // delete all tasks
 	// Modifed 16 -> 17 to include SHIFT
 	TOTAL_PIN_MODES byte = 0x11
 
@@ -0,0 +1,301 @@
+package firmata
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// HandshakeCache stores the pin table of boards, so the handshake can skip
+// capability, analog mapping and pin names queries. It is best effort.
+type HandshakeCache interface {
+	// Load returns nil entry if not found.
+	Load(key string) (*HandshakeCacheEntry, error)
+	Store(key string, e *HandshakeCacheEntry) error
+	Delete(key string) error
+}
+
+type HandshakeCacheEntry struct {
+	TotalPorts byte        `json:"totalPorts"`
+	Pins       []CachedPin `json:"pins"`
+}
+
+type CachedPin struct {
+	Ax    byte          `json:"ax"`
+	Name  PinName       `json:"name"`
+	Modes map[byte]byte `json:"modes"`
+}
+
+// cachedHandshakeTimeout limits waiting the PIN_STATE_RESPONSE of every pin
+// after the cached pin table is restored.
+var cachedHandshakeTimeout = 2 * time.Second
+
+// HandshakeCacheKey identifies the pin table by the board and firmware.
+func (f *Firmata) HandshakeCacheKey() string {
+	fw := f.FirmwareVersion.GetServer()
+	return fmt.Sprintf("%s/%s@%d.%d", f.Config.CacheId, fw.GetName(), fw.GetMajor(), fw.GetMinor())
+}
+
+func (f *Firmata) handshakeCacheError(key string, err error) {
+	if f.Config.OnHandshakeCacheError != nil {
+		f.Config.OnHandshakeCacheError(f, key, err)
+	}
+}
+
+// loadHandshakeCache_l loads the pin table out of the loop, then goes on with
+// the cached handshake or the full one. Frames are ignored while loading.
+func (f *Firmata) loadHandshakeCache_l() bool {
+	c := f.Config.HandshakeCache
+	if c == nil {
+		return false
+	}
+	key := f.HandshakeCacheKey()
+	fw := f.FirmwareVersion
+	f.loadingCache_l = true
+	go func() {
+		e, err := c.Load(key)
+		f.loopClose(func() error {
+			// the handshake started over while loading
+			if !f.loadingCache_l || f.FirmwareVersion != fw {
+				return nil
+			}
+			f.loadingCache_l = false
+			if err != nil {
+				f.handshakeCacheError(key, err)
+			}
+			if err == nil && f.restoreHandshakeCache_l(e) {
+				return f.queryCachedPinStates_l()
+			}
+			return f.reportInit_l()
+		})
+	}()
+	return true
+}
+
+// restoreHandshakeCache_l restores the pin table of e.
+func (f *Firmata) restoreHandshakeCache_l(e *HandshakeCacheEntry) bool {
+	if e == nil || len(e.Pins) == 0 || len(e.Pins) > 0xFF {
+		return false
+	}
+
+	totalPins := byte(len(e.Pins))
+	pins := make([]*Pin, totalPins)
+	analogPins := make([]*Pin, 0, totalPins)
+	dxByName := make(map[PinName]byte, totalPins)
+	for i, cp := range e.Pins {
+		dx := byte(i)
+		pin := &Pin{
+			Dx:     dx,
+			Ax:     cp.Ax,
+			Name:   cp.Name,
+			Modes:  cp.Modes,
+			Mode_l: PIN_MODE_OUTPUT,
+		}
+		pins[i] = pin
+		if pin.IsAnalog() {
+			analogPins = append(analogPins, pin)
+		}
+		dxByName[cp.Name] = dx
+	}
+
+	f.Pins = pins
+	f.TotalPins = totalPins
+	f.TotalPorts = e.TotalPorts
+	f.AnalogPins = analogPins
+	f.TotalAnalogPins = byte(len(analogPins))
+	f.DxByName = dxByName
+	return true
+}
+
+// queryCachedPinStates_l sends PIN_STATE_QUERY for every restored pin. The
+// full handshake starts over if they are not all responded in time.
+func (f *Firmata) queryCachedPinStates_l() error {
+	f.pendingCachedStates_l = int(f.TotalPins)
+	for i := byte(0); i < f.TotalPins; i++ {
+		_, err := f.queryPinState_l(i, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	var timer *time.Timer
+	timer = time.AfterFunc(cachedHandshakeTimeout, func() {
+		f.loopClose(func() error {
+			if f.cacheTimer_l != timer {
+				return nil
+			}
+			return f.fallbackHandshake_l()
+		})
+	})
+	f.cacheTimer_l = timer
+	return nil
+}
+
+// fallbackHandshake_l drops the restored pin table, and starts the full
+// handshake.
+func (f *Firmata) fallbackHandshake_l() error {
+	f.handshakeCacheError(f.HandshakeCacheKey(),
+		fmt.Errorf("PIN_STATE_RESPONSE of %d pins timeout", f.pendingCachedStates_l))
+	f.stopCacheTimer_l()
+	f.pendingCachedStates_l = 0
+	f.DxByName = nil
+	f.Pins = nil
+	f.AnalogPins = nil
+	f.TotalPorts = 0
+	f.TotalPins = 0
+	f.TotalAnalogPins = 0
+	f.PortConfigInputs_l = [16]byte{}
+	f.pinStateQueries_l = nil
+	return f.reportInit_l()
+}
+
+func (f *Firmata) stopCacheTimer_l() {
+	if f.cacheTimer_l != nil {
+		f.cacheTimer_l.Stop()
+		f.cacheTimer_l = nil
+	}
+}
+
+// onCachedPinState_l finishes the cached handshake after the last
+// PIN_STATE_RESPONSE.
+func (f *Firmata) onCachedPinState_l() error {
+	if f.pendingCachedStates_l == 0 {
+		return nil
+	}
+	f.pendingCachedStates_l--
+	if f.pendingCachedStates_l != 0 {
+		return nil
+	}
+	f.stopCacheTimer_l()
+
+	err := f.verifyPins_l()
+	if err != nil {
+		return err
+	}
+	f.verifyingCache_l = true
+	err = f.finishHandshake_l()
+	if err != nil {
+		return err
+	}
+	return f.writer.CapabilitiesQuery()
+}
+
+// verifyHandshakeCache_l closes the connection if the fresh capability
+// differs from the cached one, so the next handshake starts from scratch.
+func (f *Firmata) verifyHandshakeCache_l(data *CapabilityFrameData) error {
+	f.verifyingCache_l = false
+	if sameCapability(f.Pins, data) {
+		return nil
+	}
+
+	key := f.HandshakeCacheKey()
+	c := f.Config.HandshakeCache
+	go func() {
+		err := c.Delete(key)
+		if err != nil {
+			f.handshakeCacheError(key, err)
+		}
+	}()
+	if f.Config.OnHandshakeCacheInvalidated != nil {
+		f.Config.OnHandshakeCacheInvalidated(f, key)
+	}
+	return fmt.Errorf("handshake cache invalidated: %s", key)
+}
+
+func sameCapability(pins []*Pin, data *CapabilityFrameData) bool {
+	if len(pins) != len(data.Pins) || byte((len(pins)+7)/8) != data.TotalPorts {
+		return false
+	}
+	for i, pin := range pins {
+		modes := data.Pins[i].Modes
+		if len(pin.Modes) != len(modes) {
+			return false
+		}
+		for k, v := range pin.Modes {
+			if vv, ok := modes[k]; !ok || v != vv {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// storeHandshakeCache_l must be called after a full handshake, it stores out
+// of the loop.
+func (f *Firmata) storeHandshakeCache_l() {
+	c := f.Config.HandshakeCache
+	if c == nil {
+		return
+	}
+	e := HandshakeCacheEntry{
+		TotalPorts: f.TotalPorts,
+		Pins:       make([]CachedPin, f.TotalPins),
+	}
+	for i, pin := range f.Pins {
+		modes := make(map[byte]byte, len(pin.Modes))
+		for k, v := range pin.Modes {
+			modes[k] = v
+		}
+		e.Pins[i] = CachedPin{
+			Ax:    pin.Ax,
+			Name:  pin.Name,
+			Modes: modes,
+		}
+	}
+	key := f.HandshakeCacheKey()
+	go func() {
+		err := c.Store(key, &e)
+		if err != nil {
+			f.handshakeCacheError(key, err)
+		}
+	}()
+}
+
+// FileHandshakeCache stores every entry as a json file under Dir.
+type FileHandshakeCache struct {
+	Dir string
+}
+
+func (c FileHandshakeCache) path(key string) string {
+	return filepath.Join(c.Dir, url.PathEscape(key)+".json")
+}
+
+func (c FileHandshakeCache) Load(key string) (*HandshakeCacheEntry, error) {
+	b, err := os.ReadFile(c.path(key))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var e HandshakeCacheEntry
+	err = json.Unmarshal(b, &e)
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+func (c FileHandshakeCache) Store(key string, e *HandshakeCacheEntry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(c.Dir, 0755)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(c.path(key), b, 0644)
+}
+
+func (c FileHandshakeCache) Delete(key string) error {
+	err := os.Remove(c.path(key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,136 @@
+package firmata
+
+import (
+	"context"
+	"fmt"
+)
+
+// PinSnapshot is an immutable copy of a Pin, taken in the serve loop.
+type PinSnapshot struct {
+	Dx       byte
+	Ax       byte
+	Name     PinName
+	Mode     byte
+	Value    uint32
+	State    uint32
+	Filtered uint32
+
+	// modes is shared with Pin, it is never modified after CAPABILITY_RESPONSE.
+	modes map[byte]byte
+}
+
+func (pin *Pin) Snapshot_l() PinSnapshot {
+	return PinSnapshot{
+		Dx:       pin.Dx,
+		Ax:       pin.Ax,
+		Name:     pin.Name,
+		Mode:     pin.Mode_l,
+		Value:    pin.Value_l,
+		State:    pin.State_l,
+		Filtered: pin.Filtered_l,
+		modes:    pin.Modes,
+	}
+}
+
+func (p PinSnapshot) SupportMode(mode byte) (ok bool) {
+	_, ok = p.modes[mode]
+	return
+}
+
+// Resolution returns the resolution of mode, ok is false if mode is not supported.
+func (p PinSnapshot) Resolution(mode byte) (resolution byte, ok bool) {
+	resolution, ok = p.modes[mode]
+	return
+}
+
+func (p PinSnapshot) IsAnalog() bool {
+	return p.Ax != 127
+}
+
+// The methods below are safe to be called from any goroutine. They run in the
+// serve loop and return when ctx is done.
+
+// SetMode sets the pin to mode.
+func (f *Firmata) SetMode(ctx context.Context, pin byte, mode byte) error {
+	return f.WaitLoopContext(ctx, func() error {
+		return f.SetPinMode_l(pin, mode)
+	})
+}
+
+// DigitalWrite sets the digital pin to value(0/1).
+func (f *Firmata) DigitalWrite(ctx context.Context, pin byte, value byte) error {
+	return f.WaitLoopContext(ctx, func() error {
+		return f.SetDigitalPinValue_l(pin, value)
+	})
+}
+
+// AnalogWrite writes value to pin.
+func (f *Firmata) AnalogWrite(ctx context.Context, pin byte, value uint32) error {
+	return f.WaitLoopContext(ctx, func() error {
+		return f.AnalogWrite_l(pin, value)
+	})
+}
+
+// WritePin writes value to pin by AnalogWrite or DigitalWrite, and returns the
+// snapshot after writing.
+func (f *Firmata) WritePin(ctx context.Context, pin byte, value uint32) (p PinSnapshot, err error) {
+	err = f.WaitLoopContext(ctx, func() error {
+		err := f.SetPinValue_l(pin, value)
+		if err != nil {
+			return err
+		}
+		p = f.Pins[pin].Snapshot_l()
+		return nil
+	})
+	return
+}
+
+// ReadPin returns the snapshot of digital pin.
+func (f *Firmata) ReadPin(ctx context.Context, pin byte) (p PinSnapshot, err error) {
+	err = f.WaitLoopContext(ctx, func() error {
+		if pin >= f.TotalPins {
+			return fmt.Errorf("ReadPin pin out of index: %d", pin)
+		}
+		p = f.Pins[pin].Snapshot_l()
+		return nil
+	})
+	return
+}
+
+// ReadAnalogPin returns the snapshot of analog pin.
+func (f *Firmata) ReadAnalogPin(ctx context.Context, pin byte) (p PinSnapshot, err error) {
+	err = f.WaitLoopContext(ctx, func() error {
+		if pin >= f.TotalAnalogPins {
+			return fmt.Errorf("ReadAnalogPin pin out of index: %d", pin)
+		}
+		p = f.AnalogPins[pin].Snapshot_l()
+		return nil
+	})
+	return
+}
+
+// ReadPins returns snapshots of all pins.
+func (f *Firmata) ReadPins(ctx context.Context) (pins []PinSnapshot, err error) {
+	err = f.WaitLoopContext(ctx, func() error {
+		pins = make([]PinSnapshot, len(f.Pins))
+		for i, pin := range f.Pins {
+			pins[i] = pin.Snapshot_l()
+		}
+		return nil
+	})
+	return
+}
+
+// ReportDigital enables or disables digital reporting for port.
+func (f *Firmata) ReportDigital(ctx context.Context, port byte, enable bool) error {
+	return f.WaitLoopContext(ctx, func() error {
+		return f.ReportDigital_l(port, enable)
+	})
+}
+
+// ReportAnalog enables or disables analog reporting for analog pin.
+func (f *Firmata) ReportAnalog(ctx context.Context, pin byte, enable bool) error {
+	return f.WaitLoopContext(ctx, func() error {
+		return f.ReportAnalog_l(pin, enable)
+	})
+}
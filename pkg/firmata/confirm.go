@@ -0,0 +1,269 @@
+package firmata
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/empirefox/firmata/pkg/pb"
+)
+
+const (
+	DefaultConfirmRetries = 2
+	DefaultConfirmTimeout = 500 * time.Millisecond
+)
+
+// ConfirmOptions controls how a write is read back by PIN_STATE_QUERY.
+type ConfirmOptions struct {
+	// Retries is how many times to write again after the first attempt.
+	Retries int
+	// Timeout waits for every PIN_STATE_RESPONSE.
+	Timeout time.Duration
+}
+
+// NewConfirmOptions returns nil if c is nil.
+func NewConfirmOptions(c *pb.Group_Confirm) *ConfirmOptions {
+	if c == nil {
+		return nil
+	}
+	opts := ConfirmOptions{
+		Retries: DefaultConfirmRetries,
+		Timeout: time.Duration(c.TimeoutMs) * time.Millisecond,
+	}
+	if c.Retries != nil {
+		opts.Retries = int(*c.Retries)
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = DefaultConfirmTimeout
+	}
+	return &opts
+}
+
+// ConfirmedSetMode sets the pin to mode, then waits the board to confirm it.
+func (f *Firmata) ConfirmedSetMode(ctx context.Context, pin byte, mode byte, opts ConfirmOptions) error {
+	return f.confirm(ctx, pin, opts,
+		func(p *Pin) error {
+			if !p.SupportMode(mode) {
+				return fmt.Errorf("unsupported mode, pin: %d", pin)
+			}
+			err := f.writer.SetPinMode(pin, mode)
+			if err == nil {
+				f.handlePinMode_l(pin, mode)
+			}
+			return err
+		},
+		func(p *Pin, st *PinStateFrameData) bool {
+			return st.Mode == mode
+		})
+}
+
+// ConfirmedDigitalWrite sets the pin to value(0/1), then waits the board to
+// confirm it.
+func (f *Firmata) ConfirmedDigitalWrite(ctx context.Context, pin byte, value byte, opts ConfirmOptions) error {
+	if value > 1 {
+		return fmt.Errorf("ConfirmedDigitalWrite pin %d accept 0/1, but got: %d", pin, value)
+	}
+	return f.confirm(ctx, pin, opts,
+		func(p *Pin) error {
+			err := f.writer.SetDigitalPinValue(pin, value)
+			if err == nil {
+				f.setDigitalPinValue_l(p, value)
+			}
+			return err
+		},
+		func(p *Pin, st *PinStateFrameData) bool {
+			if st.State != uint32(value) {
+				return false
+			}
+			p.Value_l = uint32(value)
+			return true
+		})
+}
+
+// ConfirmedWritePin writes value to pin like SetPinValue_l, then waits the
+// board to confirm it.
+func (f *Firmata) ConfirmedWritePin(ctx context.Context, pin byte, value uint32, opts ConfirmOptions) (snapshot PinSnapshot, err error) {
+	err = f.confirm(ctx, pin, opts,
+		func(p *Pin) error {
+			if p.IsAnalog() {
+				return f.AnalogWrite_l(pin, value)
+			}
+			if value > 1 {
+				return fmt.Errorf("ConfirmedWritePin pin %d accept 0/1, but got: %d", pin, value)
+			}
+			err := f.writer.SetDigitalPinValue(pin, byte(value))
+			if err == nil {
+				f.setDigitalPinValue_l(p, byte(value))
+			}
+			return err
+		},
+		func(p *Pin, st *PinStateFrameData) bool {
+			if st.State != value {
+				return false
+			}
+			p.Value_l = value
+			snapshot = p.Snapshot_l()
+			return true
+		})
+	return
+}
+
+// confirm runs write in the serve loop followed by a PIN_STATE_QUERY, then
+// checks the PIN_STATE_RESPONSE in the serve loop. write must not skip writing
+// even if the cached value is the same. The cached pin is rolled back if it is
+// not confirmed at last.
+func (f *Firmata) confirm(ctx context.Context, pin byte, opts ConfirmOptions,
+	write func(*Pin) error, check func(*Pin, *PinStateFrameData) bool) (err error) {
+	if opts.Timeout == 0 {
+		opts.Timeout = DefaultConfirmTimeout
+	}
+
+	var saved *savedPin
+	// answered is set if the board told the real state of the last attempt.
+	var answered bool
+	defer func() {
+		if err != nil && saved != nil && err != ErrClosed {
+			f.WaitLoop(func() error {
+				saved.restore_l(answered)
+				return nil
+			})
+		}
+	}()
+
+	var lastErr error
+	for attempt := 0; attempt <= opts.Retries; attempt++ {
+		ch := make(chan bool, 1)
+		var q *pinStateQuery
+		err = f.WaitLoopContext(ctx, func() error {
+			if pin >= f.TotalPins {
+				return fmt.Errorf("confirm pin out of index: %d", pin)
+			}
+			p := f.Pins[pin]
+			if saved == nil {
+				saved = f.savePin_l(p)
+			}
+			err := write(p)
+			if err != nil {
+				return err
+			}
+			q, err = f.queryPinState_l(pin, func(st *PinStateFrameData) {
+				ch <- check(p, st)
+			})
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		timer := time.NewTimer(opts.Timeout)
+		select {
+		case ok := <-ch:
+			timer.Stop()
+			if ok {
+				return nil
+			}
+			answered = true
+			lastErr = fmt.Errorf("pin %d state mismatch", pin)
+		case <-timer.C:
+			f.abandonPinStateQuery(q)
+			answered = false
+			lastErr = fmt.Errorf("pin %d state response timeout", pin)
+		case <-ctx.Done():
+			timer.Stop()
+			f.abandonPinStateQuery(q)
+			answered = false
+			return ctx.Err()
+		case <-f.doneServing:
+			timer.Stop()
+			return ErrClosed
+		}
+	}
+	return fmt.Errorf("pin %d not confirmed after %d attempts: %v",
+		pin, opts.Retries+1, lastErr)
+}
+
+// savedPin is the cached state of a pin before a confirmed write.
+type savedPin struct {
+	f     *Firmata
+	p     *Pin
+	mode  byte
+	value uint32
+	state uint32
+	input byte
+}
+
+func (f *Firmata) savePin_l(p *Pin) *savedPin {
+	return &savedPin{
+		f:     f,
+		p:     p,
+		mode:  p.Mode_l,
+		value: p.Value_l,
+		state: p.State_l,
+		input: f.PortConfigInputs_l[p.Dx/8] & (1 << (p.Dx & 7)),
+	}
+}
+
+// restore_l restores the saved state. If answered, the PIN_STATE_RESPONSE
+// has set the mode and state, only the value is restored when the mode is
+// unchanged.
+func (s *savedPin) restore_l(answered bool) {
+	p := s.p
+	if answered {
+		if p.Mode_l == s.mode {
+			p.Value_l = s.value
+		}
+		return
+	}
+	p.Mode_l, p.Value_l, p.State_l = s.mode, s.value, s.state
+	bit := byte(1) << (p.Dx & 7)
+	s.f.PortConfigInputs_l[p.Dx/8] = s.f.PortConfigInputs_l[p.Dx/8]&^bit | s.input
+}
+
+// pinStateQuery is a sent PIN_STATE_QUERY. The board answers the queries of
+// a pin in order, so every PIN_STATE_RESPONSE belongs to the oldest query of
+// its pin.
+type pinStateQuery struct {
+	// fn is nil if nobody waits the response. Such a query is kept until its
+	// response arrives, so the response is never taken by a later query.
+	fn func(*PinStateFrameData)
+}
+
+// queryPinState_l sends a PIN_STATE_QUERY, fn is called once with its
+// PIN_STATE_RESPONSE unless abandoned.
+func (f *Firmata) queryPinState_l(pin byte, fn func(*PinStateFrameData)) (*pinStateQuery, error) {
+	err := f.writer.PinStateQuery(pin)
+	if err != nil {
+		return nil, err
+	}
+	if f.pinStateQueries_l == nil {
+		f.pinStateQueries_l = make(map[byte][]*pinStateQuery)
+	}
+	q := &pinStateQuery{fn: fn}
+	f.pinStateQueries_l[pin] = append(f.pinStateQueries_l[pin], q)
+	return q, nil
+}
+
+// abandonPinStateQuery stops waiting q. q stays queued, so its late response
+// will not be taken as the response of a later query.
+func (f *Firmata) abandonPinStateQuery(q *pinStateQuery) {
+	f.WaitLoop(func() error {
+		q.fn = nil
+		return nil
+	})
+}
+
+func (f *Firmata) notifyPinState_l(data *PinStateFrameData) {
+	queries := f.pinStateQueries_l[data.Pin]
+	if len(queries) == 0 {
+		return
+	}
+	q := queries[0]
+	if len(queries) == 1 {
+		delete(f.pinStateQueries_l, data.Pin)
+	} else {
+		f.pinStateQueries_l[data.Pin] = queries[1:]
+	}
+	if q.fn != nil {
+		q.fn(data)
+	}
+}
@@ -0,0 +1,102 @@
+package device
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+
+	"github.com/empirefox/firmata/pkg/firmata"
+)
+
+// Command is a message sent by the host.
+type Command struct {
+	// Type is the command byte without the port/pin nibble.
+	Type byte
+	// Pin is the pin or port of SET_PIN_MODE, SET_DIGITAL_PIN_VALUE,
+	// DIGITAL_MESSAGE, ANALOG_MESSAGE, REPORT_ANALOG and REPORT_DIGITAL.
+	Pin byte
+	// Value of two 7-bit bytes, or the single byte of mode/enable.
+	Value uint32
+	// Sysex is the data between START_SYSEX and END_SYSEX, Sysex[0] is the
+	// sysex command.
+	Sysex []byte
+}
+
+// CommandReader parses the host side commands of WriteFramer.
+type CommandReader struct {
+	r   *bufio.Reader
+	buf [firmata.MaxRecvSize]byte
+}
+
+func NewCommandReader(r io.Reader) *CommandReader {
+	return &CommandReader{r: bufio.NewReader(r)}
+}
+
+func (cr *CommandReader) ReadCommand() (*Command, error) {
+	for {
+		b, err := cr.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case b == firmata.REPORT_VERSION || b == firmata.SYSTEM_RESET:
+			return &Command{Type: b}, nil
+		case b == firmata.SET_PIN_MODE || b == firmata.SET_DIGITAL_PIN_VALUE:
+			data, err := cr.read(2)
+			if err != nil {
+				return nil, err
+			}
+			return &Command{Type: b, Pin: data[0], Value: uint32(data[1])}, nil
+		case b == firmata.START_SYSEX:
+			data, err := cr.readSysex()
+			if err != nil {
+				return nil, err
+			}
+			return &Command{Type: b, Sysex: data}, nil
+		case b&0xF0 == firmata.DIGITAL_MESSAGE || b&0xF0 == firmata.ANALOG_MESSAGE:
+			data, err := cr.read(2)
+			if err != nil {
+				return nil, err
+			}
+			return &Command{
+				Type:  b & 0xF0,
+				Pin:   b & 0x0F,
+				Value: uint32(data[0]&0x7F) | uint32(data[1]&0x7F)<<7,
+			}, nil
+		case b&0xF0 == firmata.REPORT_ANALOG || b&0xF0 == firmata.REPORT_DIGITAL:
+			data, err := cr.read(1)
+			if err != nil {
+				return nil, err
+			}
+			return &Command{Type: b & 0xF0, Pin: b & 0x0F, Value: uint32(data[0])}, nil
+		default:
+			// data byte out of sync, skip it like firmware does
+		}
+	}
+}
+
+func (cr *CommandReader) read(n int) ([]byte, error) {
+	_, err := io.ReadFull(cr.r, cr.buf[:n])
+	return cr.buf[:n], err
+}
+
+func (cr *CommandReader) readSysex() ([]byte, error) {
+	n := 0
+	for {
+		b, err := cr.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if b == firmata.END_SYSEX {
+			data := make([]byte, n)
+			copy(data, cr.buf[:n])
+			return data, nil
+		}
+		if n == len(cr.buf) {
+			return nil, fmt.Errorf("sysex exceeds %d bytes", len(cr.buf))
+		}
+		cr.buf[n] = b
+		n++
+	}
+}
@@ -0,0 +1,460 @@
+// Package device implements the board side of the firmata protocol, so the
+// host side can run against a virtual or emulated board.
+package device
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/empirefox/firmata/pkg/firmata"
+)
+
+const (
+	DefaultFirmwareName     = "VirtualFirmata"
+	DefaultSamplingInterval = 19 * time.Millisecond
+
+	// replyQueueSize decouples replies from reading, net.Pipe is synchronous.
+	replyQueueSize = 1024
+)
+
+// PinConfig is a row of the declarative pin table, the index is Dx.
+type PinConfig struct {
+	Name firmata.PinName
+	// Ax is the analog channel, 127 if not an analog pin.
+	Ax byte
+	// Modes maps supported mode to resolution.
+	Modes map[byte]byte
+}
+
+// DigitalPin supports INPUT, OUTPUT and PULLUP.
+func DigitalPin(name firmata.PinName) PinConfig {
+	return PinConfig{
+		Name: name,
+		Ax:   127,
+		Modes: map[byte]byte{
+			firmata.PIN_MODE_INPUT:  1,
+			firmata.PIN_MODE_OUTPUT: 1,
+			firmata.PIN_MODE_PULLUP: 1,
+		},
+	}
+}
+
+// AnalogPin is a DigitalPin which supports 10 bits ANALOG on channel ax.
+func AnalogPin(name firmata.PinName, ax byte) PinConfig {
+	pin := DigitalPin(name)
+	pin.Ax = ax
+	pin.Modes[firmata.PIN_MODE_ANALOG] = 10
+	return pin
+}
+
+type Config struct {
+	FirmwareName  string
+	FirmwareMajor byte
+	FirmwareMinor byte
+	// Serial answers UD_BOARD_SERIAL_REQUEST if not empty.
+	Serial string
+	Pins   []PinConfig
+	// IO defaults to a MemoryIO.
+	IO IO
+	// SamplingInterval defaults to DefaultSamplingInterval, the host may
+	// change it by SAMPLING_INTERVAL.
+	SamplingInterval time.Duration
+}
+
+type pinState struct {
+	mode  byte
+	state uint32
+}
+
+// Device answers the host commands read from rw.
+type Device struct {
+	config  Config
+	rw      io.ReadWriter
+	replies chan []byte
+
+	mu               sync.Mutex
+	pins             []pinState
+	reportDigital    [16]bool
+	reportAnalog     [16]bool
+	lastPorts        [16]byte
+	samplingInterval time.Duration
+	intervalChanged  chan struct{}
+}
+
+func New(rw io.ReadWriter, config *Config) *Device {
+	d := &Device{
+		config:          *config,
+		rw:              rw,
+		replies:         make(chan []byte, replyQueueSize),
+		pins:            make([]pinState, len(config.Pins)),
+		intervalChanged: make(chan struct{}, 1),
+	}
+	if d.config.FirmwareName == "" {
+		d.config.FirmwareName = DefaultFirmwareName
+	}
+	if d.config.IO == nil {
+		d.config.IO = NewMemoryIO(len(config.Pins))
+	}
+	d.samplingInterval = d.config.SamplingInterval
+	if d.samplingInterval <= 0 {
+		d.samplingInterval = DefaultSamplingInterval
+	}
+	d.reset()
+	return d
+}
+
+// IO returns the backend of the device.
+func (d *Device) IO() IO { return d.config.IO }
+
+// Serve blocks until rw fails or ctx is done. rw is closed on ctx done if it
+// is an io.Closer.
+func (d *Device) Serve(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if c, ok := d.rw.(io.Closer); ok {
+		go func() {
+			<-ctx.Done()
+			c.Close()
+		}()
+	}
+
+	errCh := make(chan error, 2)
+	go func() { errCh <- d.writeReplies(ctx) }()
+	go func() { errCh <- d.readCommands(ctx) }()
+	go d.report(ctx)
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (d *Device) writeReplies(ctx context.Context) error {
+	for {
+		select {
+		case b := <-d.replies:
+			if _, err := d.rw.Write(b); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (d *Device) readCommands(ctx context.Context) error {
+	cr := NewCommandReader(d.rw)
+	for {
+		cmd, err := cr.ReadCommand()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		d.handle(ctx, cmd)
+	}
+}
+
+func (d *Device) send(ctx context.Context, b ...byte) {
+	select {
+	case d.replies <- b:
+	case <-ctx.Done():
+	}
+}
+
+// sendString reports backend errors to the host as STRING_DATA.
+func (d *Device) sendString(ctx context.Context, err error) {
+	if err == nil {
+		return
+	}
+	s := []byte(err.Error())
+	if len(s) > firmata.MaxStringDataBytes {
+		s = s[:firmata.MaxStringDataBytes]
+	}
+	d.send(ctx, StringReply(s)...)
+}
+
+func (d *Device) handle(ctx context.Context, cmd *Command) {
+	switch cmd.Type {
+	case firmata.REPORT_VERSION:
+		d.send(ctx, VersionReply(firmata.FIRMATA_PROTOCOL_MAJOR_VERSION,
+			firmata.FIRMATA_PROTOCOL_MINOR_VERSION)...)
+	case firmata.SYSTEM_RESET:
+		d.mu.Lock()
+		d.reset()
+		d.mu.Unlock()
+	case firmata.SET_PIN_MODE:
+		d.sendString(ctx, d.setPinMode(cmd.Pin, byte(cmd.Value)))
+	case firmata.SET_DIGITAL_PIN_VALUE:
+		d.sendString(ctx, d.digitalWrite(cmd.Pin, byte(cmd.Value)))
+	case firmata.DIGITAL_MESSAGE:
+		d.sendString(ctx, d.portWrite(cmd.Pin, byte(cmd.Value)))
+	case firmata.ANALOG_MESSAGE:
+		d.sendString(ctx, d.analogWrite(cmd.Pin, cmd.Value))
+	case firmata.REPORT_ANALOG:
+		d.mu.Lock()
+		d.reportAnalog[cmd.Pin] = cmd.Value != 0
+		d.mu.Unlock()
+	case firmata.REPORT_DIGITAL:
+		d.mu.Lock()
+		d.reportDigital[cmd.Pin] = cmd.Value != 0
+		values, err := d.readPort(cmd.Pin)
+		d.lastPorts[cmd.Pin] = values
+		d.mu.Unlock()
+		if err != nil {
+			d.sendString(ctx, err)
+		} else if cmd.Value != 0 {
+			// firmware reports the port at once on enabling
+			d.send(ctx, DigitalMessage(cmd.Pin, values)...)
+		}
+	case firmata.START_SYSEX:
+		d.handleSysex(ctx, cmd.Sysex)
+	}
+}
+
+func (d *Device) handleSysex(ctx context.Context, data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	switch data[0] {
+	case firmata.REPORT_FIRMWARE:
+		d.send(ctx, FirmwareReply(d.config.FirmwareName,
+			d.config.FirmwareMajor, d.config.FirmwareMinor)...)
+	case firmata.CAPABILITY_QUERY:
+		d.send(ctx, CapabilityReply(d.config.Pins)...)
+	case firmata.ANALOG_MAPPING_QUERY:
+		d.send(ctx, AnalogMappingReply(d.config.Pins)...)
+	case firmata.PIN_STATE_QUERY:
+		if len(data) < 2 || int(data[1]) >= len(d.pins) {
+			return
+		}
+		pin := data[1]
+		d.mu.Lock()
+		p := d.pins[pin]
+		d.mu.Unlock()
+		d.send(ctx, PinStateReply(pin, p.mode, p.state)...)
+	case firmata.UD_PIN_NAMES_REQUEST:
+		d.send(ctx, PinNamesReply(d.config.Pins)...)
+	case firmata.UD_BOARD_SERIAL_REQUEST:
+		if d.config.Serial != "" {
+			d.send(ctx, BoardSerialReply(d.config.Serial)...)
+		}
+	case firmata.EXTENDED_ANALOG:
+		if len(data) < 3 {
+			return
+		}
+		var value uint32
+		for i, b := range data[2:] {
+			value |= uint32(b&0x7F) << (7 * uint(i))
+		}
+		d.sendString(ctx, d.analogWrite(data[1], value))
+	case firmata.SAMPLING_INTERVAL:
+		if len(data) < 3 {
+			return
+		}
+		ms := uint32(data[1]&0x7F) | uint32(data[2]&0x7F)<<7
+		if ms == 0 {
+			return
+		}
+		d.mu.Lock()
+		d.samplingInterval = time.Duration(ms) * time.Millisecond
+		d.mu.Unlock()
+		select {
+		case d.intervalChanged <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// reset restores the power-on state, must hold mu except in New.
+func (d *Device) reset() {
+	for dx, pin := range d.config.Pins {
+		mode := firmata.PIN_MODE_INPUT
+		if _, ok := pin.Modes[firmata.PIN_MODE_ANALOG]; ok && pin.Ax != 127 {
+			mode = firmata.PIN_MODE_ANALOG
+		} else if _, ok := pin.Modes[firmata.PIN_MODE_OUTPUT]; ok {
+			mode = firmata.PIN_MODE_OUTPUT
+		}
+		d.pins[dx] = pinState{mode: mode}
+		d.config.IO.PinMode(byte(dx), mode)
+	}
+	d.reportDigital = [16]bool{}
+	d.reportAnalog = [16]bool{}
+	d.lastPorts = [16]byte{}
+}
+
+func (d *Device) setPinMode(pin byte, mode byte) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if int(pin) >= len(d.pins) {
+		return errors.New("SET_PIN_MODE: invalid pin")
+	}
+	if _, ok := d.config.Pins[pin].Modes[mode]; !ok {
+		return errors.New("SET_PIN_MODE: unsupported mode")
+	}
+	err := d.config.IO.PinMode(pin, mode)
+	if err != nil {
+		return err
+	}
+	state := uint32(0)
+	if mode == firmata.PIN_MODE_PULLUP {
+		state = 1
+	}
+	d.pins[pin] = pinState{mode: mode, state: state}
+	return nil
+}
+
+func (d *Device) digitalWrite(pin byte, value byte) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.digitalWrite_l(pin, value)
+}
+
+func (d *Device) digitalWrite_l(pin byte, value byte) error {
+	if int(pin) >= len(d.pins) {
+		return errors.New("digital write: invalid pin")
+	}
+	if value != 0 {
+		value = 1
+	}
+	p := &d.pins[pin]
+	switch p.mode {
+	case firmata.PIN_MODE_OUTPUT:
+		err := d.config.IO.DigitalWrite(pin, value)
+		if err != nil {
+			return err
+		}
+		p.state = uint32(value)
+	case firmata.PIN_MODE_INPUT, firmata.PIN_MODE_PULLUP:
+		// writing to an input pin toggles the pullup like firmware does
+		if _, ok := d.config.Pins[pin].Modes[firmata.PIN_MODE_PULLUP]; !ok {
+			return nil
+		}
+		mode := firmata.PIN_MODE_INPUT
+		if value != 0 {
+			mode = firmata.PIN_MODE_PULLUP
+		}
+		err := d.config.IO.PinMode(pin, mode)
+		if err != nil {
+			return err
+		}
+		p.mode = mode
+		p.state = uint32(value)
+	}
+	return nil
+}
+
+func (d *Device) portWrite(port byte, values byte) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	start := int(port) * 8
+	for i := 0; i < 8 && start+i < len(d.pins); i++ {
+		err := d.digitalWrite_l(byte(start+i), values>>uint(i)&1)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *Device) analogWrite(pin byte, value uint32) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if int(pin) >= len(d.pins) {
+		return errors.New("analog write: invalid pin")
+	}
+	p := &d.pins[pin]
+	switch p.mode {
+	case firmata.PIN_MODE_PWM, firmata.PIN_MODE_SERVO:
+		err := d.config.IO.AnalogWrite(pin, value)
+		if err != nil {
+			return err
+		}
+		p.state = value
+	}
+	return nil
+}
+
+// readPort must hold mu.
+func (d *Device) readPort(port byte) (values byte, err error) {
+	start := int(port) * 8
+	for i := 0; i < 8 && start+i < len(d.pins); i++ {
+		pin := byte(start + i)
+		switch d.pins[pin].mode {
+		case firmata.PIN_MODE_INPUT, firmata.PIN_MODE_PULLUP:
+			v, err := d.config.IO.DigitalRead(pin)
+			if err != nil {
+				return 0, err
+			}
+			if v != 0 {
+				values |= 1 << uint(i)
+			}
+		}
+	}
+	return values, nil
+}
+
+func (d *Device) report(ctx context.Context) {
+	d.mu.Lock()
+	interval := d.samplingInterval
+	d.mu.Unlock()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-d.intervalChanged:
+			d.mu.Lock()
+			interval = d.samplingInterval
+			d.mu.Unlock()
+			ticker.Reset(interval)
+		case <-ticker.C:
+			for _, b := range d.sample(ctx) {
+				d.send(ctx, b...)
+			}
+		}
+	}
+}
+
+// sample collects changed digital ports and all enabled analog channels.
+func (d *Device) sample(ctx context.Context) (reports [][]byte) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for port, enabled := range d.reportDigital {
+		if !enabled {
+			continue
+		}
+		values, err := d.readPort(byte(port))
+		if err != nil {
+			go d.sendString(ctx, err)
+			continue
+		}
+		if values != d.lastPorts[port] {
+			d.lastPorts[port] = values
+			reports = append(reports, DigitalMessage(byte(port), values))
+		}
+	}
+
+	for dx, pin := range d.config.Pins {
+		if pin.Ax > 15 || !d.reportAnalog[pin.Ax] || d.pins[dx].mode != firmata.PIN_MODE_ANALOG {
+			continue
+		}
+		value, err := d.config.IO.AnalogRead(byte(dx))
+		if err != nil {
+			go d.sendString(ctx, err)
+			continue
+		}
+		reports = append(reports, AnalogMessage(pin.Ax, value))
+	}
+	return reports
+}
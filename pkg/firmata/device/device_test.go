@@ -0,0 +1,84 @@
+package device
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/empirefox/firmata/pkg/firmata"
+	"github.com/empirefox/firmata/pkg/pb"
+	"gobot.io/x/gobot/gobottest"
+)
+
+func testDevice(t *testing.T, config *firmata.Config) (*firmata.Firmata, *MemoryIO, context.CancelFunc) {
+	host, board := net.Pipe()
+	mem := NewMemoryIO(4)
+	d := New(board, &Config{
+		FirmwareMajor: 2,
+		FirmwareMinor: 3,
+		Serial:        "SN01",
+		Pins: []PinConfig{
+			DigitalPin(pb.PinName_PA0),
+			DigitalPin(pb.PinName_PA1),
+			AnalogPin(pb.PinName_PA2, 0),
+			AnalogPin(pb.PinName_PA3, 1),
+		},
+		IO:               mem,
+		SamplingInterval: time.Millisecond,
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	go d.Serve(ctx)
+
+	hctx, hcancel := context.WithTimeout(ctx, time.Second)
+	defer hcancel()
+	f, err := firmata.Connect(hctx, host, config)
+	gobottest.Assert(t, err, nil)
+	return f, mem, cancel
+}
+
+func TestDeviceHandshake(t *testing.T) {
+	f, _, cancel := testDevice(t, &firmata.Config{
+		Identity: &pb.Firmata_Identity{FirmwareName: DefaultFirmwareName, Serial: "SN01"},
+	})
+	defer cancel()
+
+	gobottest.Assert(t, f.TotalPins, byte(4))
+	gobottest.Assert(t, f.TotalAnalogPins, byte(2))
+	gobottest.Assert(t, f.BoardSerial, "SN01")
+	gobottest.Assert(t, f.DxByName[pb.PinName_PA3], byte(3))
+	pin, err := f.ReadPin(context.Background(), 2)
+	gobottest.Assert(t, err, nil)
+	gobottest.Assert(t, pin.Mode, firmata.PIN_MODE_ANALOG)
+}
+
+func TestDeviceWriteAndReport(t *testing.T) {
+	analog := make(chan uint32, 16)
+	f, mem, cancel := testDevice(t, &firmata.Config{
+		OnAnalogMessage: func(f *firmata.Firmata, pin *firmata.Pin) {
+			if pin.Ax == 1 {
+				select {
+				case analog <- pin.Value_l:
+				default:
+				}
+			}
+		},
+	})
+	defer cancel()
+	ctx := context.Background()
+
+	err := f.SetMode(ctx, 0, firmata.PIN_MODE_OUTPUT)
+	gobottest.Assert(t, err, nil)
+	err = f.ConfirmedDigitalWrite(ctx, 0, 1, firmata.ConfirmOptions{})
+	gobottest.Assert(t, err, nil)
+	gobottest.Assert(t, mem.Value(0), uint32(1))
+
+	mem.SetInput(3, 512)
+	gobottest.Assert(t, f.ReportAnalog(ctx, 1, true), nil)
+	select {
+	case v := <-analog:
+		gobottest.Assert(t, v, uint32(512))
+	case <-time.After(time.Second):
+		t.Fatal("no analog report")
+	}
+}
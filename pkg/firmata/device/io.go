@@ -0,0 +1,99 @@
+package device
+
+import (
+	"fmt"
+	"sync"
+)
+
+// IO is the backend which drives the real or virtual pins.
+type IO interface {
+	PinMode(pin byte, mode byte) error
+	DigitalWrite(pin byte, value byte) error
+	DigitalRead(pin byte) (byte, error)
+	AnalogWrite(pin byte, value uint32) error
+	AnalogRead(pin byte) (uint32, error)
+}
+
+// MemoryIO is a virtual IO, inputs are set by SetInput.
+type MemoryIO struct {
+	mu     sync.Mutex
+	modes  []byte
+	values []uint32
+}
+
+func NewMemoryIO(totalPins int) *MemoryIO {
+	return &MemoryIO{
+		modes:  make([]byte, totalPins),
+		values: make([]uint32, totalPins),
+	}
+}
+
+// SetInput simulates the external signal of pin.
+func (m *MemoryIO) SetInput(pin byte, value uint32) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if int(pin) < len(m.values) {
+		m.values[pin] = value
+	}
+}
+
+// Value returns the last written or input value of pin.
+func (m *MemoryIO) Value(pin byte) uint32 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if int(pin) < len(m.values) {
+		return m.values[pin]
+	}
+	return 0
+}
+
+// Mode returns the last mode of pin.
+func (m *MemoryIO) Mode(pin byte) byte {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if int(pin) < len(m.modes) {
+		return m.modes[pin]
+	}
+	return 0
+}
+
+func (m *MemoryIO) PinMode(pin byte, mode byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if int(pin) >= len(m.modes) {
+		return fmt.Errorf("PinMode pin out of index: %d", pin)
+	}
+	m.modes[pin] = mode
+	return nil
+}
+
+func (m *MemoryIO) DigitalWrite(pin byte, value byte) error {
+	return m.AnalogWrite(pin, uint32(value))
+}
+
+func (m *MemoryIO) DigitalRead(pin byte) (byte, error) {
+	v, err := m.AnalogRead(pin)
+	if v != 0 {
+		v = 1
+	}
+	return byte(v), err
+}
+
+func (m *MemoryIO) AnalogWrite(pin byte, value uint32) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if int(pin) >= len(m.values) {
+		return fmt.Errorf("write pin out of index: %d", pin)
+	}
+	m.values[pin] = value
+	return nil
+}
+
+func (m *MemoryIO) AnalogRead(pin byte) (uint32, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if int(pin) >= len(m.values) {
+		return 0, fmt.Errorf("read pin out of index: %d", pin)
+	}
+	return m.values[pin], nil
+}
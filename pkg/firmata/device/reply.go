@@ -0,0 +1,85 @@
+package device
+
+import (
+	"github.com/empirefox/firmata/pkg/firmata"
+)
+
+// Encoders of the device replies, the reverse of firmata.ReadFramer.
+
+func Sysex(cmd byte, data []byte) []byte {
+	b := make([]byte, 0, len(data)+3)
+	b = append(b, firmata.START_SYSEX, cmd)
+	b = append(b, data...)
+	return append(b, firmata.END_SYSEX)
+}
+
+func VersionReply(major, minor byte) []byte {
+	return []byte{firmata.REPORT_VERSION, major, minor}
+}
+
+func FirmwareReply(name string, major, minor byte) []byte {
+	b := []byte{major, minor}
+	return Sysex(firmata.REPORT_FIRMWARE, append(b, firmata.To14bits([]byte(name))...))
+}
+
+func CapabilityReply(pins []PinConfig) []byte {
+	var b []byte
+	for _, pin := range pins {
+		for mode := byte(0); mode < firmata.TOTAL_PIN_MODES; mode++ {
+			if res, ok := pin.Modes[mode]; ok {
+				b = append(b, mode, res)
+			}
+		}
+		b = append(b, 0x7F)
+	}
+	return Sysex(firmata.CAPABILITY_RESPONSE, b)
+}
+
+func AnalogMappingReply(pins []PinConfig) []byte {
+	b := make([]byte, len(pins))
+	for dx, pin := range pins {
+		b[dx] = pin.Ax
+	}
+	return Sysex(firmata.ANALOG_MAPPING_RESPONSE, b)
+}
+
+// PinStateReply sends state in 7-bit chunks, at least one.
+func PinStateReply(pin, mode byte, state uint32) []byte {
+	b := []byte{pin, mode, byte(state & 0x7F)}
+	for state >>= 7; state != 0; state >>= 7 {
+		b = append(b, byte(state&0x7F))
+	}
+	return Sysex(firmata.PIN_STATE_RESPONSE, b)
+}
+
+func PinNamesReply(pins []PinConfig) []byte {
+	b := make([]byte, len(pins))
+	for dx, pin := range pins {
+		b[dx] = byte(pin.Name)
+	}
+	return Sysex(firmata.UD_PIN_NAMES_REPLY, firmata.To14bits(b))
+}
+
+func BoardSerialReply(serial string) []byte {
+	return Sysex(firmata.UD_BOARD_SERIAL_REPLY, firmata.To14bits([]byte(serial)))
+}
+
+func StringReply(s []byte) []byte {
+	return Sysex(firmata.STRING_DATA, firmata.To14bits(s))
+}
+
+func I2cReply(reply *firmata.I2cReply) []byte {
+	b := []byte{
+		byte(reply.Address & 0x7F), byte(reply.Address >> 7 & 0x7F),
+		byte(reply.Register & 0x7F), byte(reply.Register >> 7 & 0x7F),
+	}
+	return Sysex(firmata.I2C_REPLY, append(b, firmata.To14bits(reply.Data)...))
+}
+
+func DigitalMessage(port, values byte) []byte {
+	return []byte{firmata.DIGITAL_MESSAGE | port, values & 0x7F, values >> 7}
+}
+
+func AnalogMessage(ax byte, value uint32) []byte {
+	return []byte{firmata.ANALOG_MESSAGE | ax, byte(value & 0x7F), byte(value >> 7 & 0x7F)}
+}
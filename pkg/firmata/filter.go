@@ -0,0 +1,119 @@
+package firmata
+
+import (
+	"time"
+
+	"github.com/empirefox/firmata/pkg/pb"
+)
+
+// AnalogFilter conditions the raw values of an analog input pin. It smooths
+// them by moving average or EMA, holds them by deadband or hysteresis, and
+// limits how often they are emitted.
+type AnalogFilter struct {
+	window []uint32
+	sum    uint64
+	next   int
+	filled int
+
+	alpha   float64
+	ema     float64
+	emaInit bool
+
+	deadband    uint32
+	hysteresis  uint32
+	minInterval time.Duration
+
+	held   uint32
+	heldOK bool
+
+	emitted   uint32
+	emittedAt time.Time
+	emittedOK bool
+}
+
+// NewAnalogFilter returns nil if c is nil.
+func NewAnalogFilter(c *pb.Group_AnalogFilter) *AnalogFilter {
+	if c == nil {
+		return nil
+	}
+	af := &AnalogFilter{
+		deadband:    c.GetDeadband(),
+		hysteresis:  c.GetHysteresis(),
+		minInterval: time.Duration(c.MinIntervalMs) * time.Millisecond,
+	}
+	if n := c.GetMovingAverage(); n > 1 {
+		af.window = make([]uint32, n)
+	}
+	if a := c.GetEma(); a > 0 && a < 1 {
+		af.alpha = float64(a)
+	}
+	return af
+}
+
+// Apply_l feeds raw into the filter, then returns the filtered value and
+// whether it should be emitted at now.
+func (af *AnalogFilter) Apply_l(raw uint32, now time.Time) (filtered uint32, emit bool) {
+	filtered = af.smooth(raw)
+	if af.hysteresis != 0 {
+		filtered = af.hold(filtered)
+	}
+
+	if af.emittedOK {
+		if af.deadband != 0 && absDiff(filtered, af.emitted) < af.deadband {
+			return filtered, false
+		}
+		if af.minInterval != 0 && now.Sub(af.emittedAt) < af.minInterval {
+			return filtered, false
+		}
+	}
+
+	af.emitted = filtered
+	af.emittedAt = now
+	af.emittedOK = true
+	return filtered, true
+}
+
+func (af *AnalogFilter) smooth(raw uint32) uint32 {
+	switch {
+	case af.window != nil:
+		af.sum -= uint64(af.window[af.next])
+		af.sum += uint64(raw)
+		af.window[af.next] = raw
+		af.next = (af.next + 1) % len(af.window)
+		if af.filled < len(af.window) {
+			af.filled++
+		}
+		return uint32(af.sum / uint64(af.filled))
+	case af.alpha != 0:
+		if !af.emaInit {
+			af.ema = float64(raw)
+			af.emaInit = true
+		} else {
+			af.ema += af.alpha * (float64(raw) - af.ema)
+		}
+		return uint32(af.ema + 0.5)
+	default:
+		return raw
+	}
+}
+
+func (af *AnalogFilter) hold(v uint32) uint32 {
+	switch {
+	case !af.heldOK:
+		af.held = v
+		af.heldOK = true
+	// compared by differences, held+hysteresis may overflow
+	case v > af.held && v-af.held > af.hysteresis:
+		af.held = v - af.hysteresis
+	case v < af.held && af.held-v > af.hysteresis:
+		af.held = v + af.hysteresis
+	}
+	return af.held
+}
+
+func absDiff(a, b uint32) uint32 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
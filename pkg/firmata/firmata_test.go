@@ -2,6 +2,9 @@ package firmata
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"math"
 	"sync"
 	"testing"
 	"time"
@@ -415,6 +418,28 @@ func TestReportAnalog(t *testing.T) {
 	gobottest.Assert(t, b.ReportAnalog_l(0, false), nil)
 }
 
+func TestReadCapabilityResponse(t *testing.T) {
+	// D0: INPUT 1, OUTPUT 1; D1: ANALOG 10, PWM 8
+	frame, err := NewReadFramer(bytes.NewReader([]byte{
+		START_SYSEX, CAPABILITY_RESPONSE,
+		PIN_MODE_INPUT, 1, PIN_MODE_OUTPUT, 1, 127,
+		PIN_MODE_ANALOG, 10, PIN_MODE_PWM, 8, 127,
+		END_SYSEX,
+	})).ReadFrame()
+	gobottest.Assert(t, err, nil)
+
+	pins := frame.Data.(*CapabilityFrameData).Pins
+	gobottest.Assert(t, len(pins), 2)
+	gobottest.Assert(t, pins[0].Modes, map[byte]byte{
+		PIN_MODE_INPUT:  1,
+		PIN_MODE_OUTPUT: 1,
+	})
+	gobottest.Assert(t, pins[1].Modes, map[byte]byte{
+		PIN_MODE_ANALOG: 10,
+		PIN_MODE_PWM:    8,
+	})
+}
+
 func TestProcessPinState13(t *testing.T) {
 	b, _ := initTestFirmata()
 	setTestReadData(b, []byte{240, 110, 13, 1, 1, 247})
@@ -425,9 +450,9 @@ func TestProcessPinState13(t *testing.T) {
 			Dx:   13,
 			Name: pb.PinName_PB15,
 			Modes: map[byte]byte{
-				0: 127,
+				0: 1,
 				1: 1,
-				4: 1,
+				4: 14,
 			},
 			Mode_l:  1,
 			Value_l: 0,
@@ -575,3 +600,487 @@ func TestProcessSysexData(t *testing.T) {
 		t.Errorf("SysexResponse was not published")
 	}
 }
+
+func TestAnalogFilter(t *testing.T) {
+	af := NewAnalogFilter(&pb.Group_AnalogFilter{
+		Smoothing:     &pb.Group_AnalogFilter_MovingAverage{MovingAverage: 2},
+		Band:          &pb.Group_AnalogFilter_Deadband{Deadband: 5},
+		MinIntervalMs: 100,
+	})
+	now := time.Now()
+
+	tests := []struct {
+		raw      uint32
+		after    time.Duration
+		filtered uint32
+		emit     bool
+	}{
+		{raw: 100, after: 0, filtered: 100, emit: true},
+		{raw: 102, after: 200 * time.Millisecond, filtered: 101, emit: false},
+		{raw: 120, after: 300 * time.Millisecond, filtered: 111, emit: true},
+		{raw: 130, after: 350 * time.Millisecond, filtered: 125, emit: false},
+		{raw: 130, after: 450 * time.Millisecond, filtered: 130, emit: true},
+	}
+	for _, test := range tests {
+		filtered, emit := af.Apply_l(test.raw, now.Add(test.after))
+		gobottest.Assert(t, filtered, test.filtered)
+		gobottest.Assert(t, emit, test.emit)
+	}
+}
+
+func TestAnalogFilterHysteresis(t *testing.T) {
+	b, _ := initTestFirmata()
+	pin := b.AnalogPins[0]
+	pin.Filter = NewAnalogFilter(&pb.Group_AnalogFilter{
+		Band: &pb.Group_AnalogFilter_Hysteresis{Hysteresis: 3},
+	})
+
+	now := time.Now()
+	for _, v := range [][2]uint32{{500, 500}, {502, 500}, {498, 500}, {504, 501}, {495, 498}} {
+		pin.Value_l = v[0]
+		gobottest.Assert(t, pin.ApplyFilter_l(now), true)
+		gobottest.Assert(t, pin.Filtered_l, v[1])
+		gobottest.Assert(t, pin.Value_l, v[0])
+	}
+
+	// held+hysteresis and v+hysteresis must not wrap
+	af := NewAnalogFilter(&pb.Group_AnalogFilter{
+		Band: &pb.Group_AnalogFilter_Hysteresis{Hysteresis: math.MaxUint32 - 10},
+	})
+	for _, v := range [][2]uint32{{20, 20}, {5, 20}, {math.MaxUint32, 20}, {0, 20}} {
+		filtered, _ := af.Apply_l(v[0], now)
+		gobottest.Assert(t, filtered, v[1])
+	}
+	af = NewAnalogFilter(&pb.Group_AnalogFilter{
+		Band: &pb.Group_AnalogFilter_Hysteresis{Hysteresis: 10},
+	})
+	for _, v := range [][2]uint32{{math.MaxUint32 - 5, math.MaxUint32 - 5},
+		{math.MaxUint32, math.MaxUint32 - 5}, {5, 15}, {0, 10}} {
+		filtered, _ := af.Apply_l(v[0], now)
+		gobottest.Assert(t, filtered, v[1])
+	}
+}
+
+func TestReadPin(t *testing.T) {
+	b, _ := initTestFirmata()
+	go b.serve()
+	defer b.Close()
+
+	ctx := context.Background()
+	gobottest.Assert(t, b.DigitalWrite(ctx, 13, 1), nil)
+	p, err := b.ReadPin(ctx, 13)
+	gobottest.Assert(t, err, nil)
+	gobottest.Assert(t, p.Name, pb.PinName_PB15)
+	gobottest.Assert(t, p.Value, uint32(1))
+	gobottest.Assert(t, p.SupportMode(PIN_MODE_SERVO), true)
+
+	gobottest.Assert(t, b.DigitalWrite(ctx, 13, 0), nil)
+	gobottest.Assert(t, p.Value, uint32(1))
+
+	_, err = b.ReadPin(ctx, 20)
+	gobottest.Refute(t, err, nil)
+}
+
+func TestWaitLoopContext(t *testing.T) {
+	b, _ := initTestFirmata()
+
+	// serve loop not running
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := b.ReadPin(ctx, 13)
+	gobottest.Assert(t, err, context.DeadlineExceeded)
+
+	b.Close()
+	_, err = b.ReadPin(context.Background(), 13)
+	gobottest.Assert(t, err, ErrClosed)
+}
+
+// waitPinStateQuery waits a PIN_STATE_QUERY of pin to be written.
+func waitPinStateQuery(b *Firmata, pin byte) {
+	rwc := b.closer.(*readWriteCloser)
+	query := []byte{START_SYSEX, PIN_STATE_QUERY, pin, END_SYSEX}
+	for {
+		rwc.writeDataMutex.Lock()
+		found := bytes.Contains(rwc.testWriteData.Bytes(), query)
+		rwc.testWriteData.Reset()
+		rwc.writeDataMutex.Unlock()
+		if found {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func respondPinState(b *Firmata, res []byte) {
+	frame, _ := NewReadFramer(bytes.NewReader(res)).ReadFrame()
+	b.Loop(func() { b.proccessFrame(frame) })
+}
+
+// answerPinStateQueries replies every PIN_STATE_QUERY of pin with responses in order.
+func answerPinStateQueries(b *Firmata, pin byte, responses [][]byte) {
+	for _, res := range responses {
+		waitPinStateQuery(b, pin)
+		respondPinState(b, res)
+	}
+}
+
+func TestConfirmedDigitalWrite(t *testing.T) {
+	b, _ := initTestFirmata()
+	go b.serve()
+	defer b.Close()
+
+	go answerPinStateQueries(b, 13, [][]byte{
+		{START_SYSEX, PIN_STATE_RESPONSE, 13, PIN_MODE_OUTPUT, 0, END_SYSEX},
+		{START_SYSEX, PIN_STATE_RESPONSE, 13, PIN_MODE_OUTPUT, 1, END_SYSEX},
+	})
+
+	ctx := context.Background()
+	err := b.ConfirmedDigitalWrite(ctx, 13, 1, ConfirmOptions{Retries: 1, Timeout: time.Second})
+	gobottest.Assert(t, err, nil)
+
+	p, _ := b.ReadPin(ctx, 13)
+	gobottest.Assert(t, p.Value, uint32(1))
+	gobottest.Assert(t, p.State, uint32(1))
+}
+
+func TestConfirmedDigitalWriteTimeout(t *testing.T) {
+	b, _ := initTestFirmata()
+	go b.serve()
+	defer b.Close()
+
+	ctx := context.Background()
+	before, _ := b.ReadPin(ctx, 13)
+	err := b.ConfirmedDigitalWrite(ctx, 13, 1,
+		ConfirmOptions{Retries: 1, Timeout: 10 * time.Millisecond})
+	gobottest.Refute(t, err, nil)
+
+	// rolled back
+	p, _ := b.ReadPin(ctx, 13)
+	gobottest.Assert(t, p, before)
+}
+
+func TestConfirmedDigitalWriteLateResponse(t *testing.T) {
+	b, _ := initTestFirmata()
+	// queried by the handshake
+	waitPinStateQuery(b, 13)
+	go b.serve()
+	defer b.Close()
+
+	go func() {
+		waitPinStateQuery(b, 13)
+		// the first attempt timed out, then the retry queried again
+		waitPinStateQuery(b, 13)
+		// late response of the first attempt
+		respondPinState(b, []byte{START_SYSEX, PIN_STATE_RESPONSE, 13, PIN_MODE_OUTPUT, 0, END_SYSEX})
+		respondPinState(b, []byte{START_SYSEX, PIN_STATE_RESPONSE, 13, PIN_MODE_OUTPUT, 1, END_SYSEX})
+	}()
+
+	err := b.ConfirmedDigitalWrite(context.Background(), 13, 1,
+		ConfirmOptions{Retries: 1, Timeout: 50 * time.Millisecond})
+	gobottest.Assert(t, err, nil)
+}
+
+func TestNewConfirmOptions(t *testing.T) {
+	gobottest.Assert(t, NewConfirmOptions(nil), (*ConfirmOptions)(nil))
+
+	opts := NewConfirmOptions(&pb.Group_Confirm{})
+	gobottest.Assert(t, opts.Retries, DefaultConfirmRetries)
+	gobottest.Assert(t, opts.Timeout, DefaultConfirmTimeout)
+
+	retries := uint32(0)
+	opts = NewConfirmOptions(&pb.Group_Confirm{Retries: &retries, TimeoutMs: 10})
+	gobottest.Assert(t, opts.Retries, 0)
+	gobottest.Assert(t, opts.Timeout, 10*time.Millisecond)
+}
+
+func TestCheckPinState(t *testing.T) {
+	b, _ := initTestFirmata()
+	go b.serve()
+	defer b.Close()
+
+	ctx := context.Background()
+	// the handshake leaves Value_l 0 with State_l 1
+	gobottest.Assert(t, b.DigitalWrite(ctx, 13, 1), nil)
+	gobottest.Assert(t, b.DigitalWrite(ctx, 13, 0), nil)
+
+	// board restarted with D13 high
+	go answerPinStateQueries(b, 13, [][]byte{
+		{START_SYSEX, PIN_STATE_RESPONSE, 13, PIN_MODE_OUTPUT, 1, END_SYSEX},
+	})
+	drift, err := b.CheckPinState(ctx, 13, time.Second)
+	gobottest.Assert(t, err, nil)
+	gobottest.Refute(t, drift, (*PinDrift)(nil))
+	gobottest.Assert(t, drift.Expected.State, uint32(0))
+	gobottest.Assert(t, drift.Actual.State, uint32(1))
+
+	gobottest.Assert(t, b.ApplyPinState(ctx, drift.Expected), nil)
+	go answerPinStateQueries(b, 13, [][]byte{
+		{START_SYSEX, PIN_STATE_RESPONSE, 13, PIN_MODE_OUTPUT, 0, END_SYSEX},
+	})
+	drift, err = b.CheckPinState(ctx, 13, time.Second)
+	gobottest.Assert(t, err, nil)
+	gobottest.Assert(t, drift, (*PinDrift)(nil))
+
+	// a matched response keeps the written value
+	gobottest.Assert(t, b.DigitalWrite(ctx, 13, 1), nil)
+	go answerPinStateQueries(b, 13, [][]byte{
+		{START_SYSEX, PIN_STATE_RESPONSE, 13, PIN_MODE_OUTPUT, 1, END_SYSEX},
+	})
+	drift, err = b.CheckPinState(ctx, 13, time.Second)
+	gobottest.Assert(t, err, nil)
+	gobottest.Assert(t, drift, (*PinDrift)(nil))
+	pin, err := b.ReadPin(ctx, 13)
+	gobottest.Assert(t, err, nil)
+	gobottest.Assert(t, pin.Value, uint32(1))
+}
+
+// runLoop runs the next fn posted to the loop which is not serving.
+func runLoop(t *testing.T, f *Firmata) {
+	t.Helper()
+	select {
+	case fn := <-f.loopCh:
+		fn()
+	case <-time.After(time.Second):
+		t.Fatal("nothing posted to the loop")
+	}
+}
+
+// waitHandshakeCache waits the entry of key stored out of the loop.
+func waitHandshakeCache(t *testing.T, c HandshakeCache, key string) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		e, err := c.Load(key)
+		gobottest.Assert(t, err, nil)
+		if e != nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s not stored", key)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// fullHandshake processes the full handshake, the cache misses.
+func fullHandshake(t *testing.T, f *Firmata) {
+	t.Helper()
+	for _, s := range [][]byte{testProtocolResponse(), testFirmwareResponse()} {
+		setTestReadData(f, s)
+		gobottest.Assert(t, processFrame(f), nil)
+	}
+	if f.Config.HandshakeCache != nil {
+		runLoop(t, f)
+	}
+	for _, s := range [][]byte{
+		testCapabilitiesResponse(),
+		testAnalogMappingResponse(),
+		testPinNamesReply(),
+	} {
+		setTestReadData(f, s)
+		gobottest.Assert(t, processFrame(f), nil)
+	}
+}
+
+func TestHandshakeCache(t *testing.T) {
+	cache := FileHandshakeCache{Dir: t.TempDir()}
+
+	f := NewFirmata(new(readWriteCloser), &Config{HandshakeCache: cache, CacheId: "uno1"})
+	fullHandshake(t, f)
+	key := f.HandshakeCacheKey()
+	gobottest.Assert(t, key, "uno1/StandardFirmata.ino@2.3")
+	waitHandshakeCache(t, cache, key)
+
+	var connected bool
+	f = NewFirmata(new(readWriteCloser), &Config{
+		HandshakeCache: cache,
+		CacheId:        "uno1",
+		OnConnected:    func(f *Firmata) { connected = true },
+	})
+	for _, s := range [][]byte{testProtocolResponse(), testFirmwareResponse()} {
+		setTestReadData(f, s)
+		gobottest.Assert(t, processFrame(f), nil)
+	}
+	runLoop(t, f)
+	gobottest.Refute(t, f.cacheTimer_l, (*time.Timer)(nil))
+	for _, s := range testPinStateReply() {
+		setTestReadData(f, s)
+		gobottest.Assert(t, processFrame(f), nil)
+	}
+	gobottest.Assert(t, connected, true)
+	gobottest.Assert(t, f.cacheTimer_l, (*time.Timer)(nil))
+	gobottest.Assert(t, f.TotalPins, byte(20))
+	gobottest.Assert(t, f.TotalAnalogPins, byte(6))
+	gobottest.Assert(t, f.DxByName[pb.PinName_PB15], byte(13))
+	gobottest.Assert(t, f.PortConfigInputs_l, [16]byte{0b00000100})
+
+	// the fresh capability differs
+	var invalidated string
+	f.Config.OnHandshakeCacheInvalidated = func(f *Firmata, key string) { invalidated = key }
+	setTestReadData(f, []byte{START_SYSEX, CAPABILITY_RESPONSE, 0, 1, 127, END_SYSEX})
+	gobottest.Refute(t, processFrame(f), nil)
+	gobottest.Assert(t, invalidated, key)
+	deadline := time.Now().Add(time.Second)
+	for {
+		e, err := cache.Load(key)
+		gobottest.Assert(t, err, nil)
+		if e == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("invalidated entry not deleted")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// another board of the same type misses
+	f = NewFirmata(new(readWriteCloser), &Config{HandshakeCache: cache, CacheId: "uno2"})
+	fullHandshake(t, f)
+	gobottest.Assert(t, f.TotalPins, byte(20))
+}
+
+func TestHandshakeCacheTimeout(t *testing.T) {
+	defer func(d time.Duration) { cachedHandshakeTimeout = d }(cachedHandshakeTimeout)
+	cachedHandshakeTimeout = 10 * time.Millisecond
+
+	cache := FileHandshakeCache{Dir: t.TempDir()}
+	f := NewFirmata(new(readWriteCloser), &Config{HandshakeCache: cache, CacheId: "uno1"})
+	fullHandshake(t, f)
+	key := f.HandshakeCacheKey()
+	waitHandshakeCache(t, cache, key)
+
+	var connected bool
+	var cacheErr error
+	f = NewFirmata(new(readWriteCloser), &Config{
+		HandshakeCache:        cache,
+		CacheId:               "uno1",
+		OnConnected:           func(f *Firmata) { connected = true },
+		OnHandshakeCacheError: func(f *Firmata, key string, err error) { cacheErr = err },
+	})
+	for _, s := range [][]byte{testProtocolResponse(), testFirmwareResponse()} {
+		setTestReadData(f, s)
+		gobottest.Assert(t, processFrame(f), nil)
+	}
+	runLoop(t, f)
+	gobottest.Assert(t, f.TotalPins, byte(20))
+	// only one PIN_STATE_RESPONSE
+	setTestReadData(f, testPinStateReply()[0])
+	gobottest.Assert(t, processFrame(f), nil)
+
+	// fired by the timer
+	runLoop(t, f)
+	gobottest.Refute(t, cacheErr, nil)
+	gobottest.Assert(t, f.Pins, ([]*Pin)(nil))
+	gobottest.Assert(t, f.pendingCachedStates_l, 0)
+	gobottest.Assert(t, connected, false)
+
+	for _, s := range [][]byte{
+		testCapabilitiesResponse(),
+		testAnalogMappingResponse(),
+		testPinNamesReply(),
+	} {
+		setTestReadData(f, s)
+		gobottest.Assert(t, processFrame(f), nil)
+	}
+	gobottest.Assert(t, connected, true)
+	gobottest.Assert(t, f.TotalPins, byte(20))
+}
+
+// failingCache fails every call.
+type failingCache struct{}
+
+func (failingCache) Load(key string) (*HandshakeCacheEntry, error) {
+	return nil, errors.New("load failed")
+}
+func (failingCache) Store(key string, e *HandshakeCacheEntry) error {
+	return errors.New("store failed")
+}
+func (failingCache) Delete(key string) error { return errors.New("delete failed") }
+
+func TestHandshakeCacheError(t *testing.T) {
+	errs := make(chan error, 2)
+	f := NewFirmata(new(readWriteCloser), &Config{
+		HandshakeCache:        failingCache{},
+		CacheId:               "uno1",
+		OnHandshakeCacheError: func(f *Firmata, key string, err error) { errs <- err },
+	})
+	// the failed load goes on with the full handshake
+	fullHandshake(t, f)
+	gobottest.Assert(t, f.TotalPins, byte(20))
+	for _, want := range []string{"load failed", "store failed"} {
+		select {
+		case err := <-errs:
+			gobottest.Assert(t, err.Error(), want)
+		case <-time.After(time.Second):
+			t.Fatalf("%s not reported", want)
+		}
+	}
+}
+
+func TestIdentity(t *testing.T) {
+	f := NewFirmata(new(readWriteCloser), &Config{
+		Identity: &pb.Firmata_Identity{FirmwareName: "ConfigurableFirmata"},
+	})
+	setTestReadData(f, testProtocolResponse())
+	gobottest.Assert(t, processFrame(f), nil)
+	setTestReadData(f, testFirmwareResponse())
+	_, ok := processFrame(f).(*IdentityError)
+	gobottest.Assert(t, ok, true)
+
+	b, _ := initTestFirmata()
+	fp := CapabilityFingerprint(b.Pins)
+
+	var connected bool
+	f = NewFirmata(new(readWriteCloser), &Config{
+		OnConnected: func(f *Firmata) { connected = true },
+		Identity: &pb.Firmata_Identity{
+			FirmwareName:          "StandardFirmata.ino",
+			Compatible:            pb.Version_no,
+			TotalPins:             20,
+			CapabilityFingerprint: fp,
+			Serial:                "0001",
+		},
+	})
+	for _, s := range [][]byte{
+		testProtocolResponse(),
+		testFirmwareResponse(),
+		testCapabilitiesResponse(),
+		testAnalogMappingResponse(),
+		testPinNamesReply(),
+	} {
+		setTestReadData(f, s)
+		gobottest.Assert(t, processFrame(f), nil)
+	}
+	gobottest.Assert(t, connected, false)
+
+	setTestReadData(f, append([]byte{START_SYSEX, UD_BOARD_SERIAL_REPLY},
+		append(To14bits([]byte("0002")), END_SYSEX)...))
+	gobottest.Refute(t, processFrame(f), nil)
+
+	f.awaitingSerial_l = true
+	setTestReadData(f, append([]byte{START_SYSEX, UD_BOARD_SERIAL_REPLY},
+		append(To14bits([]byte("0001")), END_SYSEX)...))
+	gobottest.Assert(t, processFrame(f), nil)
+	gobottest.Assert(t, connected, true)
+	gobottest.Assert(t, f.BoardSerial, "0001")
+}
+
+func TestEncode7bit(t *testing.T) {
+	gobottest.Assert(t, Encode7bit([]byte{0xFF}), []byte{0x7F, 0x01})
+	gobottest.Assert(t, Encode7bit([]byte{0x00, 0xFF}), []byte{0x00, 0x7E, 0x03})
+
+	// decoded like Encoder7Bit.readBinary of firmata
+	in := []byte{SET_DIGITAL_PIN_VALUE, 13, 1, 0x80, 0xAA, 0x55, 0x7F, 0xFE, 0x01}
+	encoded := Encode7bit(in)
+	for _, b := range encoded {
+		gobottest.Assert(t, b&0x80, byte(0))
+	}
+	encoded = append(encoded, 0)
+	out := make([]byte, len(in))
+	for i := range out {
+		j := i << 3
+		pos, shift := j/7, uint(j%7)
+		out[i] = encoded[pos]>>shift | encoded[pos+1]<<(7-shift)
+	}
+	gobottest.Assert(t, out, in)
+}
@@ -131,6 +131,9 @@ func (fr *WriteFramer) AnalogMappingQuery() error {
 func (fr *WriteFramer) PinNamesRequest() error {
 	return fr.write([]byte{START_SYSEX, UD_PIN_NAMES_REQUEST, END_SYSEX})
 }
+func (fr *WriteFramer) BoardSerialRequest() error {
+	return fr.write([]byte{START_SYSEX, UD_BOARD_SERIAL_REQUEST, END_SYSEX})
+}
 
 func (fr *WriteFramer) ReportDigital(port byte, value byte) error {
 	return fr.write([]byte{REPORT_DIGITAL | byte(port), byte(value)})
@@ -231,6 +234,45 @@ func (fr *WriteFramer) SamplingInterval(ms uint32) error {
 	})
 }
 
+func (fr *WriteFramer) SchedulerCreateTask(id byte, length int) error {
+	return fr.Sysex([]byte{SCHEDULER_DATA, SCHEDULER_CREATE_TASK, id,
+		byte(length & 0x7F), byte((length >> 7) & 0x7F)})
+}
+
+func (fr *WriteFramer) SchedulerDeleteTask(id byte) error {
+	return fr.Sysex([]byte{SCHEDULER_DATA, SCHEDULER_DELETE_TASK, id})
+}
+
+// SchedulerAddToTask appends raw firmata messages to the task.
+func (fr *WriteFramer) SchedulerAddToTask(id byte, messages []byte) error {
+	b := append([]byte{SCHEDULER_DATA, SCHEDULER_ADD_TO_TASK, id}, Encode7bit(messages)...)
+	return fr.Sysex(b)
+}
+
+// SchedulerScheduleTask runs the task after ms, it reschedules the task if
+// called again before.
+func (fr *WriteFramer) SchedulerScheduleTask(id byte, ms uint32) error {
+	t := []byte{byte(ms), byte(ms >> 8), byte(ms >> 16), byte(ms >> 24)}
+	b := append([]byte{SCHEDULER_DATA, SCHEDULER_SCHEDULE_TASK, id}, Encode7bit(t)...)
+	return fr.Sysex(b)
+}
+
+// SchedulerDelayTask delays the running task by ms, a task ending with it
+// runs every ms and is never freed.
+func (fr *WriteFramer) SchedulerDelayTask(ms uint32) error {
+	t := []byte{byte(ms), byte(ms >> 8), byte(ms >> 16), byte(ms >> 24)}
+	b := append([]byte{SCHEDULER_DATA, SCHEDULER_DELAY_TASK}, Encode7bit(t)...)
+	return fr.Sysex(b)
+}
+
+// Sysex writes a raw sysex message, data[0] is the sysex command.
+func (fr *WriteFramer) Sysex(data []byte) error {
+	b := make([]byte, 0, len(data)+2)
+	b = append(b, START_SYSEX)
+	b = append(b, data...)
+	return fr.write(append(b, END_SYSEX))
+}
+
 func (fr *WriteFramer) write(b []byte) (err error) {
 	_, err = fr.w.Write(b)
 	return
@@ -352,7 +394,9 @@ func (fr *ReadFramer) ReadFrame() (f *ReadFrame, err error) {
 			var dx byte
 			n := 0
 
-			for i, val := range fr.buf[2 : fr.cur-1] {
+			// i indexes data, not fr.buf
+			data := fr.buf[2 : fr.cur-1]
+			for i, val := range data {
 				if val == 0x7F {
 					pins[dx] = &Pin{
 						Dx:     dx,
@@ -364,8 +408,7 @@ func (fr *ReadFramer) ReadFrame() (f *ReadFrame, err error) {
 					modes = make(map[byte]byte)
 				} else {
 					if n == 0 {
-						i++
-						modes[val] = fr.buf[i]
+						modes[val] = data[i+1]
 					}
 					n ^= 1
 				}
@@ -396,6 +439,17 @@ func (fr *ReadFramer) ReadFrame() (f *ReadFrame, err error) {
 				Type: UD_PIN_NAMES_REPLY,
 				Data: From14bits(fr.buf[2 : fr.cur-1]),
 			}
+		case UD_BOARD_SERIAL_REPLY:
+			// 0  START_SYSEX                  (0xF0)
+			// 1  UD_BOARD_SERIAL_REPLY        (0x09)
+			// 2  serial char0 bits 0-6        (least significant byte)
+			// 3  serial char0 bits 7-13       (most significant byte)
+			// ... char1 and more
+			// N  END_SYSEX                    (0xF7)
+			f = &ReadFrame{
+				Type: UD_BOARD_SERIAL_REPLY,
+				Data: From14bits(fr.buf[2 : fr.cur-1]),
+			}
 
 		case PIN_STATE_RESPONSE:
 			state := uint32(fr.buf[4])
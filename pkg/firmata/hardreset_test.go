@@ -0,0 +1,103 @@
+package firmata_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/empirefox/firmata/pkg/firmata"
+	"github.com/empirefox/firmata/pkg/firmata/device"
+	"github.com/empirefox/firmata/pkg/pb"
+	"gobot.io/x/gobot/gobottest"
+)
+
+func connectDevice(t *testing.T, host io.ReadWriteCloser, board net.Conn, config *firmata.Config) *firmata.Firmata {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	d := device.New(board, &device.Config{
+		Pins: []device.PinConfig{
+			device.DigitalPin(pb.PinName_PA0),
+			device.DigitalPin(pb.PinName_PA1),
+			device.AnalogPin(pb.PinName_PA2, 0),
+			device.AnalogPin(pb.PinName_PA3, 1),
+		},
+		SamplingInterval: time.Millisecond,
+	})
+	go d.Serve(ctx)
+
+	hctx, hcancel := context.WithTimeout(ctx, time.Second)
+	defer hcancel()
+	f, err := firmata.Connect(hctx, host, config)
+	gobottest.Assert(t, err, nil)
+	t.Cleanup(f.Close)
+	return f
+}
+
+// resetConn reboots the virtual device like a DTR pulse.
+type resetConn struct {
+	net.Conn
+	resets int
+}
+
+func (c *resetConn) ResetLine(ctx context.Context) error {
+	c.resets++
+	_, err := c.Write([]byte{firmata.SYSTEM_RESET})
+	return err
+}
+
+// wrapConn is a wrapper like dial.CaptureConn.
+type wrapConn struct {
+	io.ReadWriteCloser
+}
+
+func (c *wrapConn) Unwrap() io.ReadWriteCloser { return c.ReadWriteCloser }
+
+func TestHardResetUnsupported(t *testing.T) {
+	ctx := context.Background()
+	for _, wrap := range []bool{false, true} {
+		host, board := net.Pipe()
+		var c io.ReadWriteCloser = host
+		if wrap {
+			c = &wrapConn{c}
+		}
+		f := connectDevice(t, c, board, &firmata.Config{})
+
+		gobottest.Assert(t, f.HardReset(ctx), firmata.ErrNoLineResetter)
+		// still connected
+		pin, err := f.ReadPin(ctx, 2)
+		gobottest.Assert(t, err, nil)
+		gobottest.Assert(t, pin.Mode, firmata.PIN_MODE_ANALOG)
+	}
+}
+
+func TestHardReset(t *testing.T) {
+	ctx := context.Background()
+	connected := make(chan struct{}, 2)
+	host, board := net.Pipe()
+	rc := &resetConn{Conn: host}
+	f := connectDevice(t, &wrapConn{rc}, board, &firmata.Config{
+		OnConnected: func(f *firmata.Firmata) { connected <- struct{}{} },
+	})
+	<-connected
+
+	initial, err := f.ReadPin(ctx, 0)
+	gobottest.Assert(t, err, nil)
+	mode := firmata.PIN_MODE_INPUT
+	if initial.Mode == mode {
+		mode = firmata.PIN_MODE_OUTPUT
+	}
+	gobottest.Assert(t, f.SetMode(ctx, 0, mode), nil)
+
+	rctx, rcancel := context.WithTimeout(ctx, time.Second)
+	defer rcancel()
+	gobottest.Assert(t, f.HardReset(rctx), nil)
+	gobottest.Assert(t, rc.resets, 1)
+	<-connected
+
+	pin, err := f.ReadPin(ctx, 0)
+	gobottest.Assert(t, err, nil)
+	gobottest.Assert(t, pin.Mode, initial.Mode)
+	gobottest.Assert(t, f.TotalPins, byte(4))
+}
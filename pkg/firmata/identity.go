@@ -0,0 +1,108 @@
+package firmata
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+// IdentityError means the board is not the one declared by Config.Identity.
+type IdentityError struct {
+	Reason string
+}
+
+func (e *IdentityError) Error() string {
+	return "board identity mismatch: " + e.Reason
+}
+
+func identityErrorf(format string, a ...interface{}) error {
+	return &IdentityError{Reason: fmt.Sprintf(format, a...)}
+}
+
+// CapabilityFingerprint hashes the supported modes and resolutions of pins.
+func CapabilityFingerprint(pins []*Pin) string {
+	h := sha256.New()
+	for _, pin := range pins {
+		for mode := byte(0); mode < PIN_MODE_IGNORE; mode++ {
+			if res, ok := pin.Modes[mode]; ok {
+				h.Write([]byte{mode, res})
+			}
+		}
+		h.Write([]byte{0x7F})
+	}
+	return hex.EncodeToString(h.Sum(nil)[:8])
+}
+
+// verifyFirmware_l checks REPORT_VERSION and REPORT_FIRMWARE.
+func (f *Firmata) verifyFirmware_l() error {
+	id := f.Config.Identity
+	if id == nil {
+		return nil
+	}
+
+	fw := f.FirmwareVersion.GetServer()
+	if id.FirmwareName != "" && id.FirmwareName != fw.GetName() {
+		return identityErrorf("firmware name %q, expected %q", fw.GetName(), id.FirmwareName)
+	}
+	if id.FirmwareMajor != nil && int32(*id.FirmwareMajor) != fw.GetMajor() {
+		return identityErrorf("firmware major %d, expected %d", fw.GetMajor(), *id.FirmwareMajor)
+	}
+	if id.FirmwareMinor != nil && int32(*id.FirmwareMinor) != fw.GetMinor() {
+		return identityErrorf("firmware minor %d, expected %d", fw.GetMinor(), *id.FirmwareMinor)
+	}
+	if c := f.ProtocolVersion.GetCompatible(); c < id.Compatible {
+		return identityErrorf("protocol compatible %s, expected %s", c, id.Compatible)
+	}
+	if c := f.FirmwareVersion.GetCompatible(); c < id.Compatible {
+		return identityErrorf("firmware compatible %s, expected %s", c, id.Compatible)
+	}
+	return nil
+}
+
+// verifyPins_l checks the pin table.
+func (f *Firmata) verifyPins_l() error {
+	id := f.Config.Identity
+	if id == nil {
+		return nil
+	}
+
+	if id.TotalPins != 0 && id.TotalPins != uint32(f.TotalPins) {
+		return identityErrorf("total pins %d, expected %d", f.TotalPins, id.TotalPins)
+	}
+	if id.CapabilityFingerprint != "" {
+		fp := CapabilityFingerprint(f.Pins)
+		if fp != id.CapabilityFingerprint {
+			return identityErrorf("capability fingerprint %s, expected %s", fp, id.CapabilityFingerprint)
+		}
+	}
+	return nil
+}
+
+// finishHandshake_l requests the board serial if required, or calls
+// OnConnected.
+func (f *Firmata) finishHandshake_l() error {
+	if f.Config.Identity.GetSerial() != "" && f.BoardSerial == "" {
+		f.awaitingSerial_l = true
+		return f.writer.BoardSerialRequest()
+	}
+	f.handshaking_l = false
+	f.connectedOnce.Do(f.onConnected)
+	for _, done := range f.hardResets_l {
+		close(done)
+	}
+	f.hardResets_l = nil
+	return nil
+}
+
+func (f *Firmata) onBoardSerial_l(serial string) error {
+	if !f.awaitingSerial_l {
+		return nil
+	}
+	f.awaitingSerial_l = false
+	expected := f.Config.Identity.GetSerial()
+	if serial != expected {
+		return identityErrorf("board serial %q, expected %q", serial, expected)
+	}
+	f.BoardSerial = serial
+	return f.finishHandshake_l()
+}
@@ -52,6 +52,18 @@ func (f *Firmata) Loop(fn func()) error {
 	}
 }
 
+// loopClose runs fn in the serve loop, the Firmata is closed if fn fails
+// like processing a frame.
+func (f *Firmata) loopClose(fn func() error) {
+	f.Loop(func() {
+		err := fn()
+		if err != nil {
+			f.ClosedError_l = err
+			f.Close()
+		}
+	})
+}
+
 type readFrameResult struct {
 	frame *ReadFrame
 	err   error
@@ -72,6 +84,9 @@ func (f *Firmata) readFrames() {
 }
 
 func (f *Firmata) proccessFrame(frame *ReadFrame) (err error) {
+	if f.loadingCache_l {
+		return nil
+	}
 	switch frame.Type {
 	case REPORT_VERSION:
 		if f.ProtocolVersion == nil {
@@ -84,9 +99,19 @@ func (f *Firmata) proccessFrame(frame *ReadFrame) (err error) {
 		}
 		if f.FirmwareVersion == nil {
 			f.FirmwareVersion = frame.Data.(*Version)
+			err := f.verifyFirmware_l()
+			if err != nil {
+				return err
+			}
+			if f.loadHandshakeCache_l() {
+				return nil
+			}
 			return f.reportInit_l()
 		}
 	case CAPABILITY_RESPONSE:
+		if f.verifyingCache_l {
+			return f.verifyHandshakeCache_l(frame.Data.(*CapabilityFrameData))
+		}
 		if f.FirmwareVersion == nil {
 			return f.reportInit_l()
 		}
@@ -129,7 +154,7 @@ func (f *Firmata) proccessFrame(frame *ReadFrame) (err error) {
 			f.TotalAnalogPins = aps
 
 			for i := byte(0); i < f.TotalPins; i++ {
-				err := f.writer.PinStateQuery(i)
+				_, err := f.queryPinState_l(i, nil)
 				if err != nil {
 					return err
 				}
@@ -157,9 +182,15 @@ func (f *Firmata) proccessFrame(frame *ReadFrame) (err error) {
 				f.Pins[i].Name = n
 			}
 
-			f.handshaking_l = false
-			f.connectedOnce.Do(f.onConnected)
+			err := f.verifyPins_l()
+			if err != nil {
+				return err
+			}
+			f.storeHandshakeCache_l()
+			return f.finishHandshake_l()
 		}
+	case UD_BOARD_SERIAL_REPLY:
+		return f.onBoardSerial_l(string(frame.Data.([]byte)))
 	default:
 		if f.AnalogPins == nil || (f.DxByName == nil && frame.Type != PIN_STATE_RESPONSE) {
 			return f.reportInit_l()
@@ -214,9 +245,11 @@ func (f *Firmata) proccessFrame(frame *ReadFrame) (err error) {
 					data.Pin, data.State)
 			}
 			pin.State_l = data.State
+			f.notifyPinState_l(data)
 			if !f.handshaking_l && f.Config.OnPinState != nil {
 				f.Config.OnPinState(f, pin)
 			}
+			return f.onCachedPinState_l()
 		case I2C_REPLY:
 			if f.Config.OnI2cReply != nil {
 				f.Config.OnI2cReply(f, frame.Data.(*I2cReply))
@@ -1,6 +1,10 @@
 package firmata
 
-import "github.com/empirefox/firmata/pkg/pb"
+import (
+	"time"
+
+	"github.com/empirefox/firmata/pkg/pb"
+)
 
 type PinName = pb.PinName
 
@@ -17,6 +21,10 @@ type Pin struct {
 	Mode_l  byte
 	Value_l uint32
 	State_l uint32
+	// Filtered_l is Value_l after Filter.
+	Filtered_l uint32
+	// Filter conditions analog input values, nil means pass through.
+	Filter *AnalogFilter
 }
 
 func (pin *Pin) ToPb_l() *pb.Instance_Pin {
@@ -30,14 +38,26 @@ func (pin *Pin) ToPb_l() *pb.Instance_Pin {
 		}
 	}
 	return &pb.Instance_Pin{
-		Dx:    uint32(pin.Dx),
-		Ax:    uint32(pin.Ax),
-		Name:  pin.Name,
-		Modes: modes,
-		Mode:  pb.Mode(pin.Mode_l),
-		Value: pin.Value_l,
-		State: pin.State_l,
+		Dx:       uint32(pin.Dx),
+		Ax:       uint32(pin.Ax),
+		Name:     pin.Name,
+		Modes:    modes,
+		Mode:     pb.Mode(pin.Mode_l),
+		Value:    pin.Value_l,
+		State:    pin.State_l,
+		Filtered: pin.Filtered_l,
+	}
+}
+
+// ApplyFilter_l updates Filtered_l from Value_l, and reports whether the
+// filtered value should be emitted.
+func (pin *Pin) ApplyFilter_l(now time.Time) (emit bool) {
+	if pin.Filter == nil {
+		pin.Filtered_l = pin.Value_l
+		return true
 	}
+	pin.Filtered_l, emit = pin.Filter.Apply_l(pin.Value_l, now)
+	return emit
 }
 
 func (pin *Pin) SupportMode(mode byte) (ok bool) {
@@ -59,6 +79,7 @@ func (pin *Pin) IsAnalog() bool {
 
 func (pin *Pin) Clone_l() *Pin {
 	clone := *pin
+	clone.Filter = nil
 	clone.Modes = make(map[byte]byte, len(pin.Modes))
 	for k, v := range pin.Modes {
 		clone.Modes[k] = v
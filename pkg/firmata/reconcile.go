@@ -0,0 +1,101 @@
+package firmata
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// PinDrift is a PIN_STATE_RESPONSE which differs from the cached pin.
+type PinDrift struct {
+	Expected PinSnapshot
+	Actual   PinStateFrameData
+}
+
+// CheckPinState sends a PIN_STATE_QUERY, then compares the response with the
+// cached pin at the time of querying. It returns nil drift if they match,
+// and keeps the cached value which the response resets. The cached pin is
+// replaced by a drifted response like any PIN_STATE_RESPONSE.
+func (f *Firmata) CheckPinState(ctx context.Context, pin byte, timeout time.Duration) (*PinDrift, error) {
+	if timeout == 0 {
+		timeout = DefaultConfirmTimeout
+	}
+
+	ch := make(chan *PinDrift, 1)
+	var q *pinStateQuery
+	err := f.WaitLoopContext(ctx, func() error {
+		if pin >= f.TotalPins {
+			return fmt.Errorf("CheckPinState pin out of index: %d", pin)
+		}
+		expected := f.Pins[pin].Snapshot_l()
+		var err error
+		p := f.Pins[pin]
+		q, err = f.queryPinState_l(pin, func(st *PinStateFrameData) {
+			if pinStateDrifted(expected, st) {
+				ch <- &PinDrift{Expected: expected, Actual: *st}
+				return
+			}
+			// handlePinMode_l reset it, but the board did not change
+			if p.Mode_l == expected.Mode {
+				p.Value_l = expected.Value
+			}
+			ch <- nil
+		})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case drift := <-ch:
+		return drift, nil
+	case <-timer.C:
+		f.abandonPinStateQuery(q)
+		return nil, fmt.Errorf("pin %d state response timeout", pin)
+	case <-ctx.Done():
+		f.abandonPinStateQuery(q)
+		return nil, ctx.Err()
+	case <-f.doneServing:
+		return nil, ErrClosed
+	}
+}
+
+func pinStateDrifted(expected PinSnapshot, st *PinStateFrameData) bool {
+	if expected.Mode != st.Mode {
+		return true
+	}
+	switch st.Mode {
+	case PIN_MODE_OUTPUT, PIN_MODE_PWM, PIN_MODE_SERVO:
+		return expected.State != st.State
+	}
+	return false
+}
+
+// ApplyPinState writes the mode and the output state of p to the board, even
+// if the cached pin is the same.
+func (f *Firmata) ApplyPinState(ctx context.Context, p PinSnapshot) error {
+	return f.WaitLoopContext(ctx, func() error {
+		if p.Dx >= f.TotalPins {
+			return fmt.Errorf("ApplyPinState pin out of index: %d", p.Dx)
+		}
+		err := f.writer.SetPinMode(p.Dx, p.Mode)
+		if err != nil {
+			return err
+		}
+		f.handlePinMode_l(p.Dx, p.Mode)
+
+		switch p.Mode {
+		case PIN_MODE_OUTPUT:
+			err = f.writer.SetDigitalPinValue(p.Dx, byte(p.State))
+			if err == nil {
+				f.setDigitalPinValue_l(f.Pins[p.Dx], byte(p.State))
+			}
+		case PIN_MODE_PWM, PIN_MODE_SERVO:
+			err = f.AnalogWrite_l(p.Dx, p.State)
+		}
+		return err
+	})
+}
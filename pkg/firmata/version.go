@@ -67,4 +67,7 @@ func compatible(v *Version) Compatible {
 type VersionInfo struct {
 	ProtocolVersion *Version
 	FirmwareVersion *Version
+	// BoardSerial is replied by UD_BOARD_SERIAL_REQUEST, only requested if
+	// Config.Identity requires it.
+	BoardSerial string
 }
@@ -0,0 +1,271 @@
+package grpci
+
+import (
+	"math"
+	"time"
+
+	"github.com/empirefox/firmata/pkg/firmata"
+	"github.com/empirefox/firmata/pkg/pb"
+)
+
+// alarm evaluates a DigitalReader with alarm or a NumberReader with
+// thresholds, guarded by alarmMu.
+//
+// level is 0 if normal, DigitalReader is 1 if triggered, NumberReader is
+// 2/1/-1/-2 for veryHigh/littleHigh/littleLow/veryLow.
+type alarm struct {
+	group uint32
+	gpin  uint32
+	gp    *pb.Group_Pin
+
+	level int
+	value uint32
+	since time.Time
+
+	// level waiting for the hold-off
+	pending      int
+	pendingValue uint32
+	timer        *time.Timer
+}
+
+// newAlarms collects the group pins which can raise alarm.
+func newAlarms(config *pb.Config) []*alarm {
+	var alarms []*alarm
+	for gi, g := range config.GetGroups() {
+		for pi, p := range g.Pins {
+			switch {
+			case p.GetDigitalReader().GetAlarm():
+			case p.GetNumberReader() != nil:
+				nr := p.GetNumberReader()
+				if nr.VeryHighThreshold == 0 && nr.LittleHighThreshold == 0 &&
+					nr.LittleLowThreshold == 0 && nr.VeryLowThreshold == 0 {
+					continue
+				}
+			default:
+				continue
+			}
+			alarms = append(alarms, &alarm{
+				group: uint32(gi),
+				gpin:  uint32(pi),
+				gp:    p,
+			})
+		}
+	}
+	return alarms
+}
+
+// numberLevel returns the level of v without hysteresis.
+func numberLevel(nr *pb.Group_NumberReader, v uint32) int {
+	switch {
+	case nr.VeryHighThreshold != 0 && v >= nr.VeryHighThreshold:
+		return 2
+	case nr.LittleHighThreshold != 0 && v >= nr.LittleHighThreshold:
+		return 1
+	case nr.VeryLowThreshold != 0 && v <= nr.VeryLowThreshold:
+		return -2
+	case nr.LittleLowThreshold != 0 && v <= nr.LittleLowThreshold:
+		return -1
+	}
+	return 0
+}
+
+// numberLevelFrom returns the level of v, the current level is kept until v
+// leaves its threshold further than hysteresis.
+func numberLevelFrom(nr *pb.Group_NumberReader, current int, v uint32) int {
+	level := numberLevel(nr, v)
+	h := nr.Hysteresis
+	switch {
+	case current > 0 && level < current:
+		upper := uint32(math.MaxUint32)
+		if v < upper-h {
+			upper = v + h
+		}
+		if numberLevel(nr, upper) >= current {
+			return current
+		}
+	case current < 0 && level > current:
+		lower := uint32(0)
+		if v > h {
+			lower = v - h
+		}
+		if numberLevel(nr, lower) <= current {
+			return current
+		}
+	}
+	return level
+}
+
+func (a *alarm) holdOff() time.Duration {
+	if dr := a.gp.GetDigitalReader(); dr != nil {
+		return time.Duration(dr.HoldOffMs) * time.Millisecond
+	}
+	return time.Duration(a.gp.GetNumberReader().HoldOffMs) * time.Millisecond
+}
+
+func (a *alarm) band() pb.ServerMessage_Alarm_Band {
+	if a.gp.GetDigitalReader() != nil {
+		return pb.ServerMessage_Alarm_triggered
+	}
+	switch a.level {
+	case 2:
+		return pb.ServerMessage_Alarm_veryHigh
+	case 1:
+		return pb.ServerMessage_Alarm_littleHigh
+	case -1:
+		return pb.ServerMessage_Alarm_littleLow
+	default:
+		return pb.ServerMessage_Alarm_veryLow
+	}
+}
+
+func (a *alarm) toPb_l(status pb.ServerMessage_Alarm_Status) *pb.ServerMessage_Alarm {
+	return &pb.ServerMessage_Alarm{
+		Group:   a.group,
+		Gpin:    a.gpin,
+		Status:  status,
+		Band:    a.band(),
+		Value:   a.value,
+		SinceMs: a.since.UnixNano() / int64(time.Millisecond),
+	}
+}
+
+// evaluateAlarm_l commits level after the hold-off, it returns the message
+// to broadcast if the alarm changed now.
+func (s *Server) evaluateAlarm_l(a *alarm, level int, value uint32) *pb.ServerMessage {
+	if level == a.level {
+		if a.timer != nil {
+			a.timer.Stop()
+			a.timer = nil
+		}
+		return nil
+	}
+	if a.timer != nil && level == a.pending {
+		a.pendingValue = value
+		return nil
+	}
+
+	a.pending = level
+	a.pendingValue = value
+	if a.timer != nil {
+		a.timer.Stop()
+		a.timer = nil
+	}
+	d := a.holdOff()
+	if d == 0 {
+		return s.commitAlarm_l(a)
+	}
+
+	var timer *time.Timer
+	timer = time.AfterFunc(d, func() {
+		s.alarmMu.Lock()
+		var out *pb.ServerMessage
+		if a.timer == timer {
+			a.timer = nil
+			out = s.commitAlarm_l(a)
+		}
+		if out != nil {
+			s.broadcastStates(out)
+		}
+		s.alarmMu.Unlock()
+	})
+	a.timer = timer
+	return nil
+}
+
+func (s *Server) commitAlarm_l(a *alarm) *pb.ServerMessage {
+	a.value = a.pendingValue
+	a.since = time.Now()
+	var out *pb.ServerMessage_Alarm
+	if a.pending == 0 {
+		// report the band being cleared
+		out = a.toPb_l(pb.ServerMessage_Alarm_cleared)
+		a.level = 0
+	} else {
+		a.level = a.pending
+		out = a.toPb_l(pb.ServerMessage_Alarm_raised)
+	}
+	return &pb.ServerMessage{
+		Type: &pb.ServerMessage_Alarm_{Alarm: out},
+	}
+}
+
+// updateDigitalAlarms_l evaluates DigitalReader pins in port of firmata idx.
+func (s *Server) updateDigitalAlarms_l(f *firmata.Firmata, idx uint32, port byte) {
+	var outs []*pb.ServerMessage
+	s.alarmMu.Lock()
+	for _, a := range s.alarms {
+		dr := a.gp.GetDigitalReader()
+		if dr == nil || a.gp.FirmataIndex != idx {
+			continue
+		}
+		dx := byte(a.gp.GetDx())
+		if dx/8 != port || dx >= f.TotalPins {
+			continue
+		}
+		value := f.Pins[dx].Value_l
+		level := 0
+		if (value != 0) != dr.LowLevelTrigger {
+			level = 1
+		}
+		if out := s.evaluateAlarm_l(a, level, value); out != nil {
+			outs = append(outs, out)
+		}
+	}
+	s.broadcastStates(outs...)
+	s.alarmMu.Unlock()
+}
+
+// updateNumberAlarms_l evaluates NumberReader pins by the filtered analog
+// value of pin.
+func (s *Server) updateNumberAlarms_l(idx uint32, pin *firmata.Pin) {
+	var outs []*pb.ServerMessage
+	s.alarmMu.Lock()
+	for _, a := range s.alarms {
+		nr := a.gp.GetNumberReader()
+		if nr == nil || a.gp.FirmataIndex != idx || byte(a.gp.GetDx()) != pin.Dx {
+			continue
+		}
+		level := numberLevelFrom(nr, a.level, pin.Filtered_l)
+		if out := s.evaluateAlarm_l(a, level, pin.Filtered_l); out != nil {
+			outs = append(outs, out)
+		}
+	}
+	s.broadcastStates(outs...)
+	s.alarmMu.Unlock()
+}
+
+// clearAlarms stops the hold-off of the disconnected firmata, and clears
+// its raised alarms.
+func (s *Server) clearAlarms(idx uint32) {
+	var outs []*pb.ServerMessage
+	s.alarmMu.Lock()
+	for _, a := range s.alarms {
+		if a.gp.FirmataIndex != idx {
+			continue
+		}
+		if a.timer != nil {
+			a.timer.Stop()
+			a.timer = nil
+		}
+		if a.level != 0 {
+			a.pending = 0
+			a.pendingValue = a.value
+			outs = append(outs, s.commitAlarm_l(a))
+		}
+	}
+	s.broadcastStates(outs...)
+	s.alarmMu.Unlock()
+}
+
+// activeAlarms returns the raised alarms ordered by group and gpin.
+func (s *Server) activeAlarms() []*pb.ServerMessage_Alarm {
+	s.alarmMu.Lock()
+	defer s.alarmMu.Unlock()
+	var alarms []*pb.ServerMessage_Alarm
+	for _, a := range s.alarms {
+		if a.level != 0 {
+			alarms = append(alarms, a.toPb_l(pb.ServerMessage_Alarm_raised))
+		}
+	}
+	return alarms
+}
@@ -0,0 +1,83 @@
+package grpci
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/empirefox/firmata/pkg/pb"
+	"gobot.io/x/gobot/gobottest"
+)
+
+func TestNumberLevelFrom(t *testing.T) {
+	nr := &pb.Group_NumberReader{
+		VeryLowThreshold:    100,
+		LittleLowThreshold:  200,
+		LittleHighThreshold: 800,
+		VeryHighThreshold:   900,
+		Hysteresis:          10,
+	}
+	cases := []struct {
+		name    string
+		current int
+		v       uint32
+		level   int
+	}{
+		{"normal", 0, 500, 0},
+		{"rise little high", 0, 800, 1},
+		{"rise very high", 1, 900, 2},
+		{"keep very high", 2, 891, 2},
+		{"leave very high", 2, 889, 1},
+		{"keep little high", 1, 795, 1},
+		{"leave little high", 1, 789, 0},
+		{"fall little low", 0, 200, -1},
+		{"keep very low", -2, 109, -2},
+		{"leave very low", -2, 111, -1},
+		{"keep little low", -1, 205, -1},
+		{"leave little low", -1, 211, 0},
+		{"jump to very low", 2, 50, -2},
+		{"lower bound", -2, 5, -2},
+	}
+	for _, c := range cases {
+		level := numberLevelFrom(nr, c.current, c.v)
+		if level != c.level {
+			t.Errorf("%s: got %d, want %d", c.name, level, c.level)
+		}
+	}
+
+	// v+h must not wrap to a low value
+	high := &pb.Group_NumberReader{
+		LittleHighThreshold: math.MaxUint32 - 5,
+		Hysteresis:          10,
+	}
+	gobottest.Assert(t, numberLevelFrom(high, 1, math.MaxUint32-6), 1)
+	gobottest.Assert(t, numberLevelFrom(high, 1, math.MaxUint32-20), 0)
+}
+
+func TestClearAlarms(t *testing.T) {
+	gp := &pb.Group_Pin{
+		FirmataIndex: 0,
+		Id:           &pb.Group_Pin_Dx{Dx: 0},
+		Type: &pb.Group_Pin_DigitalReader{DigitalReader: &pb.Group_DigitalReader{
+			Alarm:     true,
+			HoldOffMs: 20,
+		}},
+	}
+	s := &Server{}
+	raised := &alarm{gp: gp, level: 1}
+	pending := &alarm{gp: gp}
+	s.alarms = []*alarm{raised, pending}
+
+	s.alarmMu.Lock()
+	gobottest.Assert(t, s.evaluateAlarm_l(pending, 1, 1) == nil, true)
+	gobottest.Refute(t, pending.timer == nil, true)
+	s.alarmMu.Unlock()
+
+	s.clearAlarms(0)
+	gobottest.Assert(t, len(s.activeAlarms()), 0)
+	gobottest.Assert(t, pending.timer == nil, true)
+
+	// the stopped hold-off never raises
+	time.Sleep(40 * time.Millisecond)
+	gobottest.Assert(t, len(s.activeAlarms()), 0)
+}
@@ -0,0 +1,45 @@
+package grpci
+
+import (
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/empirefox/firmata/pkg/pb"
+)
+
+// connState is the reconnecting state of a firmata, guarded by instanceMu.
+type connState struct {
+	attempt       uint32
+	lastError     string
+	nextRetry     time.Time
+	lastConnected time.Time
+}
+
+// retryDelay is the delay after the failed attempt, fixed ConnectRetrySecond
+// if Backoff is not set.
+func retryDelay(pbConfig *pb.Firmata, attempt uint32, rnd *rand.Rand) time.Duration {
+	b := pbConfig.Backoff
+	if b == nil {
+		return time.Second * time.Duration(pbConfig.ConnectRetrySecond)
+	}
+
+	ms := float64(b.InitialMs) * math.Pow(b.Multiplier, float64(attempt-1))
+	ms = math.Min(ms, float64(b.MaxMs))
+	if b.Jitter > 0 {
+		ms *= 1 + b.Jitter*(2*rnd.Float64()-1)
+	}
+	return time.Duration(ms * float64(time.Millisecond))
+}
+
+// toPbConnecting fills the state into the Connecting message.
+func (c *connState) toPbConnecting(out *pb.ServerMessage_Connecting) {
+	out.Attempt = c.attempt
+	out.LastError = c.lastError
+	if !c.nextRetry.IsZero() {
+		out.NextRetryMs = c.nextRetry.UnixNano() / int64(time.Millisecond)
+	}
+	if !c.lastConnected.IsZero() {
+		out.LastConnectedMs = c.lastConnected.UnixNano() / int64(time.Millisecond)
+	}
+}
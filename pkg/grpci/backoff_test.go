@@ -0,0 +1,52 @@
+package grpci
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/empirefox/firmata/pkg/pb"
+	"gobot.io/x/gobot/gobottest"
+)
+
+func TestRetryDelay(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+
+	fixed := &pb.Firmata{ConnectRetrySecond: 10}
+	gobottest.Assert(t, retryDelay(fixed, 1, rnd), 10*time.Second)
+	gobottest.Assert(t, retryDelay(fixed, 5, rnd), 10*time.Second)
+
+	exp := &pb.Firmata{Backoff: &pb.Firmata_Backoff{
+		InitialMs:  100,
+		MaxMs:      1000,
+		Multiplier: 2,
+	}}
+	for attempt, want := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		got := retryDelay(exp, uint32(attempt+1), rnd)
+		gobottest.Assert(t, got, want*time.Millisecond)
+	}
+	// no overflow after many attempts
+	gobottest.Assert(t, retryDelay(exp, 10000, rnd), time.Second)
+
+	jitter := &pb.Firmata{Backoff: &pb.Firmata_Backoff{
+		InitialMs:  1000,
+		MaxMs:      1000,
+		Multiplier: 2,
+		Jitter:     0.2,
+	}}
+	var min, max time.Duration = time.Hour, 0
+	for i := 0; i < 1000; i++ {
+		d := retryDelay(jitter, 3, rnd)
+		if d < min {
+			min = d
+		}
+		if d > max {
+			max = d
+		}
+	}
+	gobottest.Assert(t, min >= 800*time.Millisecond, true)
+	gobottest.Assert(t, max <= 1200*time.Millisecond, true)
+	// spread over the range
+	gobottest.Assert(t, min < 900*time.Millisecond, true)
+	gobottest.Assert(t, max > 1100*time.Millisecond, true)
+}
@@ -0,0 +1,184 @@
+package grpci
+
+import (
+	"github.com/empirefox/firmata/pkg/firmata"
+	"github.com/empirefox/firmata/pkg/pb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// switchDetect tracks the state of a Switch by its detect pin, guarded by
+// detectMu.
+type switchDetect struct {
+	group  uint32
+	gpin   uint32
+	detect *pb.Group_DigitalInputPin
+
+	// resolved by the first connection of the detect firmata
+	dx       byte
+	resolved bool
+	// reporting of the detect port, held across reconnections
+	lease *ReportLease
+	state pb.ServerMessage_SwitchState_State
+}
+
+// newSwitchDetects collects the switches which have a detect pin.
+func newSwitchDetects(config *pb.Config) map[*pb.Group_Pin]*switchDetect {
+	detects := make(map[*pb.Group_Pin]*switchDetect)
+	for gi, g := range config.GetGroups() {
+		for pi, p := range g.Pins {
+			if dt := p.GetSwitch().GetDetect(); dt != nil {
+				detects[p] = &switchDetect{
+					group:  uint32(gi),
+					gpin:   uint32(pi),
+					detect: dt,
+				}
+			}
+		}
+	}
+	return detects
+}
+
+// initDetects_l sets detect pins of firmata idx to input and holds their
+// reporting, must be called before applyReports_l.
+func (s *Server) initDetects_l(f *firmata.Firmata, idx uint32) {
+	name := f.Config.Data.(*FirmataData).PbConfig.Name
+	s.detectMu.Lock()
+	defer s.detectMu.Unlock()
+	for _, d := range s.detects {
+		dt := d.detect
+		if dt.FirmataIndex != idx {
+			continue
+		}
+
+		if !d.resolved {
+			var dx byte
+			switch dt.Id.(type) {
+			case *pb.Group_DigitalInputPin_Ax:
+				ax := dt.GetAx()
+				if ax >= uint32(len(f.AnalogPins)) {
+					s.log.Error().Str("firmata", name).
+						Uint32("ax", ax).Msg("detect pin out of index")
+					continue
+				}
+				dx = f.AnalogPins[ax].Dx
+			case *pb.Group_DigitalInputPin_Dx:
+				dx = byte(dt.GetDx())
+			case *pb.Group_DigitalInputPin_GpioName:
+				var ok bool
+				dx, ok = f.DxByName[dt.GetGpioName()]
+				if !ok {
+					s.log.Error().Str("firmata", name).
+						Stringer("gpio", dt.GetGpioName()).Msg("detect pin not found")
+					continue
+				}
+			}
+			dt.Id = &pb.Group_DigitalInputPin_Dx{Dx: uint32(dx)}
+			d.dx = dx
+			d.resolved = true
+		}
+		if d.dx >= f.TotalPins {
+			s.log.Error().Str("firmata", name).
+				Uint8("dx", d.dx).Msg("detect pin out of index")
+			continue
+		}
+
+		switch f.Pins[d.dx].Mode_l {
+		case firmata.PIN_MODE_INPUT, firmata.PIN_MODE_PULLUP:
+		default:
+			err := f.SetPinMode_l(d.dx, firmata.PIN_MODE_INPUT)
+			if err != nil {
+				s.log.Err(err).Str("firmata", name).
+					Uint8("dx", d.dx).Msg("detect pin mode")
+				continue
+			}
+		}
+		if d.lease == nil {
+			l, _, err := s.countReport(idx, false, d.dx/8)
+			if err != nil {
+				s.log.Err(err).Str("firmata", name).
+					Uint8("dx", d.dx).Msg("detect pin report")
+				continue
+			}
+			d.lease = l
+		}
+	}
+}
+
+// updateDetects_l reads the detect pins in port of firmata idx, and
+// broadcasts the changed switch states.
+func (s *Server) updateDetects_l(f *firmata.Firmata, idx uint32, port byte) {
+	var outs []*pb.ServerMessage
+	s.detectMu.Lock()
+	for _, d := range s.detects {
+		if d.detect.FirmataIndex != idx || !d.resolved || d.dx/8 != port || d.dx >= f.TotalPins {
+			continue
+		}
+		high := f.Pins[d.dx].Value_l != 0
+		state := pb.ServerMessage_SwitchState_off
+		if high != d.detect.LowLevelTrigger {
+			state = pb.ServerMessage_SwitchState_on
+		}
+		if d.state != state {
+			d.state = state
+			outs = append(outs, d.toPb_l())
+		}
+	}
+	// queued under the lock to keep the order of changes
+	s.broadcastStates(outs...)
+	s.detectMu.Unlock()
+}
+
+// clearDetects sets the switches of the disconnected firmata to unknown.
+func (s *Server) clearDetects(idx uint32) {
+	var outs []*pb.ServerMessage
+	s.detectMu.Lock()
+	for _, d := range s.detects {
+		if d.detect.FirmataIndex == idx && d.state != pb.ServerMessage_SwitchState_unknown {
+			d.state = pb.ServerMessage_SwitchState_unknown
+			outs = append(outs, d.toPb_l())
+		}
+	}
+	s.broadcastStates(outs...)
+	s.detectMu.Unlock()
+}
+
+// switchState returns the detected state of the switch gp.
+func (s *Server) switchState(gp *pb.Group_Pin) (pb.ServerMessage_SwitchState_State, error) {
+	s.detectMu.Lock()
+	defer s.detectMu.Unlock()
+	d := s.detects[gp]
+	if d == nil {
+		return pb.ServerMessage_SwitchState_unknown, status.Errorf(codes.FailedPrecondition, "switch has no detect pin")
+	}
+	return d.state, nil
+}
+
+func (s *Server) sendSwitchStatesTo(sender pb.Transport_OnServerMessageServer) (err error) {
+	s.detectMu.Lock()
+	outs := make([]*pb.ServerMessage, 0, len(s.detects))
+	for _, d := range s.detects {
+		outs = append(outs, d.toPb_l())
+	}
+	s.detectMu.Unlock()
+
+	for _, out := range outs {
+		err = sender.Send(out)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (d *switchDetect) toPb_l() *pb.ServerMessage {
+	return &pb.ServerMessage{
+		Type: &pb.ServerMessage_SwitchState_{
+			SwitchState: &pb.ServerMessage_SwitchState{
+				Group: d.group,
+				Gpin:  d.gpin,
+				State: d.state,
+			},
+		},
+	}
+}
@@ -0,0 +1,68 @@
+package grpci
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/empirefox/firmata/pkg/pb"
+	"gobot.io/x/gobot/gobottest"
+)
+
+// messageStream records the messages of OnServerMessage.
+type messageStream struct {
+	pb.Transport_OnServerMessageServer
+	out chan *pb.ServerMessage
+}
+
+func (m *messageStream) Send(out *pb.ServerMessage) error {
+	m.out <- out
+	return nil
+}
+
+func TestDetectStateOrder(t *testing.T) {
+	sw := &pb.Group_Pin{
+		Nick: "sw",
+		Mode: pb.Mode_OUTPUT,
+		Id:   &pb.Group_Pin_Dx{Dx: 0},
+		Type: &pb.Group_Pin_Switch{Switch: &pb.Group_Switch{
+			Detect: &pb.Group_DigitalInputPin{
+				Id: &pb.Group_DigitalInputPin_Dx{Dx: 1},
+			},
+		}},
+	}
+	s, _ := testServer(t, sw)
+	stream := &messageStream{out: make(chan *pb.ServerMessage, 64)}
+	s.onServerMessageMu.Lock()
+	s.onSeverMessageSenders = append(s.onSeverMessageSenders, stream)
+	s.onServerMessageMu.Unlock()
+
+	s.detectMu.Lock()
+	s.detects[sw].dx = 1
+	s.detects[sw].resolved = true
+	s.detectMu.Unlock()
+
+	f := s.instances[0].firmata
+	const changes = 32
+	err := f.WaitLoopContext(context.Background(), func() error {
+		for i := 0; i < changes; i++ {
+			f.Pins[1].Value_l = uint32(1 - i%2)
+			s.updateDetects_l(f, 0, 0)
+		}
+		return nil
+	})
+	gobottest.Assert(t, err, nil)
+
+	for i := 0; i < changes; i++ {
+		want := pb.ServerMessage_SwitchState_on
+		if i%2 == 1 {
+			want = pb.ServerMessage_SwitchState_off
+		}
+		select {
+		case out := <-stream.out:
+			gobottest.Assert(t, out.GetSwitchState().GetState(), want)
+		case <-time.After(time.Second):
+			t.Fatalf("change %d not broadcast", i)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package grpci
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	"github.com/empirefox/firmata/pkg/firmata"
+	"github.com/empirefox/firmata/pkg/pb"
+)
+
+// failsafeTaskId is the scheduler task of the failsafe watchdog.
+const failsafeTaskId byte = 0x7F
+
+// failsafeCloseTimeout limits applying the failsafe values before closing.
+const failsafeCloseTimeout = time.Second
+
+// failsafePins returns the group pins of the firmata which have a failsafe
+// value.
+func (s *Server) failsafePins(idx uint32) []*pb.Group_Pin {
+	var pins []*pb.Group_Pin
+	for _, g := range s.Config.Groups {
+		for _, p := range g.Pins {
+			if p.FirmataIndex == idx && p.Failsafe != nil {
+				pins = append(pins, p)
+			}
+		}
+	}
+	return pins
+}
+
+// failsafeMessages encodes the failsafe values as raw firmata messages. Pins
+// are skipped until their dx is resolved by the first connection.
+func (s *Server) failsafeMessages(idx uint32) []byte {
+	var buf bytes.Buffer
+	w := firmata.NewWriteFramer(&buf)
+	for _, p := range s.failsafePins(idx) {
+		id, ok := p.Id.(*pb.Group_Pin_Dx)
+		if !ok {
+			continue
+		}
+		dx := byte(id.Dx)
+		value := uint32(p.GetFailsafe())
+		w.SetPinMode(dx, byte(p.Mode))
+		switch byte(p.Mode) {
+		case firmata.PIN_MODE_PWM, firmata.PIN_MODE_SERVO:
+			if dx > 15 || value >= 0x4000 {
+				w.ExtendedAnalogWrite(dx, value)
+			} else {
+				w.AnalogWrite(dx, value)
+			}
+		default:
+			w.SetDigitalPinValue(dx, byte(value))
+		}
+	}
+	return buf.Bytes()
+}
+
+// applyFailsafe_l writes the failsafe values of the connected firmata, it
+// goes on with other pins if one fails.
+func (s *Server) applyFailsafe_l(f *firmata.Firmata, idx uint32) (err error) {
+	for _, p := range s.failsafePins(idx) {
+		dx := byte(p.GetDx())
+		e := f.SetPinMode_l(dx, byte(p.Mode))
+		if e == nil {
+			e = f.SetPinValue_l(dx, uint32(p.GetFailsafe()))
+		}
+		if e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// uploadFailsafe_l uploads the failsafe watchdog task, and schedules it.
+func (s *Server) uploadFailsafe_l(f *firmata.Firmata, idx uint32) error {
+	ms := s.Integration.Firmatas[idx].FailsafeWatchdogMs
+	if ms == 0 {
+		return nil
+	}
+	b := s.failsafeMessages(idx)
+	if len(b) == 0 {
+		return nil
+	}
+	// the scheduler frees a task after it runs to the end, so end it with a
+	// delay: it repeats while the host is silent, and the watchdog daemon can
+	// postpone it again after the host recovers
+	buf := bytes.NewBuffer(b)
+	firmata.NewWriteFramer(buf).SchedulerDelayTask(ms)
+	err := f.UploadTask_l(failsafeTaskId, buf.Bytes())
+	if err != nil {
+		return err
+	}
+	return f.ScheduleTask_l(failsafeTaskId, ms)
+}
+
+// failsafeWatchdogDaemon postpones the failsafe task before it runs. It stops
+// when inst is closed.
+func (s *Server) failsafeWatchdogDaemon(ctx context.Context, inst *Instance) {
+	ms := inst.config.FailsafeWatchdogMs
+	if ms == 0 || len(s.failsafePins(inst.index)) == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(time.Duration(ms) * time.Millisecond / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-inst.firmata.CloseNotify():
+			return
+		case <-ctx.Done():
+			return
+		}
+
+		err := inst.firmata.WaitLoopContext(ctx, func() error {
+			return inst.firmata.ScheduleTask_l(failsafeTaskId, ms)
+		})
+		if err != nil {
+			s.log.Debug().Str("type", "failsafe").
+				Str("firmata", inst.config.Name).Err(err).Send()
+		}
+	}
+}
+
+// closeFirmata applies the failsafe values, then closes the firmata.
+func (s *Server) closeFirmata(inst *Instance) {
+	ctx, cancel := context.WithTimeout(context.Background(), failsafeCloseTimeout)
+	defer cancel()
+	err := inst.firmata.WaitLoopContext(ctx, func() error {
+		return s.applyFailsafe_l(inst.firmata, inst.index)
+	})
+	if err != nil {
+		s.log.Err(err).Str("firmata", inst.config.Name).Msg("apply failsafe")
+	}
+	inst.firmata.Close()
+}
+
+// Shutdown releases the pulses, applies the failsafe values and closes every
+// firmata, they are not reconnected after it.
+func (s *Server) Shutdown() {
+	s.releasePulses()
+
+	s.instanceMu.Lock()
+	var insts []*Instance
+	for idx, inst := range s.instances {
+		s.instanceTmpDown[idx] = true
+		if inst != nil {
+			insts = append(insts, inst)
+		}
+	}
+	s.instanceMu.Unlock()
+
+	for _, inst := range insts {
+		s.closeFirmata(inst)
+	}
+}
@@ -0,0 +1,112 @@
+package grpci
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/empirefox/firmata/pkg/firmata"
+	"github.com/empirefox/firmata/pkg/pb"
+	"gobot.io/x/gobot/gobottest"
+)
+
+func failsafePin(nick string, mode pb.Mode, dx uint32, failsafe int32) *pb.Group_Pin {
+	return &pb.Group_Pin{
+		Nick:     nick,
+		Mode:     mode,
+		Id:       &pb.Group_Pin_Dx{Dx: dx},
+		Failsafe: &failsafe,
+	}
+}
+
+// writeRecorder records what the firmata writes.
+type writeRecorder struct {
+	bytes.Buffer
+}
+
+func (w *writeRecorder) Read(p []byte) (int, error) { select {} }
+func (w *writeRecorder) Close() error               { return nil }
+
+func TestFailsafeMessages(t *testing.T) {
+	unresolved := failsafePin("unresolved", pb.Mode_OUTPUT, 0, 1)
+	unresolved.Id = &pb.Group_Pin_GpioName{GpioName: pb.PinName_PA5}
+	s := &Server{Config: &pb.Config{Groups: []*pb.Group{{Pins: []*pb.Group_Pin{
+		failsafePin("out", pb.Mode_OUTPUT, 0, 1),
+		failsafePin("pwm", pb.Mode_PWM, 3, 100),
+		failsafePin("servo", pb.Mode_SERVO, 20, 1500),
+		unresolved,
+		{Nick: "none", Mode: pb.Mode_OUTPUT, Id: &pb.Group_Pin_Dx{Dx: 1}},
+	}}}}}
+
+	gobottest.Assert(t, s.failsafeMessages(0), []byte{
+		firmata.SET_PIN_MODE, 0, firmata.PIN_MODE_OUTPUT,
+		firmata.SET_DIGITAL_PIN_VALUE, 0, 1,
+		firmata.SET_PIN_MODE, 3, firmata.PIN_MODE_PWM,
+		firmata.ANALOG_MESSAGE | 3, 100, 0,
+		firmata.SET_PIN_MODE, 20, firmata.PIN_MODE_SERVO,
+		// 1500 of D20 needs EXTENDED_ANALOG
+		firmata.START_SYSEX, firmata.EXTENDED_ANALOG, 20, 0x5C, 0x0B, firmata.END_SYSEX,
+	})
+	gobottest.Assert(t, len(s.failsafeMessages(1)), 0)
+}
+
+func TestUploadFailsafe(t *testing.T) {
+	s := &Server{
+		Integration: &pb.Integration{Firmatas: []*pb.Firmata{{FailsafeWatchdogMs: 300}}},
+		Config: &pb.Config{Groups: []*pb.Group{{Pins: []*pb.Group_Pin{
+			failsafePin("out", pb.Mode_OUTPUT, 0, 0),
+		}}}},
+	}
+	w := new(writeRecorder)
+	f := firmata.NewFirmata(w, nil)
+	gobottest.Assert(t, s.uploadFailsafe_l(f, 0), nil)
+
+	task := bytes.NewBuffer(s.failsafeMessages(0))
+	firmata.NewWriteFramer(task).SchedulerDelayTask(300)
+	var want bytes.Buffer
+	wf := firmata.NewWriteFramer(&want)
+	wf.SchedulerDeleteTask(failsafeTaskId)
+	wf.SchedulerCreateTask(failsafeTaskId, task.Len())
+	wf.SchedulerAddToTask(failsafeTaskId, task.Bytes())
+	wf.SchedulerScheduleTask(failsafeTaskId, 300)
+	gobottest.Assert(t, w.Bytes(), want.Bytes())
+
+	// nothing uploaded without the watchdog
+	w.Reset()
+	s.Integration.Firmatas[0].FailsafeWatchdogMs = 0
+	gobottest.Assert(t, s.uploadFailsafe_l(f, 0), nil)
+	gobottest.Assert(t, w.Len(), 0)
+}
+
+func TestApplyFailsafe(t *testing.T) {
+	s, mem := testServer(t,
+		// D9 does not exist, the others are applied anyway
+		failsafePin("missing", pb.Mode_PWM, 9, 1),
+		failsafePin("out", pb.Mode_OUTPUT, 0, 1),
+	)
+	f := s.instances[0].firmata
+	err := f.WaitLoopContext(context.Background(), func() error {
+		return s.applyFailsafe_l(f, 0)
+	})
+	gobottest.Refute(t, err, nil)
+	waitValue(t, mem, 0, 1, time.Second)
+}
+
+func TestShutdown(t *testing.T) {
+	s, mem := testServer(t, failsafePin("out", pb.Mode_OUTPUT, 0, 0))
+	f := s.instances[0].firmata
+	gobottest.Assert(t, f.DigitalWrite(context.Background(), 0, 1), nil)
+	waitValue(t, mem, 0, 1, time.Second)
+
+	s.Shutdown()
+	select {
+	case <-f.CloseNotify():
+	case <-time.After(time.Second):
+		t.Fatal("firmata not closed")
+	}
+	waitValue(t, mem, 0, 0, time.Second)
+	s.instanceMu.Lock()
+	gobottest.Assert(t, s.instanceTmpDown[0], true)
+	s.instanceMu.Unlock()
+}
@@ -0,0 +1,76 @@
+package grpci
+
+import (
+	"context"
+	"time"
+
+	"github.com/empirefox/firmata/pkg/dial"
+)
+
+// hotplugDaemon connects a firmata as soon as its tty is plugged, and closes
+// it the moment the tty is removed.
+func (s *Server) hotplugDaemon(ctx context.Context) {
+	events, err := dial.WatchUevent(ctx)
+	if err != nil {
+		s.log.Warn().Err(err).Msg("hotplug falls back to polling sysfs")
+		events = dial.PollHotplug(ctx, time.Second)
+	}
+	for ev := range events {
+		s.log.Debug().Str("type", "hotplug").
+			Stringer("action", ev.Action).
+			Str("device", ev.Device).Send()
+		switch ev.Action {
+		case dial.HotplugAdd:
+			s.hotplugAdd(ctx, ev.Device)
+		case dial.HotplugRemove:
+			s.hotplugRemove(ev.Device)
+		}
+	}
+}
+
+func (s *Server) hotplugAdd(ctx context.Context, device string) {
+	for i, pbConfig := range s.Integration.Firmatas {
+		if pbConfig.ManualConnect || !dial.IsDeviceDial(pbConfig.Dial) {
+			continue
+		}
+		dev, err := dial.Device(pbConfig.Dial)
+		if err != nil || dev != device {
+			continue
+		}
+
+		idx := uint32(i)
+		s.instanceMu.Lock()
+		if s.instances[idx] != nil || s.instanceTmpDown[idx] {
+			s.instanceMu.Unlock()
+			continue
+		}
+		if s.instanceBuilds[idx] {
+			// sleeping in connectRetry
+			select {
+			case s.instanceWake[idx] <- struct{}{}:
+			default:
+			}
+			s.instanceMu.Unlock()
+			continue
+		}
+		s.instanceBuilds[idx] = true
+		s.instanceMu.Unlock()
+		go s.connectFirmata(ctx, idx)
+	}
+}
+
+func (s *Server) hotplugRemove(device string) {
+	var closing []*Instance
+	s.instanceMu.Lock()
+	for idx, dev := range s.instanceDevices {
+		if dev == device && s.instances[idx] != nil {
+			closing = append(closing, s.instances[idx])
+		}
+	}
+	s.instanceMu.Unlock()
+	for _, inst := range closing {
+		s.log.Debug().Str("firmata", inst.config.Name).
+			Str("device", device).Msg("unplugged")
+		inst.firmata.Close()
+	}
+}
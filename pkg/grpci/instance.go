@@ -36,6 +36,7 @@ func (inst *Instance) ToPb_l() (out *pb.Instance) {
 		FirmwareVersion:  f.FirmwareVersion,
 		Pins:             f.PinsToPb_l(),
 		PortConfigInputs: f.PortConfigInputs_l[:f.TotalPorts],
+		BoardSerial:      f.BoardSerial,
 	}
 }
 
@@ -0,0 +1,136 @@
+package grpci
+
+import (
+	"github.com/empirefox/firmata/pkg/firmata"
+	"github.com/empirefox/firmata/pkg/pb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// checkPinValue validates SetPinValue by the type of the group pin.
+func checkPinValue(gp *pb.Group_Pin, value uint32) error {
+	switch t := gp.Type.(type) {
+	case *pb.Group_Pin_DigitalReader, *pb.Group_Pin_NumberReader:
+		return status.Errorf(codes.FailedPrecondition,
+			"pin %s is an input", gp.Nick)
+	case *pb.Group_Pin_Button:
+		return status.Errorf(codes.FailedPrecondition,
+			"button %s accepts TriggerDigitalPin only", gp.Nick)
+	case *pb.Group_Pin_Switch:
+		if t.Switch.TriggerMs != 0 {
+			return status.Errorf(codes.FailedPrecondition,
+				"switch %s accepts TriggerDigitalPin only", gp.Nick)
+		}
+		if value > 1 {
+			return status.Errorf(codes.InvalidArgument,
+				"switch %s value must be 0 or 1: %d", gp.Nick, value)
+		}
+	case *pb.Group_Pin_NumberWriter:
+		w := t.NumberWriter
+		if value < w.Min || (w.Max != 0 && value > w.Max) {
+			return status.Errorf(codes.InvalidArgument,
+				"number %s value out of [%d, %d]: %d", gp.Nick, w.Min, w.Max, value)
+		}
+		if w.Step > 1 && (value-w.Min)%w.Step != 0 {
+			return status.Errorf(codes.InvalidArgument,
+				"number %s value is not min %d plus multiple of step %d: %d",
+				gp.Nick, w.Min, w.Step, value)
+		}
+	}
+	return nil
+}
+
+// checkTrigger validates TriggerDigitalPin by the type of the group pin.
+func checkTrigger(gp *pb.Group_Pin, in *pb.TriggerDigitalPinRequest) error {
+	var triggerMs uint32
+	switch t := gp.Type.(type) {
+	case *pb.Group_Pin_Button:
+		if in.Ensure != pb.TriggerDigitalPinRequest_toggle {
+			return status.Errorf(codes.InvalidArgument,
+				"TriggerDigitalPinRequest.Ensure accepts switch type only")
+		}
+		triggerMs = t.Button.TriggerMs
+	case *pb.Group_Pin_Switch:
+		if in.Ensure != pb.TriggerDigitalPinRequest_toggle && t.Switch.Detect == nil {
+			return status.Errorf(codes.FailedPrecondition,
+				"switch %s has no detect pin", gp.Nick)
+		}
+		triggerMs = t.Switch.TriggerMs
+	default:
+		return status.Errorf(codes.FailedPrecondition,
+			"TriggerDigitalPin accepts button/switch type: %T", gp.Type)
+	}
+	if in.RealtimeTriggerMs == 0 && triggerMs == 0 {
+		return status.Errorf(codes.InvalidArgument,
+			"TriggerDigitalPinRequest.RealtimeTriggerMs is required")
+	}
+	return nil
+}
+
+// checkPinMode_l rejects SetPinMode on group pins without mutableMode, and on
+// detect pins of switches. It returns the mutable group pin at dx if any.
+func (s *Server) checkPinMode_l(inst *Instance, dx byte) (*pb.Group_Pin, error) {
+	var mutable *pb.Group_Pin
+	for _, g := range s.Config.Groups {
+		for _, p := range g.Pins {
+			if p.FirmataIndex != inst.index {
+				continue
+			}
+			pdx, ok := groupPinDx_l(inst.firmata, p)
+			if !ok || pdx != dx {
+				continue
+			}
+			if p.MutableMode {
+				mutable = p
+				continue
+			}
+			return nil, status.Errorf(codes.FailedPrecondition,
+				"mode of group %s pin %s is immutable", g.Name, p.Nick)
+		}
+	}
+
+	s.detectMu.Lock()
+	defer s.detectMu.Unlock()
+	for _, d := range s.detects {
+		if d.detect.FirmataIndex == inst.index && d.resolved && d.dx == dx {
+			return nil, status.Errorf(codes.FailedPrecondition,
+				"pin %d is the detect pin of a switch", dx)
+		}
+	}
+	return mutable, nil
+}
+
+// groupPinAt_l returns the group pin at dx of the instance, nil if not found.
+func (s *Server) groupPinAt_l(inst *Instance, dx byte) *pb.Group_Pin {
+	for _, g := range s.Config.Groups {
+		for _, p := range g.Pins {
+			if p.FirmataIndex != inst.index {
+				continue
+			}
+			pdx, ok := groupPinDx_l(inst.firmata, p)
+			if ok && pdx == dx {
+				return p
+			}
+		}
+	}
+	return nil
+}
+
+// groupPinDx_l resolves the pin of the firmata which may be not connected
+// before.
+func groupPinDx_l(f *firmata.Firmata, p *pb.Group_Pin) (byte, bool) {
+	switch p.Id.(type) {
+	case *pb.Group_Pin_Ax:
+		ax := p.GetAx()
+		if ax >= uint32(len(f.AnalogPins)) {
+			return 0, false
+		}
+		return f.AnalogPins[ax].Dx, true
+	case *pb.Group_Pin_Dx:
+		return byte(p.GetDx()), true
+	case *pb.Group_Pin_GpioName:
+		dx, ok := f.DxByName[p.GetGpioName()]
+		return dx, ok
+	}
+	return 0, false
+}
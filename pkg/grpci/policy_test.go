@@ -0,0 +1,103 @@
+package grpci
+
+import (
+	"context"
+	"testing"
+
+	"github.com/empirefox/firmata/pkg/firmata"
+	"github.com/empirefox/firmata/pkg/pb"
+	"gobot.io/x/gobot/gobottest"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestCheckPinValue(t *testing.T) {
+	number := func(min, max, step uint32) *pb.Group_Pin {
+		return &pb.Group_Pin{Type: &pb.Group_Pin_NumberWriter{
+			NumberWriter: &pb.Group_NumberWriter{Min: min, Max: max, Step: step},
+		}}
+	}
+	swtch := func(triggerMs uint32) *pb.Group_Pin {
+		return &pb.Group_Pin{Type: &pb.Group_Pin_Switch{
+			Switch: &pb.Group_Switch{TriggerMs: triggerMs},
+		}}
+	}
+	cases := []struct {
+		name  string
+		gp    *pb.Group_Pin
+		value uint32
+		code  codes.Code
+	}{
+		{"min", number(10, 20, 0), 10, codes.OK},
+		{"max", number(10, 20, 0), 20, codes.OK},
+		{"below min", number(10, 20, 0), 9, codes.InvalidArgument},
+		{"above max", number(10, 20, 0), 21, codes.InvalidArgument},
+		{"zero max", number(10, 0, 0), 1 << 20, codes.OK},
+		{"step", number(10, 20, 5), 15, codes.OK},
+		{"off step", number(10, 20, 5), 16, codes.InvalidArgument},
+		{"digital reader", &pb.Group_Pin{Type: &pb.Group_Pin_DigitalReader{}}, 1, codes.FailedPrecondition},
+		{"number reader", &pb.Group_Pin{Type: &pb.Group_Pin_NumberReader{}}, 1, codes.FailedPrecondition},
+		{"button", &pb.Group_Pin{Type: &pb.Group_Pin_Button{Button: &pb.Group_Button{}}}, 1, codes.FailedPrecondition},
+		{"toggle switch", swtch(0), 1, codes.OK},
+		{"toggle switch value", swtch(0), 2, codes.InvalidArgument},
+		{"trigger switch", swtch(100), 1, codes.FailedPrecondition},
+	}
+	for _, c := range cases {
+		err := checkPinValue(c.gp, c.value)
+		if status.Code(err) != c.code {
+			t.Errorf("%s: got %v, want %v", c.name, err, c.code)
+		}
+	}
+}
+
+func TestCheckTrigger(t *testing.T) {
+	button := &pb.Group_Pin{Type: &pb.Group_Pin_Button{Button: &pb.Group_Button{TriggerMs: 100}}}
+	swtch := &pb.Group_Pin{Type: &pb.Group_Pin_Switch{Switch: &pb.Group_Switch{}}}
+	cases := []struct {
+		name string
+		gp   *pb.Group_Pin
+		in   *pb.TriggerDigitalPinRequest
+		code codes.Code
+	}{
+		{"button", button, &pb.TriggerDigitalPinRequest{}, codes.OK},
+		{"button ensure", button, &pb.TriggerDigitalPinRequest{Ensure: pb.TriggerDigitalPinRequest_on}, codes.InvalidArgument},
+		{"switch without triggerMs", swtch, &pb.TriggerDigitalPinRequest{}, codes.InvalidArgument},
+		{"switch realtime", swtch, &pb.TriggerDigitalPinRequest{RealtimeTriggerMs: 100}, codes.OK},
+		{"switch ensure without detect", swtch, &pb.TriggerDigitalPinRequest{Ensure: pb.TriggerDigitalPinRequest_on, RealtimeTriggerMs: 100}, codes.FailedPrecondition},
+		{"number", &pb.Group_Pin{Type: &pb.Group_Pin_NumberWriter{}}, &pb.TriggerDigitalPinRequest{}, codes.FailedPrecondition},
+	}
+	for _, c := range cases {
+		err := checkTrigger(c.gp, c.in)
+		if status.Code(err) != c.code {
+			t.Errorf("%s: got %v, want %v", c.name, err, c.code)
+		}
+	}
+}
+
+func TestCheckPinMode(t *testing.T) {
+	// Ax and GpioName are not resolved without OnConnected
+	s, mem := testServer(t,
+		&pb.Group_Pin{Nick: "a0", Id: &pb.Group_Pin_Ax{Ax: 0}},
+		&pb.Group_Pin{
+			Nick:        "pa1",
+			Id:          &pb.Group_Pin_GpioName{GpioName: pb.PinName_PA1},
+			MutableMode: true,
+			Confirm:     &pb.Group_Confirm{},
+		},
+	)
+	ctx := context.Background()
+	setMode := func(dx uint32) error {
+		_, err := s.SetPinMode(ctx, &pb.SetPinModeRequest{Dx: dx, Mode: pb.Mode_OUTPUT})
+		return err
+	}
+
+	gobottest.Assert(t, status.Code(setMode(2)), codes.FailedPrecondition)
+	gobottest.Assert(t, mem.Mode(2), firmata.PIN_MODE_ANALOG)
+
+	// confirmed by the mutable group pin
+	gobottest.Assert(t, setMode(1), nil)
+	gobottest.Assert(t, mem.Mode(1), firmata.PIN_MODE_OUTPUT)
+
+	gobottest.Assert(t, setMode(0), nil)
+	gobottest.Assert(t, status.Code(setMode(256)), codes.InvalidArgument)
+}
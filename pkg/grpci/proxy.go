@@ -0,0 +1,386 @@
+package grpci
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/empirefox/firmata/pkg/firmata"
+	"github.com/empirefox/firmata/pkg/firmata/device"
+)
+
+// proxyQueueSize is the max pending messages of a proxy client, the slow
+// client is dropped instead of blocking the serve loop.
+const proxyQueueSize = 256
+
+var errProxyReadOnly = errors.New("proxy is read-only")
+
+// proxy shares a firmata with raw firmata clients by Firmata.proxyListen.
+// Handshake queries are answered from the cached state, writes are checked by
+// the group pins and forwarded through the serve loop if Firmata.proxyWritable,
+// and reports are fanned out to the clients which enabled them.
+type proxy struct {
+	mu      sync.Mutex
+	clients map[*proxyClient]struct{}
+}
+
+type proxyClient struct {
+	conn net.Conn
+	out  chan []byte
+
+	// leases are only touched by the client goroutine
+	digitalLeases [16]*ReportLease
+	analogLeases  [16]*ReportLease
+
+	mu      sync.Mutex
+	digital [16]bool
+	analog  [16]bool
+}
+
+// send drops the client if it is too slow.
+func (c *proxyClient) send(b []byte) {
+	select {
+	case c.out <- b:
+	default:
+		c.conn.Close()
+	}
+}
+
+func (c *proxyClient) reports(analog bool, pin byte) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if analog {
+		return c.analog[pin]
+	}
+	return c.digital[pin]
+}
+
+func (c *proxyClient) writeLoop(ctx context.Context) {
+	for {
+		select {
+		case b := <-c.out:
+			if _, err := c.conn.Write(b); err != nil {
+				c.conn.Close()
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *Server) proxyDaemon(ctx context.Context, idx uint32, addr string) {
+	name := s.Integration.Firmatas[idx].Name
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		s.log.Error().Str("firmata", name).Str("proxy", addr).Err(err).Send()
+		return
+	}
+	go func() {
+		<-ctx.Done()
+		lis.Close()
+	}()
+	s.log.Info().Str("firmata", name).Str("proxy", lis.Addr().String()).Send()
+
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			if e, ok := err.(temporary); ok && e.Temporary() {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			s.log.Error().Str("firmata", name).Str("proxy", addr).Err(err).Send()
+			return
+		}
+		go s.serveProxyClient(ctx, idx, conn)
+	}
+}
+
+func (s *Server) serveProxyClient(ctx context.Context, idx uint32, conn net.Conn) {
+	name := s.Integration.Firmatas[idx].Name
+	s.log.Debug().Str("firmata", name).Str("proxy-client", conn.RemoteAddr().String()).
+		Msg("connected")
+
+	c := &proxyClient{conn: conn, out: make(chan []byte, proxyQueueSize)}
+	p := s.proxies[idx]
+	p.mu.Lock()
+	p.clients[c] = struct{}{}
+	p.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer func() {
+		cancel()
+		p.mu.Lock()
+		delete(p.clients, c)
+		p.mu.Unlock()
+		conn.Close()
+		for _, l := range c.digitalLeases {
+			if l != nil {
+				l.Release(context.Background())
+			}
+		}
+		for _, l := range c.analogLeases {
+			if l != nil {
+				l.Release(context.Background())
+			}
+		}
+		s.log.Debug().Str("firmata", name).Str("proxy-client", conn.RemoteAddr().String()).
+			Msg("disconnected")
+	}()
+	go c.writeLoop(ctx)
+
+	cr := device.NewCommandReader(conn)
+	for {
+		cmd, err := cr.ReadCommand()
+		if err != nil {
+			return
+		}
+		err = s.handleProxyCommand(ctx, idx, c, cmd)
+		if err != nil {
+			s.log.Debug().Str("firmata", name).Err(err).Msg("proxy command")
+			c.send(device.StringReply([]byte(err.Error())))
+		}
+	}
+}
+
+func (s *Server) handleProxyCommand(ctx context.Context, idx uint32, c *proxyClient, cmd *device.Command) error {
+	switch cmd.Type {
+	case firmata.SYSTEM_RESET:
+		// planet owns the board
+		return nil
+	case firmata.REPORT_DIGITAL:
+		return s.proxyReport(ctx, idx, c, false, cmd.Pin, cmd.Value != 0)
+	case firmata.REPORT_ANALOG:
+		return s.proxyReport(ctx, idx, c, true, cmd.Pin, cmd.Value != 0)
+	case firmata.START_SYSEX:
+		if len(cmd.Sysex) == 0 || cmd.Sysex[0] == firmata.SAMPLING_INTERVAL {
+			// planet owns the sampling interval
+			return nil
+		}
+	}
+
+	return s.loopFromFirmata(ctx, idx, func(inst *Instance) error {
+		f := inst.firmata
+		switch cmd.Type {
+		case firmata.REPORT_VERSION:
+			v := f.ProtocolVersion.Server
+			c.send(device.VersionReply(byte(v.Major), byte(v.Minor)))
+		case firmata.SET_PIN_MODE:
+			if !inst.config.ProxyWritable {
+				return errProxyReadOnly
+			}
+			_, err := s.checkPinMode_l(inst, cmd.Pin)
+			if err != nil {
+				return err
+			}
+			return f.SetPinMode_l(cmd.Pin, byte(cmd.Value))
+		case firmata.SET_DIGITAL_PIN_VALUE:
+			var value byte
+			if cmd.Value != 0 {
+				value = 1
+			}
+			err := s.checkProxyWrite_l(inst, cmd.Pin, uint32(value))
+			if err != nil {
+				return err
+			}
+			return f.SetDigitalPinValue_l(cmd.Pin, value)
+		case firmata.DIGITAL_MESSAGE:
+			err := s.checkProxyPortWrite_l(inst, cmd.Pin, byte(cmd.Value))
+			if err != nil {
+				return err
+			}
+			_, err = f.DigitalWrite_l(cmd.Pin, byte(cmd.Value))
+			return err
+		case firmata.ANALOG_MESSAGE:
+			err := s.checkProxyWrite_l(inst, cmd.Pin, cmd.Value)
+			if err != nil {
+				return err
+			}
+			return f.AnalogWrite_l(cmd.Pin, cmd.Value)
+		case firmata.START_SYSEX:
+			return s.handleProxySysex_l(inst, c, cmd.Sysex)
+		}
+		return nil
+	})
+}
+
+// checkProxyWrite_l validates a write of a proxy client like SetPinValue
+// if the pin is a group pin.
+func (s *Server) checkProxyWrite_l(inst *Instance, dx byte, value uint32) error {
+	if !inst.config.ProxyWritable {
+		return errProxyReadOnly
+	}
+	gp := s.groupPinAt_l(inst, dx)
+	if gp == nil {
+		return nil
+	}
+	return checkPinValue(gp, value)
+}
+
+// checkProxyPortWrite_l validates the changed output pins of a port write.
+func (s *Server) checkProxyPortWrite_l(inst *Instance, port byte, values byte) error {
+	if !inst.config.ProxyWritable {
+		return errProxyReadOnly
+	}
+	f := inst.firmata
+	if port >= f.TotalPorts {
+		return fmt.Errorf("DigitalWrite port out of index: %d", port)
+	}
+	for i, p := range f.PortPins_l(port) {
+		if p.Mode_l != firmata.PIN_MODE_OUTPUT {
+			continue
+		}
+		value := uint32(values>>i) & 1
+		if p.Value_l == value {
+			continue
+		}
+		err := s.checkProxyWrite_l(inst, p.Dx, value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Server) handleProxySysex_l(inst *Instance, c *proxyClient, data []byte) error {
+	f := inst.firmata
+	switch data[0] {
+	case firmata.REPORT_FIRMWARE:
+		v := f.FirmwareVersion.Server
+		c.send(device.FirmwareReply(v.Name, byte(v.Major), byte(v.Minor)))
+	case firmata.CAPABILITY_QUERY:
+		c.send(device.CapabilityReply(proxyPinConfigs_l(f)))
+	case firmata.ANALOG_MAPPING_QUERY:
+		c.send(device.AnalogMappingReply(proxyPinConfigs_l(f)))
+	case firmata.UD_PIN_NAMES_REQUEST:
+		c.send(device.PinNamesReply(proxyPinConfigs_l(f)))
+	case firmata.UD_BOARD_SERIAL_REQUEST:
+		if f.BoardSerial != "" {
+			c.send(device.BoardSerialReply(f.BoardSerial))
+		}
+	case firmata.PIN_STATE_QUERY:
+		if len(data) < 2 || data[1] >= f.TotalPins {
+			return fmt.Errorf("PIN_STATE_QUERY invalid pin")
+		}
+		p := f.Pins[data[1]]
+		c.send(device.PinStateReply(p.Dx, p.Mode_l, p.State_l))
+	case firmata.EXTENDED_ANALOG:
+		if len(data) < 3 {
+			return fmt.Errorf("EXTENDED_ANALOG too short")
+		}
+		var value uint32
+		for i, b := range data[2:] {
+			value |= uint32(b&0x7F) << (7 * uint(i))
+		}
+		err := s.checkProxyWrite_l(inst, data[1], value)
+		if err != nil {
+			return err
+		}
+		return f.AnalogWrite_l(data[1], value)
+	case firmata.STRING_DATA:
+		if !inst.config.ProxyWritable {
+			return errProxyReadOnly
+		}
+		return f.StringWrite_l(firmata.From14bits(data[1:]))
+	default:
+		if !inst.config.ProxyWritable {
+			return errProxyReadOnly
+		}
+		// replies are fanned out by OnSysexResponse and OnI2cReply
+		return f.SysexWrite_l(data)
+	}
+	return nil
+}
+
+// proxyReport enables reporting by a lease of the client. The current port
+// values are sent at once on enabling digital reporting like firmware does.
+func (s *Server) proxyReport(ctx context.Context, idx uint32, c *proxyClient, analog bool, pin byte, enable bool) error {
+	leases := &c.digitalLeases
+	if analog {
+		leases = &c.analogLeases
+	}
+
+	if !enable {
+		c.mu.Lock()
+		if analog {
+			c.analog[pin] = false
+		} else {
+			c.digital[pin] = false
+		}
+		c.mu.Unlock()
+		if leases[pin] != nil {
+			leases[pin].Release(ctx)
+			leases[pin] = nil
+		}
+		return nil
+	}
+
+	if leases[pin] == nil {
+		l, err := s.AcquireReport(ctx, idx, analog, pin)
+		if err != nil {
+			return err
+		}
+		leases[pin] = l
+	}
+	c.mu.Lock()
+	if analog {
+		c.analog[pin] = true
+	} else {
+		c.digital[pin] = true
+	}
+	c.mu.Unlock()
+
+	if analog {
+		return nil
+	}
+	return s.loopFromFirmata(ctx, idx, func(inst *Instance) error {
+		f := inst.firmata
+		if pin >= f.TotalPorts {
+			return nil
+		}
+		inputs := f.PortConfigInputs_l[pin]
+		var values byte
+		for i, p := range f.PortPins_l(pin) {
+			if inputs&(1<<i) != 0 && p.Value_l != 0 {
+				values |= 1 << i
+			}
+		}
+		c.send(device.DigitalMessage(pin, values))
+		return nil
+	})
+}
+
+func proxyPinConfigs_l(f *firmata.Firmata) []device.PinConfig {
+	pins := make([]device.PinConfig, len(f.Pins))
+	for dx, p := range f.Pins {
+		pins[dx] = device.PinConfig{Name: p.Name, Ax: p.Ax, Modes: p.Modes}
+	}
+	return pins
+}
+
+// proxyReportMessage sends b to the proxy clients which enabled the reporting.
+func (s *Server) proxyReportMessage(idx uint32, analog bool, pin byte, b []byte) {
+	p := s.proxies[idx]
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for c := range p.clients {
+		if c.reports(analog, pin) {
+			c.send(b)
+		}
+	}
+}
+
+func (s *Server) proxyBroadcast(idx uint32, b []byte) {
+	p := s.proxies[idx]
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for c := range p.clients {
+		c.send(b)
+	}
+}
@@ -0,0 +1,59 @@
+package grpci
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/empirefox/firmata/pkg/firmata"
+	"github.com/empirefox/firmata/pkg/firmata/device"
+	"github.com/empirefox/firmata/pkg/pb"
+	"gobot.io/x/gobot/gobottest"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestProxyWrites(t *testing.T) {
+	s, mem := testServer(t,
+		buttonPin(100, pb.Group_reject),
+		&pb.Group_Pin{
+			Nick: "sw",
+			Mode: pb.Mode_OUTPUT,
+			Id:   &pb.Group_Pin_Dx{Dx: 1},
+			Type: &pb.Group_Pin_Switch{Switch: &pb.Group_Switch{}},
+		},
+	)
+	ctx := context.Background()
+	c := &proxyClient{out: make(chan []byte, proxyQueueSize)}
+	write := func(pin byte, value uint32) error {
+		return s.handleProxyCommand(ctx, 0, c, &device.Command{
+			Type:  firmata.SET_DIGITAL_PIN_VALUE,
+			Pin:   pin,
+			Value: value,
+		})
+	}
+
+	// read-only by default
+	gobottest.Assert(t, write(1, 1), errProxyReadOnly)
+	err := s.handleProxyCommand(ctx, 0, c, &device.Command{Type: firmata.REPORT_VERSION})
+	gobottest.Assert(t, err, nil)
+	gobottest.Assert(t, len(c.out), 1)
+
+	s.Integration.Firmatas[0].ProxyWritable = true
+	gobottest.Assert(t, write(1, 1), nil)
+	waitValue(t, mem, 1, 1, time.Second)
+
+	// button accepts TriggerDigitalPin only
+	gobottest.Assert(t, status.Code(write(0, 1)), codes.FailedPrecondition)
+	err = s.handleProxyCommand(ctx, 0, c, &device.Command{
+		Type: firmata.DIGITAL_MESSAGE, Pin: 0, Value: 0b11,
+	})
+	gobottest.Assert(t, status.Code(err), codes.FailedPrecondition)
+	gobottest.Assert(t, mem.Value(0), uint32(0))
+
+	// mode of group pins is immutable
+	err = s.handleProxyCommand(ctx, 0, c, &device.Command{
+		Type: firmata.SET_PIN_MODE, Pin: 1, Value: uint32(firmata.PIN_MODE_INPUT),
+	})
+	gobottest.Assert(t, status.Code(err), codes.FailedPrecondition)
+}
@@ -0,0 +1,204 @@
+package grpci
+
+import (
+	"context"
+	"time"
+
+	"github.com/empirefox/firmata/pkg/pb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// pulse is a triggered group pin waiting to be released, guarded by pulseMu.
+type pulse struct {
+	id      uint64
+	group   uint32
+	gpin    uint32
+	inst    *Instance
+	gp      *pb.Group_Pin
+	release byte
+	end     time.Time
+	timer   *time.Timer
+}
+
+func (p *pulse) endMs() int64 {
+	return p.end.UnixNano() / int64(time.Millisecond)
+}
+
+// startPulse writes active to the pin, then releases it after d by a timer,
+// so it does not depend on the caller.
+func (s *Server) startPulse(ctx context.Context, group, gpin uint32, inst *Instance, gp *pb.Group_Pin,
+	active, release byte, d time.Duration, overlap pb.Group_PulseOverlap) (*pulse, error) {
+	s.pulseMu.Lock()
+	if old := s.pulseByPin[gp]; old != nil {
+		switch overlap {
+		case pb.Group_extend:
+			if end := time.Now().Add(d); end.After(old.end) {
+				old.end = end
+				// releasePulse resets the timer if it fires before end, the
+				// timer is nil until the first write is done
+				if old.timer != nil {
+					old.timer.Reset(d)
+				}
+			}
+			s.pulseMu.Unlock()
+			s.broadcastPulse(old, pb.ServerMessage_Pulse_extended, nil)
+			return old, nil
+		case pb.Group_restart:
+			s.removePulse_l(old)
+			s.pulseMu.Unlock()
+			s.endPulse(old, pb.ServerMessage_Pulse_ended)
+			s.pulseMu.Lock()
+			if s.pulseByPin[gp] != nil {
+				s.pulseMu.Unlock()
+				return nil, status.Errorf(codes.FailedPrecondition, "group %d pin %d is pulsing", group, gpin)
+			}
+		default:
+			s.pulseMu.Unlock()
+			return nil, status.Errorf(codes.FailedPrecondition, "group %d pin %d is pulsing", group, gpin)
+		}
+	}
+
+	s.pulseSeq++
+	p := &pulse{
+		id:      s.pulseSeq,
+		group:   group,
+		gpin:    gpin,
+		inst:    inst,
+		gp:      gp,
+		release: release,
+		end:     time.Now().Add(d),
+	}
+	// reserve the pin before writing
+	s.pulseByPin[gp] = p
+	s.pulseById[p.id] = p
+	s.pulseMu.Unlock()
+
+	err := s.digitalWrite(ctx, inst, gp, active)
+	if err != nil {
+		s.pulseMu.Lock()
+		s.removePulse_l(p)
+		s.pulseMu.Unlock()
+		return nil, err
+	}
+	s.broadcastPinValue(inst, gp, active)
+
+	s.pulseMu.Lock()
+	if s.pulseById[p.id] != p {
+		// canceled or restarted while writing, the release may be written
+		// before active
+		again := s.pulseByPin[gp] == nil
+		s.pulseMu.Unlock()
+		if again {
+			s.digitalWrite(context.Background(), inst, gp, release)
+		}
+		return nil, status.Errorf(codes.Aborted, "group %d pin %d pulse is canceled", group, gpin)
+	}
+	// counted from the active write, but never shorten an extended end
+	if end := time.Now().Add(d); end.After(p.end) {
+		p.end = end
+	}
+	p.timer = time.AfterFunc(time.Until(p.end), func() { s.releasePulse(p) })
+	s.pulseMu.Unlock()
+	s.broadcastPulse(p, pb.ServerMessage_Pulse_started, nil)
+	return p, nil
+}
+
+// releasePulse is run by the timer of p.
+func (s *Server) releasePulse(p *pulse) {
+	s.pulseMu.Lock()
+	if s.pulseById[p.id] != p {
+		s.pulseMu.Unlock()
+		return
+	}
+	if left := time.Until(p.end); left > 0 {
+		// extended
+		p.timer.Reset(left)
+		s.pulseMu.Unlock()
+		return
+	}
+	s.removePulse_l(p)
+	s.pulseMu.Unlock()
+	s.endPulse(p, pb.ServerMessage_Pulse_ended)
+}
+
+// cancelPulse releases the pulse now.
+func (s *Server) cancelPulse(id uint64) error {
+	s.pulseMu.Lock()
+	p := s.pulseById[id]
+	if p == nil {
+		s.pulseMu.Unlock()
+		return status.Errorf(codes.NotFound, "pulse not found: %d", id)
+	}
+	s.removePulse_l(p)
+	s.pulseMu.Unlock()
+	s.endPulse(p, pb.ServerMessage_Pulse_canceled)
+	return nil
+}
+
+// releasePulses releases all pulses before shutdown.
+func (s *Server) releasePulses() {
+	s.pulseMu.Lock()
+	ps := make([]*pulse, 0, len(s.pulseById))
+	for _, p := range s.pulseById {
+		s.removePulse_l(p)
+		ps = append(ps, p)
+	}
+	s.pulseMu.Unlock()
+	for _, p := range ps {
+		s.endPulse(p, pb.ServerMessage_Pulse_canceled)
+	}
+}
+
+func (s *Server) removePulse_l(p *pulse) {
+	if p.timer != nil {
+		p.timer.Stop()
+	}
+	delete(s.pulseByPin, p.gp)
+	delete(s.pulseById, p.id)
+}
+
+// endPulse writes the release value even if the caller has gone.
+func (s *Server) endPulse(p *pulse, status pb.ServerMessage_Pulse_Status) {
+	err := s.digitalWrite(context.Background(), p.inst, p.gp, p.release)
+	if err != nil {
+		s.log.Err(err).Str("firmata", p.inst.config.Name).
+			Uint64("pulse", p.id).Msg("release pulse")
+	} else {
+		s.broadcastPinValue(p.inst, p.gp, p.release)
+	}
+	s.broadcastPulse(p, status, err)
+}
+
+// broadcastPinValue reports the written value of a digital group pin.
+func (s *Server) broadcastPinValue(inst *Instance, gp *pb.Group_Pin, value byte) {
+	dx := byte(gp.GetDx())
+	s.broadcastServerMessage(&pb.ServerMessage{
+		Type: &pb.ServerMessage_Digital_{
+			Digital: &pb.ServerMessage_Digital{
+				Firmata: inst.index,
+				Port:    uint32(dx / 8),
+				Pins:    1 << (dx % 8),
+				Values:  uint32(value) << (dx % 8),
+			},
+		},
+	})
+}
+
+func (s *Server) broadcastPulse(p *pulse, status pb.ServerMessage_Pulse_Status, reason error) {
+	s.pulseMu.Lock()
+	out := &pb.ServerMessage_Pulse{
+		Id:     p.id,
+		Group:  p.group,
+		Gpin:   p.gpin,
+		Status: status,
+		EndMs:  p.endMs(),
+	}
+	s.pulseMu.Unlock()
+	if reason != nil {
+		out.Error = reason.Error()
+	}
+	s.broadcastServerMessage(&pb.ServerMessage{
+		Type: &pb.ServerMessage_Pulse_{Pulse: out},
+	})
+}
@@ -0,0 +1,126 @@
+package grpci
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/empirefox/firmata/pkg/pb"
+	"gobot.io/x/gobot/gobottest"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func buttonPin(triggerMs uint32, overlap pb.Group_PulseOverlap) *pb.Group_Pin {
+	return &pb.Group_Pin{
+		Nick: "btn",
+		Mode: pb.Mode_OUTPUT,
+		Id:   &pb.Group_Pin_Dx{Dx: 0},
+		Type: &pb.Group_Pin_Button{Button: &pb.Group_Button{
+			TriggerMs: triggerMs,
+			Overlap:   overlap,
+		}},
+	}
+}
+
+func TestPulseReleaseAfterCallerGone(t *testing.T) {
+	s, mem := testServer(t, buttonPin(100, pb.Group_reject))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	h, err := s.TriggerDigitalPin(ctx, &pb.TriggerDigitalPinRequest{})
+	cancel()
+	gobottest.Assert(t, err, nil)
+	gobottest.Refute(t, h.Id, uint64(0))
+	waitValue(t, mem, 0, 1, time.Second)
+
+	waitValue(t, mem, 0, 0, time.Second)
+}
+
+func TestPulseReject(t *testing.T) {
+	s, mem := testServer(t, buttonPin(1000, pb.Group_reject))
+	ctx := context.Background()
+
+	_, err := s.TriggerDigitalPin(ctx, &pb.TriggerDigitalPinRequest{})
+	gobottest.Assert(t, err, nil)
+	_, err = s.TriggerDigitalPin(ctx, &pb.TriggerDigitalPinRequest{})
+	gobottest.Assert(t, status.Code(err), codes.FailedPrecondition)
+	waitValue(t, mem, 0, 1, time.Second)
+}
+
+func TestPulseExtend(t *testing.T) {
+	s, mem := testServer(t, buttonPin(100, pb.Group_extend))
+	ctx := context.Background()
+
+	// extend while the first write may be in progress
+	var wg sync.WaitGroup
+	ids := make(chan uint64, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h, err := s.TriggerDigitalPin(ctx, &pb.TriggerDigitalPinRequest{})
+			if err == nil {
+				ids <- h.Id
+			}
+		}()
+	}
+	wg.Wait()
+	close(ids)
+	first := <-ids
+	for id := range ids {
+		gobottest.Assert(t, id, first)
+	}
+
+	h1, err := s.TriggerDigitalPin(ctx, &pb.TriggerDigitalPinRequest{})
+	gobottest.Assert(t, err, nil)
+	time.Sleep(60 * time.Millisecond)
+	h2, err := s.TriggerDigitalPin(ctx, &pb.TriggerDigitalPinRequest{})
+	gobottest.Assert(t, err, nil)
+	gobottest.Assert(t, h2.Id, h1.Id)
+	gobottest.Assert(t, h2.EndMs > h1.EndMs, true)
+
+	// the first end has passed
+	time.Sleep(60 * time.Millisecond)
+	gobottest.Assert(t, mem.Value(0), uint32(1))
+	waitValue(t, mem, 0, 0, time.Second)
+}
+
+func TestPulseRestart(t *testing.T) {
+	s, mem := testServer(t, buttonPin(100, pb.Group_restart))
+	ctx := context.Background()
+
+	h1, err := s.TriggerDigitalPin(ctx, &pb.TriggerDigitalPinRequest{})
+	gobottest.Assert(t, err, nil)
+	time.Sleep(60 * time.Millisecond)
+	h2, err := s.TriggerDigitalPin(ctx, &pb.TriggerDigitalPinRequest{})
+	gobottest.Assert(t, err, nil)
+	gobottest.Refute(t, h2.Id, h1.Id)
+
+	time.Sleep(60 * time.Millisecond)
+	gobottest.Assert(t, mem.Value(0), uint32(1))
+	waitValue(t, mem, 0, 0, time.Second)
+
+	err = s.cancelPulse(h1.Id)
+	gobottest.Assert(t, status.Code(err), codes.NotFound)
+}
+
+func TestPulseCancel(t *testing.T) {
+	s, mem := testServer(t, buttonPin(10000, pb.Group_reject))
+	ctx := context.Background()
+
+	h, err := s.TriggerDigitalPin(ctx, &pb.TriggerDigitalPinRequest{})
+	gobottest.Assert(t, err, nil)
+	waitValue(t, mem, 0, 1, time.Second)
+
+	_, err = s.CancelPulse(ctx, h)
+	gobottest.Assert(t, err, nil)
+	waitValue(t, mem, 0, 0, 100*time.Millisecond)
+
+	_, err = s.CancelPulse(ctx, h)
+	gobottest.Assert(t, status.Code(err), codes.NotFound)
+
+	// the pin is free again
+	_, err = s.TriggerDigitalPin(ctx, &pb.TriggerDigitalPinRequest{})
+	gobottest.Assert(t, err, nil)
+}
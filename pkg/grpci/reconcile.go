@@ -0,0 +1,96 @@
+package grpci
+
+import (
+	"context"
+	"time"
+
+	"github.com/empirefox/firmata/pkg/pb"
+)
+
+// reconcileDaemon queries group pins of inst one by one, and broadcasts the
+// drifts. It stops when inst is closed.
+func (s *Server) reconcileDaemon(ctx context.Context, inst *Instance) {
+	rc := inst.config.Reconcile
+	if rc == nil || rc.EveryMs == 0 {
+		return
+	}
+
+	pins := s.groupPinsOf(inst.index)
+	if len(pins) == 0 {
+		return
+	}
+
+	timeout := time.Duration(rc.TimeoutMs) * time.Millisecond
+	ticker := time.NewTicker(time.Duration(rc.EveryMs) * time.Millisecond)
+	defer ticker.Stop()
+
+	for i := 0; ; i = (i + 1) % len(pins) {
+		select {
+		case <-ticker.C:
+		case <-inst.firmata.CloseNotify():
+			return
+		case <-ctx.Done():
+			return
+		}
+
+		dx := pins[i]
+		drift, err := inst.firmata.CheckPinState(ctx, dx, timeout)
+		if err != nil {
+			s.log.Debug().Str("type", "reconcile").
+				Str("firmata", inst.config.Name).
+				Uint8("dx", dx).Err(err).Send()
+			continue
+		}
+		if drift == nil {
+			continue
+		}
+
+		var reapplied bool
+		if rc.Reapply {
+			err = inst.firmata.ApplyPinState(ctx, drift.Expected)
+			if err != nil {
+				s.log.Err(err).Str("firmata", inst.config.Name).
+					Uint8("dx", dx).Msg("reapply drifted pin")
+			}
+			reapplied = err == nil
+		}
+
+		s.log.Warn().Str("type", "reconcile").
+			Str("firmata", inst.config.Name).
+			Uint8("dx", dx).
+			Uint8("expectedMode", drift.Expected.Mode).
+			Uint32("expectedState", drift.Expected.State).
+			Uint8("actualMode", drift.Actual.Mode).
+			Uint32("actualState", drift.Actual.State).
+			Bool("reapplied", reapplied).
+			Msg("pin drifted")
+
+		s.broadcastServerMessage(&pb.ServerMessage{
+			Type: &pb.ServerMessage_PinDrift_{
+				PinDrift: &pb.ServerMessage_PinDrift{
+					Firmata:       inst.index,
+					Pin:           uint32(dx),
+					ExpectedMode:  pb.Mode(drift.Expected.Mode),
+					ExpectedState: drift.Expected.State,
+					ActualMode:    pb.Mode(drift.Actual.Mode),
+					ActualState:   drift.Actual.State,
+					Reapplied:     reapplied,
+				},
+			},
+		})
+	}
+}
+
+// groupPinsOf returns dx of group pins on firmata idx, must be called after
+// OnConnected.
+func (s *Server) groupPinsOf(idx uint32) []byte {
+	var pins []byte
+	for _, g := range s.Config.Groups {
+		for _, p := range g.Pins {
+			if p.FirmataIndex == idx {
+				pins = append(pins, byte(p.GetDx()))
+			}
+		}
+	}
+	return pins
+}
@@ -0,0 +1,64 @@
+package grpci
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/empirefox/firmata/pkg/pb"
+	"gobot.io/x/gobot/gobottest"
+)
+
+func TestReconcileDaemon(t *testing.T) {
+	s, mem := testServer(t, &pb.Group_Pin{
+		Nick: "out",
+		Mode: pb.Mode_OUTPUT,
+		Id:   &pb.Group_Pin_Dx{Dx: 0},
+	})
+	stream := &messageStream{out: make(chan *pb.ServerMessage, 64)}
+	s.onServerMessageMu.Lock()
+	s.onSeverMessageSenders = append(s.onSeverMessageSenders, stream)
+	s.onServerMessageMu.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	inst := s.instances[0]
+	inst.config.Reconcile = &pb.Firmata_Reconcile{EveryMs: 5, Reapply: true}
+	f := inst.firmata
+	gobottest.Assert(t, f.DigitalWrite(ctx, 0, 1), nil)
+	waitValue(t, mem, 0, 1, time.Second)
+	go s.reconcileDaemon(ctx, inst)
+
+	// matched checks keep the cached value
+	time.Sleep(30 * time.Millisecond)
+	pin, err := f.ReadPin(ctx, 0)
+	gobottest.Assert(t, err, nil)
+	gobottest.Assert(t, pin.Value, uint32(1))
+	select {
+	case out := <-stream.out:
+		t.Fatalf("unexpected message: %v", out)
+	default:
+	}
+
+	// the cache says low while the board is high, again if a response in
+	// flight restored it
+	var drift *pb.ServerMessage_PinDrift
+	for i := 0; i < 20 && drift == nil; i++ {
+		err = f.WaitLoopContext(ctx, func() error {
+			f.Pins[0].Value_l = 0
+			f.Pins[0].State_l = 0
+			return nil
+		})
+		gobottest.Assert(t, err, nil)
+		select {
+		case out := <-stream.out:
+			drift = out.GetPinDrift()
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+	gobottest.Refute(t, drift, (*pb.ServerMessage_PinDrift)(nil))
+	gobottest.Assert(t, drift.ExpectedState, uint32(0))
+	gobottest.Assert(t, drift.ActualState, uint32(1))
+	gobottest.Assert(t, drift.Reapplied, true)
+	waitValue(t, mem, 0, 0, time.Second)
+}
@@ -0,0 +1,288 @@
+package grpci
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/empirefox/firmata/pkg/firmata"
+	"github.com/empirefox/firmata/pkg/pb"
+	"google.golang.org/grpc/peer"
+)
+
+// reporting counts leases of digital port and analog pin reporting of a
+// firmata. It survives reconnecting.
+type reporting struct {
+	mu      sync.Mutex
+	digital [16]int
+	analog  [16]int
+}
+
+// counts_l must be called with mu locked.
+func (r *reporting) counts_l(analog bool) *[16]int {
+	if analog {
+		return &r.analog
+	}
+	return &r.digital
+}
+
+// ReportLease keeps reporting of a digital port or an analog pin enabled until
+// it is released.
+type ReportLease struct {
+	s       *Server
+	firmata uint32
+	analog  bool
+	pin     byte
+	once    sync.Once
+}
+
+// AcquireReport enables reporting of the digital port or the analog pin if it
+// is the first lease. The lease is applied again after every reconnecting.
+func (s *Server) AcquireReport(ctx context.Context, firmataIndex uint32, analog bool, pin byte) (*ReportLease, error) {
+	if s.TotalFirmatas == 0 || firmataIndex >= s.TotalFirmatas {
+		return nil, fmt.Errorf("config.firmatas out of index: %d", firmataIndex)
+	}
+
+	l, first, err := s.countReport(firmataIndex, analog, pin)
+	if err != nil {
+		return nil, err
+	}
+	if first {
+		err := s.applyReport(ctx, firmataIndex, analog, pin)
+		if err != nil {
+			l.Release(ctx)
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// countReport only counts the lease, first is true if reporting should be
+// enabled.
+func (s *Server) countReport(idx uint32, analog bool, pin byte) (l *ReportLease, first bool, err error) {
+	if pin >= 16 {
+		return nil, false, fmt.Errorf("report pin/port out of index: %d", pin)
+	}
+
+	r := s.reportings[idx]
+	r.mu.Lock()
+	counts := r.counts_l(analog)
+	counts[pin]++
+	first = counts[pin] == 1
+	r.mu.Unlock()
+
+	l = &ReportLease{
+		s:       s,
+		firmata: idx,
+		analog:  analog,
+		pin:     pin,
+	}
+	return
+}
+
+// Release disables reporting if it is the last lease.
+func (l *ReportLease) Release(ctx context.Context) (err error) {
+	l.once.Do(func() {
+		r := l.s.reportings[l.firmata]
+		r.mu.Lock()
+		counts := r.counts_l(l.analog)
+		counts[l.pin]--
+		last := counts[l.pin] == 0
+		r.mu.Unlock()
+
+		if last {
+			err = l.s.applyReport(ctx, l.firmata, l.analog, l.pin)
+		}
+	})
+	return
+}
+
+// applyReport writes reporting of the port or pin by its count read in the
+// loop, so concurrent acquire and release end with the latest count. It
+// ignores disconnected firmata, reporting is applied by applyReports_l after
+// connected.
+func (s *Server) applyReport(ctx context.Context, idx uint32, analog bool, pin byte) error {
+	s.instanceMu.Lock()
+	inst := s.instances[idx]
+	s.instanceMu.Unlock()
+	if inst == nil {
+		return nil
+	}
+
+	r := s.reportings[idx]
+	err := inst.firmata.WaitLoopContext(ctx, func() error {
+		r.mu.Lock()
+		enable := r.counts_l(analog)[pin] > 0
+		r.mu.Unlock()
+		if analog {
+			return inst.firmata.ReportAnalog_l(pin, enable)
+		}
+		return inst.firmata.ReportDigital_l(pin, enable)
+	})
+	if err == firmata.ErrClosed {
+		return nil
+	}
+	return err
+}
+
+// applyReports_l enables all leased reporting, must be called after the
+// instance is added.
+func (s *Server) applyReports_l(f *firmata.Firmata, idx uint32) error {
+	r := s.reportings[idx]
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, n := range r.digital {
+		if n > 0 {
+			err := f.ReportDigital_l(byte(i), true)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for i, n := range r.analog {
+		if n > 0 {
+			err := f.ReportAnalog_l(byte(i), true)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// apiReportTTL is how long a lease of ReportDigital/ReportAnalog lives after
+// the last enabling.
+var apiReportTTL = time.Minute
+
+type reportKey struct {
+	firmata uint32
+	analog  bool
+	pin     byte
+	// client id or address of the api client
+	client string
+}
+
+// apiLease is reserved before acquiring, lease is nil until acquired.
+type apiLease struct {
+	lease *ReportLease
+	timer *time.Timer
+}
+
+// apiReport replaces the board-wide ReportDigital/ReportAnalog, which now
+// only holds or releases one lease of the api client, so clients do not
+// disable reporting of each other. leasesMu is never held while waiting the
+// loop, OnConnected takes it in the loop.
+func (s *Server) apiReport(ctx context.Context, idx uint32, analog bool, pin byte, client string, enable bool) error {
+	k := reportKey{firmata: idx, analog: analog, pin: pin, client: client}
+	if k.client == "" {
+		if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+			k.client = p.Addr.String()
+		}
+	}
+
+	s.leasesMu.Lock()
+	e, ok := s.apiLeases[k]
+	if !enable {
+		if ok {
+			delete(s.apiLeases, k)
+			if e.timer != nil {
+				e.timer.Stop()
+			}
+		}
+		s.leasesMu.Unlock()
+		if !ok || e.lease == nil {
+			// the acquiring one releases it
+			return nil
+		}
+		return e.lease.Release(ctx)
+	}
+	if ok {
+		if e.timer != nil {
+			e.timer.Reset(apiReportTTL)
+		}
+		s.leasesMu.Unlock()
+		return nil
+	}
+	e = new(apiLease)
+	s.apiLeases[k] = e
+	s.leasesMu.Unlock()
+
+	l, err := s.AcquireReport(ctx, idx, analog, pin)
+
+	s.leasesMu.Lock()
+	if s.apiLeases[k] != e {
+		// disabled while acquiring
+		s.leasesMu.Unlock()
+		if err == nil {
+			l.Release(ctx)
+		}
+		return err
+	}
+	if err != nil {
+		delete(s.apiLeases, k)
+		s.leasesMu.Unlock()
+		return err
+	}
+	e.lease = l
+	e.timer = time.AfterFunc(apiReportTTL, func() { s.expireApiLease(k, e) })
+	s.leasesMu.Unlock()
+	return nil
+}
+
+// expireApiLease releases e if it is not renewed or disabled.
+func (s *Server) expireApiLease(k reportKey, e *apiLease) {
+	s.leasesMu.Lock()
+	if s.apiLeases[k] != e {
+		s.leasesMu.Unlock()
+		return
+	}
+	delete(s.apiLeases, k)
+	s.leasesMu.Unlock()
+
+	err := e.lease.Release(context.Background())
+	if err != nil {
+		s.log.Err(err).Uint32("firmata", k.firmata).Str("client", k.client).
+			Msg("expire report lease")
+	}
+}
+
+// holdGroupReports_l counts leases of input group pins on firmata idx once,
+// must be called after OnConnected resolved dx of group pins.
+func (s *Server) holdGroupReports_l(f *firmata.Firmata, idx uint32) {
+	s.leasesMu.Lock()
+	defer s.leasesMu.Unlock()
+	for _, g := range s.Config.Groups {
+		for _, p := range g.Pins {
+			if p.FirmataIndex != idx {
+				continue
+			}
+			if _, ok := s.groupLeases[p]; ok {
+				continue
+			}
+
+			dx := byte(p.GetDx())
+			if dx >= f.TotalPins {
+				continue
+			}
+			var l *ReportLease
+			var err error
+			switch p.Type.(type) {
+			case *pb.Group_Pin_DigitalReader:
+				l, _, err = s.countReport(idx, false, dx/8)
+			case *pb.Group_Pin_NumberReader:
+				if pin := f.Pins[dx]; pin.IsAnalog() {
+					l, _, err = s.countReport(idx, true, pin.Ax)
+				}
+			}
+			if err != nil {
+				s.log.Err(err).Str("firmata", f.Config.Data.(*FirmataData).PbConfig.Name).
+					Str("pin", p.Nick).Msg("report group pin")
+				continue
+			}
+			if l != nil {
+				s.groupLeases[p] = l
+			}
+		}
+	}
+}
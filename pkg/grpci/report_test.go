@@ -0,0 +1,160 @@
+package grpci
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/empirefox/firmata/pkg/firmata"
+	"gobot.io/x/gobot/gobottest"
+	"google.golang.org/grpc/peer"
+)
+
+func reportCount(s *Server, analog bool, pin byte) int {
+	r := s.reportings[0]
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.counts_l(analog)[pin]
+}
+
+func TestReportLeaseCount(t *testing.T) {
+	s, _ := testServer(t)
+	ctx := context.Background()
+
+	l1, err := s.AcquireReport(ctx, 0, false, 0)
+	gobottest.Assert(t, err, nil)
+	l2, err := s.AcquireReport(ctx, 0, false, 0)
+	gobottest.Assert(t, err, nil)
+	gobottest.Assert(t, reportCount(s, false, 0), 2)
+
+	gobottest.Assert(t, l1.Release(ctx), nil)
+	// released only once
+	gobottest.Assert(t, l1.Release(ctx), nil)
+	gobottest.Assert(t, reportCount(s, false, 0), 1)
+	gobottest.Assert(t, l2.Release(ctx), nil)
+	gobottest.Assert(t, reportCount(s, false, 0), 0)
+
+	_, err = s.AcquireReport(ctx, 0, false, 16)
+	gobottest.Refute(t, err, nil)
+	_, _, err = s.countReport(0, true, 127)
+	gobottest.Refute(t, err, nil)
+	_, err = s.AcquireReport(ctx, 1, false, 0)
+	gobottest.Refute(t, err, nil)
+}
+
+func TestReportLeaseConcurrent(t *testing.T) {
+	s, mem := testServer(t)
+	ctx := context.Background()
+
+	analog := make(chan uint32, 16)
+	f := s.instances[0].firmata
+	f.WaitLoopContext(ctx, func() error {
+		f.Config.OnAnalogMessage = func(f *firmata.Firmata, pin *firmata.Pin) {
+			select {
+			case analog <- pin.Value_l:
+			default:
+			}
+		}
+		return nil
+	})
+
+	held, err := s.AcquireReport(ctx, 0, true, 1)
+	gobottest.Assert(t, err, nil)
+	defer held.Release(ctx)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			l, err := s.AcquireReport(ctx, 0, true, 1)
+			if err == nil {
+				l.Release(ctx)
+			}
+		}()
+	}
+	wg.Wait()
+	gobottest.Assert(t, reportCount(s, true, 1), 1)
+
+	// reporting is still enabled by the held lease
+	mem.SetInput(3, 300)
+	deadline := time.After(time.Second)
+	for {
+		select {
+		case v := <-analog:
+			if v == 300 {
+				return
+			}
+		case <-deadline:
+			t.Fatal("reporting disabled while a lease is held")
+		}
+	}
+}
+
+func TestReportApiPeers(t *testing.T) {
+	s, _ := testServer(t)
+	a := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{Port: 1}})
+	b := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{Port: 2}})
+
+	gobottest.Assert(t, s.apiReport(a, 0, false, 0, "", true), nil)
+	gobottest.Assert(t, s.apiReport(a, 0, false, 0, "", true), nil)
+	gobottest.Assert(t, s.apiReport(b, 0, false, 0, "", true), nil)
+	gobottest.Assert(t, reportCount(s, false, 0), 2)
+
+	// b disables its own lease only
+	gobottest.Assert(t, s.apiReport(b, 0, false, 0, "", false), nil)
+	gobottest.Assert(t, s.apiReport(b, 0, false, 0, "", false), nil)
+	gobottest.Assert(t, reportCount(s, false, 0), 1)
+	gobottest.Assert(t, s.apiReport(a, 0, false, 0, "", false), nil)
+	gobottest.Assert(t, reportCount(s, false, 0), 0)
+}
+
+func TestReportApiExpire(t *testing.T) {
+	ttl := apiReportTTL
+	apiReportTTL = 50 * time.Millisecond
+	defer func() { apiReportTTL = ttl }()
+
+	s, _ := testServer(t)
+	ctx := context.Background()
+	gobottest.Assert(t, s.apiReport(ctx, 0, true, 1, "a", true), nil)
+	gobottest.Assert(t, s.apiReport(ctx, 0, true, 1, "b", true), nil)
+	gobottest.Assert(t, reportCount(s, true, 1), 2)
+
+	// a renews its lease, b does not
+	time.Sleep(30 * time.Millisecond)
+	gobottest.Assert(t, s.apiReport(ctx, 0, true, 1, "a", true), nil)
+	time.Sleep(30 * time.Millisecond)
+	gobottest.Assert(t, reportCount(s, true, 1), 1)
+	time.Sleep(60 * time.Millisecond)
+	gobottest.Assert(t, reportCount(s, true, 1), 0)
+}
+
+func TestReportApiUnlocked(t *testing.T) {
+	s, _ := testServer(t)
+	f := s.instances[0].firmata
+	ctx := context.Background()
+
+	// the loop is busy like OnConnected
+	block := make(chan struct{})
+	f.Loop(func() { <-block })
+	errc := make(chan error, 1)
+	go func() { errc <- s.apiReport(ctx, 0, false, 0, "a", true) }()
+
+	locked := make(chan struct{})
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		s.leasesMu.Lock()
+		s.leasesMu.Unlock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-time.After(time.Second):
+		t.Fatal("leasesMu held while waiting the loop")
+	}
+	close(block)
+	gobottest.Assert(t, <-errc, nil)
+	gobottest.Assert(t, reportCount(s, false, 0), 1)
+}
@@ -2,14 +2,21 @@ package grpci
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"math/rand"
+	"path/filepath"
 	"sync"
 	"time"
 
 	"github.com/empirefox/firmata/pkg/dial"
 	"github.com/empirefox/firmata/pkg/firmata"
+	"github.com/empirefox/firmata/pkg/firmata/device"
 	"github.com/empirefox/firmata/pkg/pb"
 	"github.com/rs/zerolog"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/emptypb"
 	"google.golang.org/protobuf/types/known/wrapperspb"
 )
@@ -42,9 +49,50 @@ type Server struct {
 	instances       []*Instance
 	instanceBuilds  []bool
 	instanceTmpDown []bool
+	// tty opened by the instance, to close it when unplugged
+	instanceDevices []string
+	// wake the retry sleep of connectFirmata
+	instanceWake []chan struct{}
+	connStates   []connState
+	retryRand    *rand.Rand
+
+	// ctx is done when the server shuts down, it ends OnServerMessage streams
+	ctx context.Context
 
 	onServerMessageMu     sync.Mutex
 	onSeverMessageSenders []pb.Transport_OnServerMessageServer
+
+	// switch states and alarms broadcast in order by stateDaemon
+	stateMu    sync.Mutex
+	stateQueue []*pb.ServerMessage
+	stateWake  chan struct{}
+
+	handshakeCache firmata.HandshakeCache
+
+	reportings  []*reporting
+	leasesMu    sync.Mutex
+	apiLeases   map[reportKey]*apiLease
+	groupLeases map[*pb.Group_Pin]*ReportLease
+
+	proxies []*proxy
+
+	pulseMu    sync.Mutex
+	pulseSeq   uint64
+	pulseByPin map[*pb.Group_Pin]*pulse
+	pulseById  map[uint64]*pulse
+
+	detectMu sync.Mutex
+	detects  map[*pb.Group_Pin]*switchDetect
+
+	alarmMu sync.Mutex
+	// ordered by group and gpin
+	alarms []*alarm
+}
+
+// Options are optional features of Server.
+type Options struct {
+	// HandshakeCache is nil if disabled.
+	HandshakeCache firmata.HandshakeCache
 }
 
 func NewServer(ctx context.Context,
@@ -52,13 +100,15 @@ func NewServer(ctx context.Context,
 	apiVersion *pb.Version_Peer,
 	boards []*pb.Board,
 	integration *pb.Integration,
-	config *pb.Config) *Server {
+	config *pb.Config,
+	opts Options) *Server {
 	boardById := make(map[string]*pb.Board, len(boards))
 	for _, b := range boards {
 		boardById[b.Id] = b
 	}
 	totalFirmatas := uint32(len(integration.GetFirmatas()))
 	s := &Server{
+		ctx:        ctx,
 		log:        log,
 		ApiVersion: apiVersion,
 
@@ -74,6 +124,43 @@ func NewServer(ctx context.Context,
 		instances:       make([]*Instance, totalFirmatas),
 		instanceBuilds:  make([]bool, totalFirmatas),
 		instanceTmpDown: make([]bool, totalFirmatas),
+		instanceDevices: make([]string, totalFirmatas),
+		instanceWake:    make([]chan struct{}, totalFirmatas),
+		connStates:      make([]connState, totalFirmatas),
+		retryRand:       rand.New(rand.NewSource(time.Now().UnixNano())),
+
+		stateWake: make(chan struct{}, 1),
+
+		handshakeCache: opts.HandshakeCache,
+
+		reportings:  make([]*reporting, totalFirmatas),
+		apiLeases:   make(map[reportKey]*apiLease),
+		groupLeases: make(map[*pb.Group_Pin]*ReportLease),
+
+		proxies: make([]*proxy, totalFirmatas),
+
+		pulseByPin: make(map[*pb.Group_Pin]*pulse),
+		pulseById:  make(map[uint64]*pulse),
+
+		detects: newSwitchDetects(config),
+		alarms:  newAlarms(config),
+	}
+	for i := range s.reportings {
+		s.reportings[i] = new(reporting)
+		s.instanceWake[i] = make(chan struct{}, 1)
+	}
+	go s.stateDaemon(ctx)
+	for i, f := range integration.GetFirmatas() {
+		s.proxies[i] = &proxy{clients: make(map[*proxyClient]struct{})}
+		if f.ProxyListen != "" {
+			go s.proxyDaemon(ctx, uint32(i), f.ProxyListen)
+		}
+	}
+	for _, f := range integration.GetFirmatas() {
+		if dial.IsDeviceDial(f.Dial) {
+			go s.hotplugDaemon(ctx)
+			break
+		}
 	}
 	go s.connectFirmatasDaemon(ctx)
 	return s
@@ -100,6 +187,12 @@ func (s *Server) tryConnectFirmatas(ctx context.Context) {
 			s.instances[idx] == nil &&
 			!s.instanceBuilds[idx] &&
 			!s.instanceTmpDown[idx] {
+			// unplugged, the hotplug watcher connects it when plugged
+			_, err := dial.Device(s.Integration.Firmatas[idx].Dial)
+			if errors.Is(err, dial.ErrDeviceAbsent) {
+				continue
+			}
+			s.instanceBuilds[idx] = true
 			go s.connectFirmata(ctx, idx)
 		}
 	}
@@ -163,6 +256,7 @@ func (s *Server) connectFirmata(ctx context.Context, idx uint32) (err error) {
 							dx = f.DxByName[p.GetGpioName()]
 							p.Id = &pb.Group_Pin_Dx{Dx: uint32(dx)}
 						}
+						f.Pins[dx].Filter = firmata.NewAnalogFilter(p.Filter)
 						err := f.SetPinMode_l(dx, byte(p.Mode))
 						if err != nil {
 							s.log.Err(err).Str("firmata", pbConfig.Name).Send()
@@ -214,13 +308,27 @@ func (s *Server) connectFirmata(ctx context.Context, idx uint32) (err error) {
 				}
 			}
 
+			err := s.uploadFailsafe_l(f, idx)
+			if err != nil {
+				s.log.Err(err).Str("firmata", pbConfig.Name).Msg("upload failsafe")
+			}
+
 			s.instanceMu.Lock()
-			if s.instances[data.Index] != nil {
+			// OnConnected is called again after HardReset
+			if s.instances[data.Index] != nil && s.instances[data.Index] != inst {
 				s.log.Error().Msg("bugs build firmata instance!!!")
 			}
 			s.instances[data.Index] = inst
+			s.connStates[data.Index] = connState{lastConnected: time.Now()}
 			s.instanceMu.Unlock()
 
+			s.holdGroupReports_l(f, idx)
+			s.initDetects_l(f, idx)
+			err = s.applyReports_l(f, idx)
+			if err != nil {
+				s.log.Err(err).Str("firmata", pbConfig.Name).Send()
+			}
+
 			s.log.Debug().Str("firmata", pbConfig.Name).
 				Msg("added to instannce")
 
@@ -233,12 +341,19 @@ func (s *Server) connectFirmata(ctx context.Context, idx uint32) (err error) {
 		},
 		OnAnalogMessage: func(f *firmata.Firmata, pin *firmata.Pin) {
 			data := f.Config.Data.(*FirmataData)
+			s.proxyReportMessage(data.Index, true, pin.Ax,
+				device.AnalogMessage(pin.Ax, pin.Value_l))
+			if !pin.ApplyFilter_l(time.Now()) {
+				return
+			}
+			s.updateNumberAlarms_l(data.Index, pin)
 			out := &pb.ServerMessage{
 				Type: &pb.ServerMessage_Analog_{
 					Analog: &pb.ServerMessage_Analog{
 						Firmata: data.Index,
 						Pin:     uint32(pin.Dx),
-						Value:   pin.Value_l,
+						Value:   pin.Filtered_l,
+						Raw:     pin.Value_l,
 					},
 				},
 			}
@@ -246,6 +361,10 @@ func (s *Server) connectFirmata(ctx context.Context, idx uint32) (err error) {
 		},
 		OnDigitalMessage: func(f *firmata.Firmata, port byte, pins byte, values byte) {
 			data := f.Config.Data.(*FirmataData)
+			s.proxyReportMessage(data.Index, false, port,
+				device.DigitalMessage(port, values))
+			s.updateDetects_l(f, data.Index, port)
+			s.updateDigitalAlarms_l(f, data.Index, port)
 			out := &pb.ServerMessage{
 				Type: &pb.ServerMessage_Digital_{
 					Digital: &pb.ServerMessage_Digital{
@@ -263,25 +382,51 @@ func (s *Server) connectFirmata(ctx context.Context, idx uint32) (err error) {
 		},
 		OnI2cReply: func(f *firmata.Firmata, reply *firmata.I2cReply) {
 			// other business here
+			s.proxyBroadcast(idx, device.I2cReply(reply))
 		},
 		OnStringData: func(f *firmata.Firmata, b []byte) {
 			data := f.Config.Data.(*FirmataData)
 			s.log.Debug().Str("firmata", data.PbConfig.Name).
 				Str("OnStringData", string(b)).Send()
+			s.proxyBroadcast(idx, device.StringReply(b))
 		},
 		OnSysexResponse: func(f *firmata.Firmata, buf []byte) {
-			// ignore by now
+			s.proxyBroadcast(idx, device.Sysex(buf[0], buf[1:]))
 		},
 		Data: &FirmataData{
 			Index:    idx,
 			PbConfig: pbConfig,
 		},
+		HandshakeCache: s.handshakeCache,
+		CacheId:        handshakeCacheId(pbConfig),
+		Identity:       pbConfig.Identity,
+		OnHandshakeCacheError: func(f *firmata.Firmata, key string, err error) {
+			s.log.Err(err).Str("firmata", pbConfig.Name).
+				Str("key", key).Msg("handshake cache")
+		},
+		OnHandshakeCacheInvalidated: func(f *firmata.Firmata, key string) {
+			s.log.Warn().Str("firmata", pbConfig.Name).
+				Str("key", key).Msg("handshake cache invalidated")
+			out := &pb.ServerMessage{
+				Type: &pb.ServerMessage_HandshakeCacheInvalidated_{
+					HandshakeCacheInvalidated: &pb.ServerMessage_HandshakeCacheInvalidated{
+						Firmata: idx,
+						Key:     key,
+					},
+				},
+			}
+			go s.broadcastServerMessage(out)
+		},
 	}
 
 	for {
 		// dialing
 		s.log.Debug().Str("type", "dialing").Str("firmata", pbConfig.Name).Send()
-		s.broadcastConnection(idx, pb.ServerMessage_Connecting_dialing)
+		s.instanceMu.Lock()
+		s.connStates[idx].attempt++
+		s.connStates[idx].nextRetry = time.Time{}
+		s.instanceMu.Unlock()
+		s.broadcastConnection(idx, pb.ServerMessage_Connecting_dialing, nil)
 		c, err := dial.Dial(ctx, pbConfig.Dial)
 		if err != nil {
 			s.log.Debug().Str("type", "dialing").
@@ -290,20 +435,37 @@ func (s *Server) connectFirmata(ctx context.Context, idx uint32) (err error) {
 				Send()
 			if e, ok := err.(temporary); ok && e.Temporary() {
 				// dialTemporaryFail
-				s.broadcastConnection(idx, pb.ServerMessage_Connecting_dialTemporaryFail)
-				if s.connectRetry(ctx, idx) {
+				if s.connectRetry(ctx, idx, pb.ServerMessage_Connecting_dialTemporaryFail, err) {
 					continue
 				}
 
 				// disconnected
-				s.broadcastConnection(idx, pb.ServerMessage_Connecting_disconnected)
+				s.broadcastConnection(idx, pb.ServerMessage_Connecting_disconnected, nil)
 				return err
 			}
 			// dialFatalError
-			s.broadcastConnection(idx, pb.ServerMessage_Connecting_dialFatalError)
+			s.setLastError(idx, err)
+			s.broadcastConnection(idx, pb.ServerMessage_Connecting_dialFatalError, err)
 			return err
 		}
 
+		dev, _ := dial.Device(pbConfig.Dial)
+		s.instanceMu.Lock()
+		s.instanceDevices[idx] = dev
+		s.instanceMu.Unlock()
+
+		if pbConfig.CaptureDir != "" {
+			c = s.capture(c, pbConfig)
+		}
+
+		// safe outputs before the handshake, the board may have reset
+		if b := s.failsafeMessages(idx); len(b) != 0 {
+			_, err = c.Write(b)
+			if err != nil {
+				s.log.Err(err).Str("firmata", pbConfig.Name).Msg("write failsafe")
+			}
+		}
+
 		inst = &Instance{
 			log:     s.log,
 			index:   idx,
@@ -319,34 +481,60 @@ func (s *Server) connectFirmata(ctx context.Context, idx uint32) (err error) {
 				Str("err", err.Error()).
 				Err(inst.firmata.ClosedError_l).
 				Send()
-			s.broadcastConnection(idx, pb.ServerMessage_Connecting_handshakeError)
-			if s.connectRetry(ctx, idx) {
+			if s.connectRetry(ctx, idx, pb.ServerMessage_Connecting_handshakeError, err) {
 				continue
 			}
 
 			// disconnected
-			s.broadcastConnection(idx, pb.ServerMessage_Connecting_disconnected)
+			s.broadcastConnection(idx, pb.ServerMessage_Connecting_disconnected, nil)
 			return err
 		}
 
 		// ok
 		go s.waitFirmataClosed(inst)
+		go s.reconcileDaemon(ctx, inst)
+		go s.failsafeWatchdogDaemon(ctx, inst)
 		return nil
 	}
 }
 
-func (s *Server) connectRetry(ctx context.Context, idx uint32) (retry bool) {
+// capture records the connection to a new file in CaptureDir, c is returned
+// as is if the file cannot be created.
+func (s *Server) capture(c io.ReadWriteCloser, pbConfig *pb.Firmata) io.ReadWriteCloser {
+	name := fmt.Sprintf("%s-%s.fcap", pbConfig.Name, time.Now().Format("20060102T150405.000"))
+	path := filepath.Join(pbConfig.CaptureDir, name)
+	cc, err := dial.NewCaptureFile(c, path)
+	if err != nil {
+		s.log.Warn().Str("firmata", pbConfig.Name).Err(err).Msg("capture disabled")
+		return c
+	}
+	s.log.Debug().Str("firmata", pbConfig.Name).Str("capture", path).Send()
+	return cc
+}
+
+// connectRetry broadcasts the failed status with the next retry time, then
+// sleeps the backoff delay.
+func (s *Server) connectRetry(ctx context.Context, idx uint32,
+	status pb.ServerMessage_Connecting_Status, reason error) (retry bool) {
+	var delay time.Duration
 	s.instanceMu.Lock()
+	state := &s.connStates[idx]
+	state.lastError = reason.Error()
 	tmpDown := s.instanceTmpDown[idx]
+	if !tmpDown {
+		delay = retryDelay(s.Integration.Firmatas[idx], state.attempt, s.retryRand)
+		state.nextRetry = time.Now().Add(delay)
+	}
 	s.instanceMu.Unlock()
+
+	s.broadcastConnection(idx, status, reason)
 	if tmpDown {
 		return false
 	}
 
-	sleep := s.Integration.Firmatas[idx].ConnectRetrySecond
-
 	select {
-	case <-time.After(time.Second * time.Duration(sleep)):
+	case <-time.After(delay):
+	case <-s.instanceWake[idx]:
 	case <-ctx.Done():
 		return false
 	}
@@ -357,26 +545,69 @@ func (s *Server) connectRetry(ctx context.Context, idx uint32) (retry bool) {
 	return !tmpDown
 }
 
+func (s *Server) setLastError(idx uint32, err error) {
+	s.instanceMu.Lock()
+	s.connStates[idx].lastError = err.Error()
+	s.connStates[idx].nextRetry = time.Time{}
+	s.instanceMu.Unlock()
+}
+
 func (s *Server) disconnectFirmata(ctx context.Context, idx uint32) {
 	s.instanceMu.Lock()
 	inst := s.instances[idx]
 	s.instanceMu.Unlock()
 	if inst != nil {
-		inst.firmata.Close()
+		s.closeFirmata(inst)
 	}
 }
 
+// handshakeCacheId identifies the board by the expected serial, or by the
+// firmata it is wired to.
+func handshakeCacheId(pbConfig *pb.Firmata) string {
+	if serial := pbConfig.Identity.GetSerial(); serial != "" {
+		return "serial/" + serial
+	}
+	return "firmata/" + pbConfig.Name
+}
+
 func (s *Server) waitFirmataClosed(inst *Instance) {
 	<-inst.firmata.CloseNotify()
 	s.instanceMu.Lock()
 	s.instances[inst.index] = nil
+	s.instanceDevices[inst.index] = ""
+	if inst.firmata.ClosedError_l != nil {
+		s.connStates[inst.index].lastError = inst.firmata.ClosedError_l.Error()
+	}
 	s.instanceMu.Unlock()
 	s.log.Debug().Str("firmata", inst.config.Name).
 		Msg("removed from instannce")
-	s.broadcastConnection(inst.index, pb.ServerMessage_Connecting_disconnected)
+	s.broadcastConnection(inst.index, pb.ServerMessage_Connecting_disconnected, nil)
+	s.clearDetects(inst.index)
+	s.clearAlarms(inst.index)
 }
 
-func (s *Server) loopFromFirmata(firmataIndex uint32, fn func(*Instance) error) error {
+// CheckHealth returns an error if the loop of a connected firmata does not
+// run, the process is stuck then even though it is alive.
+func (s *Server) CheckHealth(ctx context.Context) error {
+	s.instanceMu.Lock()
+	var insts []*Instance
+	for _, inst := range s.instances {
+		if inst != nil {
+			insts = append(insts, inst)
+		}
+	}
+	s.instanceMu.Unlock()
+
+	for _, inst := range insts {
+		err := inst.firmata.WaitLoopContext(ctx, func() error { return nil })
+		if err != nil && err != firmata.ErrClosed {
+			return fmt.Errorf("firmata %s loop: %v", inst.config.Name, err)
+		}
+	}
+	return nil
+}
+
+func (s *Server) loopFromFirmata(ctx context.Context, firmataIndex uint32, fn func(*Instance) error) error {
 	if s.TotalFirmatas == 0 || firmataIndex >= s.TotalFirmatas {
 		return fmt.Errorf("config.firmatas out of index: %d", firmataIndex)
 	}
@@ -388,20 +619,20 @@ func (s *Server) loopFromFirmata(firmataIndex uint32, fn func(*Instance) error)
 		return fmt.Errorf("firmata disconnected")
 	}
 
-	return inst.firmata.WaitLoop(func() error {
+	return inst.firmata.WaitLoopContext(ctx, func() error {
 		return fn(inst)
 	})
 }
 
-func (s *Server) loopFromGroup(group uint32, gpin uint32, pre, fn func(*Instance, *pb.Group_Pin) error) (err error) {
+func (s *Server) groupPin(group uint32, gpin uint32) (*Instance, *pb.Group_Pin, error) {
 	if s.TotalGroups == 0 || group >= s.TotalGroups {
-		return fmt.Errorf("config.groups out of index: %d", group)
+		return nil, nil, status.Errorf(codes.InvalidArgument, "config.groups out of index: %d", group)
 	}
 
 	g := s.Config.Groups[group]
 	gpinSize := uint32(len(g.Pins))
 	if gpinSize == 0 || gpin >= gpinSize {
-		return fmt.Errorf("config.groups[%d].pins out of index: %d", group, gpin)
+		return nil, nil, status.Errorf(codes.InvalidArgument, "config.groups[%d].pins out of index: %d", group, gpin)
 	}
 	gp := g.Pins[gpin]
 
@@ -409,19 +640,20 @@ func (s *Server) loopFromGroup(group uint32, gpin uint32, pre, fn func(*Instance
 	inst := s.instances[gp.FirmataIndex]
 	s.instanceMu.Unlock()
 	if inst == nil {
-		return fmt.Errorf("firmata disconnected")
+		return nil, nil, status.Errorf(codes.FailedPrecondition, "firmata disconnected")
 	}
+	return inst, gp, nil
+}
 
-	if pre != nil {
-		err = pre(inst, gp)
-		if err != nil {
-			return
-		}
+// digitalWrite writes the group pin, confirmed by the board if gp.Confirm is set.
+func (s *Server) digitalWrite(ctx context.Context, inst *Instance, gp *pb.Group_Pin, value byte) error {
+	dx := byte(gp.GetDx())
+	s.log.Debug().Str("firmata", inst.config.Name).
+		Uint8("dx", dx).Uint8("v", value).Send()
+	if opts := firmata.NewConfirmOptions(gp.Confirm); opts != nil {
+		return inst.firmata.ConfirmedDigitalWrite(ctx, dx, value, *opts)
 	}
-	err = inst.firmata.WaitLoop(func() error {
-		return fn(inst, gp)
-	})
-	return err
+	return inst.firmata.DigitalWrite(ctx, dx, value)
 }
 
 func (s *Server) sendInstancesTo(sender pb.Transport_OnServerMessageServer) (err error) {
@@ -430,12 +662,14 @@ func (s *Server) sendInstancesTo(sender pb.Transport_OnServerMessageServer) (err
 	for i, inst := range s.instances {
 		var out *pb.ServerMessage
 		if inst == nil {
+			connecting := &pb.ServerMessage_Connecting{
+				Firmata: uint32(i),
+				Status:  pb.ServerMessage_Connecting_disconnected,
+			}
+			s.connStates[i].toPbConnecting(connecting)
 			out = &pb.ServerMessage{
 				Type: &pb.ServerMessage_Connecting_{
-					Connecting: &pb.ServerMessage_Connecting{
-						Firmata: uint32(i),
-						Status:  pb.ServerMessage_Connecting_disconnected,
-					},
+					Connecting: connecting,
 				},
 			}
 		} else {
@@ -458,13 +692,20 @@ func (s *Server) sendInstancesTo(sender pb.Transport_OnServerMessageServer) (err
 	return
 }
 
-func (s *Server) broadcastConnection(idx uint32, status pb.ServerMessage_Connecting_Status) {
+func (s *Server) broadcastConnection(idx uint32, status pb.ServerMessage_Connecting_Status, reason error) {
+	connecting := &pb.ServerMessage_Connecting{
+		Firmata: idx,
+		Status:  status,
+	}
+	if reason != nil {
+		connecting.Reason = reason.Error()
+	}
+	s.instanceMu.Lock()
+	s.connStates[idx].toPbConnecting(connecting)
+	s.instanceMu.Unlock()
 	out := &pb.ServerMessage{
 		Type: &pb.ServerMessage_Connecting_{
-			Connecting: &pb.ServerMessage_Connecting{
-				Firmata: idx,
-				Status:  status,
-			},
+			Connecting: connecting,
 		},
 	}
 	s.broadcastServerMessage(out)
@@ -478,6 +719,43 @@ func (s *Server) broadcastServerMessage(out *pb.ServerMessage) {
 	}
 }
 
+// broadcastStates queues the state changes, they are broadcast in the order
+// of queueing without blocking the caller.
+func (s *Server) broadcastStates(outs ...*pb.ServerMessage) {
+	if len(outs) == 0 {
+		return
+	}
+	s.stateMu.Lock()
+	s.stateQueue = append(s.stateQueue, outs...)
+	s.stateMu.Unlock()
+	select {
+	case s.stateWake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Server) stateDaemon(ctx context.Context) {
+	for {
+		select {
+		case <-s.stateWake:
+		case <-ctx.Done():
+			return
+		}
+		for {
+			s.stateMu.Lock()
+			outs := s.stateQueue
+			s.stateQueue = nil
+			s.stateMu.Unlock()
+			if len(outs) == 0 {
+				break
+			}
+			for _, out := range outs {
+				s.broadcastServerMessage(out)
+			}
+		}
+	}
+}
+
 func (s *Server) GetApiVersion(ctx context.Context, in *emptypb.Empty) (*pb.Version_Peer, error) {
 	return s.ApiVersion, nil
 }
@@ -493,6 +771,25 @@ func (s *Server) GetIntegration(ctx context.Context, in *emptypb.Empty) (*pb.Int
 func (s *Server) GetConfig(ctx context.Context, in *emptypb.Empty) (*pb.Config, error) {
 	return s.Config, nil
 }
+func (s *Server) ListUsbSerials(ctx context.Context, in *emptypb.Empty) (*pb.UsbSerialsResponse, error) {
+	all, err := dial.ListUSBSerial()
+	if err != nil {
+		return nil, err
+	}
+	devices := make([]*pb.UsbSerial, len(all))
+	for i, d := range all {
+		devices[i] = &pb.UsbSerial{
+			Device:       d.Device,
+			Vid:          d.Vid,
+			Pid:          d.Pid,
+			Serial:       d.Serial,
+			Manufacturer: d.Manufacturer,
+			Product:      d.Product,
+			BusPath:      d.BusPath,
+		}
+	}
+	return &pb.UsbSerialsResponse{Devices: devices}, nil
+}
 
 func (s *Server) OnServerMessage(in *emptypb.Empty, stream pb.Transport_OnServerMessageServer) error {
 	s.onServerMessageMu.Lock()
@@ -500,7 +797,11 @@ func (s *Server) OnServerMessage(in *emptypb.Empty, stream pb.Transport_OnServer
 	s.onServerMessageMu.Unlock()
 
 	s.sendInstancesTo(stream)
-	<-stream.Context().Done()
+	s.sendSwitchStatesTo(stream)
+	select {
+	case <-stream.Context().Done():
+	case <-s.ctx.Done():
+	}
 
 	s.onServerMessageMu.Lock()
 	for i, sender := range s.onSeverMessageSenders {
@@ -515,137 +816,176 @@ func (s *Server) OnServerMessage(in *emptypb.Empty, stream pb.Transport_OnServer
 	return nil
 }
 
+func (s *Server) ListAlarms(ctx context.Context, in *emptypb.Empty) (*pb.AlarmsResponse, error) {
+	return &pb.AlarmsResponse{Alarms: s.activeAlarms()}, nil
+}
+
 func (s *Server) Connect(ctx context.Context, in *pb.FirmataIndex) (*emptypb.Empty, error) {
+	if in.Firmata >= s.TotalFirmatas {
+		return nil, status.Errorf(codes.InvalidArgument, "config.firmatas out of index: %d", in.Firmata)
+	}
 	s.instanceMu.Lock()
 	s.instanceTmpDown[in.Firmata] = false
 	s.instanceMu.Unlock()
 	return empty, s.tryConnectFirmata(ctx, in.Firmata)
 }
 func (s *Server) Disconnect(ctx context.Context, in *pb.FirmataIndex) (*emptypb.Empty, error) {
+	if in.Firmata >= s.TotalFirmatas {
+		return nil, status.Errorf(codes.InvalidArgument, "config.firmatas out of index: %d", in.Firmata)
+	}
 	s.instanceMu.Lock()
 	s.instanceTmpDown[in.Firmata] = true
 	s.instanceMu.Unlock()
 	s.disconnectFirmata(ctx, in.Firmata)
 	return empty, nil
 }
+func (s *Server) HardReset(ctx context.Context, in *pb.FirmataIndex) (*emptypb.Empty, error) {
+	if in.Firmata >= s.TotalFirmatas {
+		return nil, status.Errorf(codes.InvalidArgument, "config.firmatas out of index: %d", in.Firmata)
+	}
+	s.instanceMu.Lock()
+	inst := s.instances[in.Firmata]
+	s.instanceMu.Unlock()
+	if inst == nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "firmata disconnected")
+	}
+	err := inst.firmata.HardReset(ctx)
+	if err == firmata.ErrNoLineResetter {
+		return nil, status.Errorf(codes.FailedPrecondition, "%v", err)
+	}
+	return empty, err
+}
 func (s *Server) SetPinMode(ctx context.Context, in *pb.SetPinModeRequest) (*emptypb.Empty, error) {
+	if in.Firmata >= s.TotalFirmatas {
+		return nil, status.Errorf(codes.InvalidArgument, "config.firmatas out of index: %d", in.Firmata)
+	}
+
 	s.instanceMu.Lock()
 	inst := s.instances[in.Firmata]
 	s.instanceMu.Unlock()
 	if inst == nil {
-		return nil, fmt.Errorf("firmata disconnected")
+		return nil, status.Errorf(codes.FailedPrecondition, "firmata disconnected")
 	}
 
-	f := inst.firmata
-	err := f.WaitLoop(func() error {
-		return f.SetPinMode_l(byte(in.Dx), byte(in.Mode))
+	var gp *pb.Group_Pin
+	err := inst.firmata.WaitLoopContext(ctx, func() (err error) {
+		if in.Dx >= uint32(inst.firmata.TotalPins) {
+			return status.Errorf(codes.InvalidArgument, "pin out of index: %d", in.Dx)
+		}
+		gp, err = s.checkPinMode_l(inst, byte(in.Dx))
+		return
 	})
+	if err != nil {
+		return nil, err
+	}
+
+	if opts := firmata.NewConfirmOptions(gp.GetConfirm()); opts != nil {
+		err = inst.firmata.ConfirmedSetMode(ctx, byte(in.Dx), byte(in.Mode), *opts)
+		return empty, err
+	}
+	err = inst.firmata.SetMode(ctx, byte(in.Dx), byte(in.Mode))
 	// TODO broadcast?
 	return empty, err
 }
-func (s *Server) TriggerDigitalPin(ctx context.Context, in *pb.TriggerDigitalPinRequest) (*emptypb.Empty, error) {
-	var instance *Instance
-	var f *firmata.Firmata
-	var dx byte
+func (s *Server) TriggerDigitalPin(ctx context.Context, in *pb.TriggerDigitalPinRequest) (*pb.PulseHandle, error) {
+	inst, gp, err := s.groupPin(in.Group, in.Gpin)
+	if err != nil {
+		return nil, err
+	}
+	err = checkTrigger(gp, in)
+	if err != nil {
+		return nil, err
+	}
+
+	var lowLevelTrigger bool
 	var triggerMs uint32
+	var overlap pb.Group_PulseOverlap
+	if btn := gp.GetButton(); btn != nil {
+		lowLevelTrigger = btn.LowLevelTrigger
+		triggerMs = btn.TriggerMs
+		overlap = btn.Overlap
+	} else {
+		swtch := gp.GetSwitch()
+		lowLevelTrigger = swtch.LowLevelTrigger
+		triggerMs = swtch.TriggerMs
+		overlap = swtch.Overlap
+	}
+
 	var values1 byte = 1
 	var values2 byte = 0
-	err := s.loopFromGroup(in.Group, in.Gpin,
-		func(inst *Instance, gp *pb.Group_Pin) error {
-			instance = inst
-			f = inst.firmata
-			dx = byte(gp.GetDx())
-
-			var isButton bool = true
-			var swtch *pb.Group_Switch
-			btn := gp.GetButton()
-			if btn == nil {
-				swtch = gp.GetSwitch()
-				if swtch == nil {
-					return fmt.Errorf("TriggerDigitalPin accepts button/switch type: %t",
-						gp.Type)
-				}
-				isButton = false
-			}
+	if lowLevelTrigger {
+		values1 = 0
+		values2 = 1
+	}
 
-			var lowLevelTrigger bool
-			if isButton {
-				lowLevelTrigger = btn.LowLevelTrigger
-				triggerMs = btn.TriggerMs
-			} else {
-				lowLevelTrigger = swtch.LowLevelTrigger
-				triggerMs = swtch.TriggerMs
-			}
+	if in.Ensure != pb.TriggerDigitalPinRequest_toggle {
+		state, err := s.switchState(gp)
+		if err != nil {
+			return nil, err
+		}
+		if state == pb.ServerMessage_SwitchState_unknown {
+			return nil, status.Errorf(codes.FailedPrecondition,
+				"switch state unknown, group %d pin %d", in.Group, in.Gpin)
+		}
+		if (state == pb.ServerMessage_SwitchState_on) == (in.Ensure == pb.TriggerDigitalPinRequest_on) {
+			// already done
+			return &pb.PulseHandle{}, nil
+		}
+	}
 
-			if lowLevelTrigger {
-				values1 = 0
-				values2 = 1
-			}
+	if in.RealtimeTriggerMs != 0 {
+		triggerMs = in.RealtimeTriggerMs
+	}
 
-			if in.RealtimeTriggerMs != 0 {
-				triggerMs = in.RealtimeTriggerMs
-			} else if triggerMs == 0 {
-				return fmt.Errorf("TriggerDigitalPinRequest.RealtimeTriggerMs is required")
-			}
-			return nil
-		},
-		func(inst *Instance, gp *pb.Group_Pin) error {
-			s.log.Debug().Str("firmata", instance.config.Name).
-				Uint8("dx", dx).Uint8("v", values1).Send()
-			return f.SetDigitalPinValue_l(dx, values1)
-		})
+	p, err := s.startPulse(ctx, in.Group, in.Gpin, inst, gp, values1, values2,
+		time.Duration(triggerMs)*time.Millisecond, overlap)
 	if err != nil {
 		return nil, err
 	}
 
-	data := pb.ServerMessage_Digital{
-		Firmata: instance.index,
-		Port:    uint32(dx / 8),
-		Pins:    1 << (dx % 8),
-		Values:  uint32(values2) << (dx % 8),
-	}
-	out := &pb.ServerMessage{
-		Type: &pb.ServerMessage_Digital_{
-			Digital: &data,
-		},
+	s.pulseMu.Lock()
+	defer s.pulseMu.Unlock()
+	return &pb.PulseHandle{Id: p.id, EndMs: p.endMs()}, nil
+}
+func (s *Server) CancelPulse(ctx context.Context, in *pb.PulseHandle) (*emptypb.Empty, error) {
+	err := s.cancelPulse(in.Id)
+	if err != nil {
+		return nil, err
 	}
-
-	s.broadcastServerMessage(out)
-
-	time.Sleep(time.Duration(triggerMs) * time.Millisecond)
-	err = f.WaitLoop(func() error {
-		s.log.Debug().Str("firmata", instance.config.Name).
-			Uint8("dx", dx).Uint8("v", values2).Send()
-		return f.SetDigitalPinValue_l(dx, values2)
-	})
-
-	data.Values = uint32(values2) << (dx % 8)
-	s.broadcastServerMessage(out)
-
-	return empty, err
+	return empty, nil
 }
 func (s *Server) SetPinValue(ctx context.Context, in *pb.SetPinValueRequest) (*emptypb.Empty, error) {
-	var instance *Instance
-	var dx byte
-	err := s.loopFromGroup(in.Group, in.Gpin, nil, func(inst *Instance, gp *pb.Group_Pin) error {
-		instance = inst
-		dx = byte(gp.GetDx())
-		s.log.Debug().Str("firmata", inst.config.Name).
-			Uint8("dx", dx).Uint32("v", in.Value).Send()
-		return inst.firmata.SetPinValue_l(dx, in.Value)
-	})
+	inst, gp, err := s.groupPin(in.Group, in.Gpin)
+	if err != nil {
+		return nil, err
+	}
+	err = checkPinValue(gp, in.Value)
+	if err != nil {
+		return nil, err
+	}
+	dx := byte(gp.GetDx())
+	s.log.Debug().Str("firmata", inst.config.Name).
+		Uint8("dx", dx).Uint32("v", in.Value).Send()
+
+	var p firmata.PinSnapshot
+	if opts := firmata.NewConfirmOptions(gp.Confirm); opts != nil {
+		p, err = inst.firmata.ConfirmedWritePin(ctx, dx, in.Value, *opts)
+	} else {
+		p, err = inst.firmata.WritePin(ctx, dx, in.Value)
+	}
 	if err != nil {
 		return nil, err
 	}
 
 	var out *pb.ServerMessage
-	if instance.firmata.Pins[dx].IsAnalog() {
+	if p.IsAnalog() {
 		out = &pb.ServerMessage{
 			Type: &pb.ServerMessage_Analog_{
 				Analog: &pb.ServerMessage_Analog{
-					Firmata: instance.index,
+					Firmata: inst.index,
 					Pin:     uint32(dx),
 					Value:   in.Value,
+					Raw:     in.Value,
 				},
 			},
 		}
@@ -653,7 +993,7 @@ func (s *Server) SetPinValue(ctx context.Context, in *pb.SetPinValueRequest) (*e
 		out = &pb.ServerMessage{
 			Type: &pb.ServerMessage_Digital_{
 				Digital: &pb.ServerMessage_Digital{
-					Firmata: instance.index,
+					Firmata: inst.index,
 					Port:    uint32(dx / 8),
 					Pins:    1 << (dx % 8),
 					Values:  in.Value << (dx % 8),
@@ -666,25 +1006,60 @@ func (s *Server) SetPinValue(ctx context.Context, in *pb.SetPinValueRequest) (*e
 	return empty, nil
 }
 func (s *Server) ReportDigital(ctx context.Context, in *pb.ReportDigitalRequest) (*emptypb.Empty, error) {
-	err := s.loopFromFirmata(in.Firmata, func(inst *Instance) error {
-		return inst.firmata.ReportDigital_l(byte(in.Port), in.Enable)
-	})
+	if in.Port >= 16 {
+		return nil, fmt.Errorf("ReportDigital port out of index: %d", in.Port)
+	}
+	err := s.apiReport(ctx, in.Firmata, false, byte(in.Port), in.Client, in.Enable)
 	return empty, err
 }
 func (s *Server) ReportAnalog(ctx context.Context, in *pb.ReportAnalogRequest) (*emptypb.Empty, error) {
-	err := s.loopFromFirmata(in.Firmata, func(inst *Instance) error {
-		return inst.firmata.ReportAnalog_l(byte(in.Pin), in.Enable)
-	})
+	if in.Pin >= 16 {
+		return nil, fmt.Errorf("ReportAnalog pin out of index: %d", in.Pin)
+	}
+	err := s.apiReport(ctx, in.Firmata, true, byte(in.Pin), in.Client, in.Enable)
 	return empty, err
 }
+func (s *Server) Report(in *pb.ReportRequest, stream pb.Transport_ReportServer) error {
+	ctx := stream.Context()
+	var leases []*ReportLease
+	defer func() {
+		for _, l := range leases {
+			l.Release(context.Background())
+		}
+	}()
+
+	for _, port := range in.Ports {
+		if port >= 16 {
+			return fmt.Errorf("Report port out of index: %d", port)
+		}
+		l, err := s.AcquireReport(ctx, in.Firmata, false, byte(port))
+		if err != nil {
+			return err
+		}
+		leases = append(leases, l)
+	}
+	for _, pin := range in.AnalogPins {
+		if pin >= 16 {
+			return fmt.Errorf("Report analog pin out of index: %d", pin)
+		}
+		l, err := s.AcquireReport(ctx, in.Firmata, true, byte(pin))
+		if err != nil {
+			return err
+		}
+		leases = append(leases, l)
+	}
+
+	<-ctx.Done()
+	return nil
+}
 func (s *Server) WriteString(ctx context.Context, in *pb.WriteStringRequest) (*emptypb.Empty, error) {
-	err := s.loopFromFirmata(in.Firmata, func(inst *Instance) error {
+	err := s.loopFromFirmata(ctx, in.Firmata, func(inst *Instance) error {
 		return inst.firmata.StringWrite_l([]byte(in.Data))
 	})
 	return empty, err
 }
 func (s *Server) SetSamplingInterval(ctx context.Context, in *pb.SetSamplingIntervalRequest) (*emptypb.Empty, error) {
-	err := s.loopFromFirmata(in.Firmata, func(inst *Instance) error {
+	err := s.loopFromFirmata(ctx, in.Firmata, func(inst *Instance) error {
 		return inst.firmata.SamplingInterval_l(in.Ms)
 	})
 	return empty, err
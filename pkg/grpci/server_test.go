@@ -0,0 +1,90 @@
+package grpci
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/empirefox/firmata/pkg/firmata"
+	"github.com/empirefox/firmata/pkg/firmata/device"
+	"github.com/empirefox/firmata/pkg/pb"
+	"github.com/rs/zerolog"
+	"gobot.io/x/gobot/gobottest"
+)
+
+// testServer connects firmata 0 to a 4 pins device emulator, pins are the
+// group 0 on firmata 0.
+func testServer(t *testing.T, pins ...*pb.Group_Pin) (*Server, *device.MemoryIO) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	host, board := net.Pipe()
+	mem := device.NewMemoryIO(4)
+	d := device.New(board, &device.Config{
+		Pins: []device.PinConfig{
+			device.DigitalPin(pb.PinName_PA0),
+			device.DigitalPin(pb.PinName_PA1),
+			device.AnalogPin(pb.PinName_PA2, 0),
+			device.AnalogPin(pb.PinName_PA3, 1),
+		},
+		IO:               mem,
+		SamplingInterval: time.Millisecond,
+	})
+	go d.Serve(ctx)
+
+	hctx, hcancel := context.WithTimeout(ctx, time.Second)
+	defer hcancel()
+	f, err := firmata.Connect(hctx, host, &firmata.Config{})
+	gobottest.Assert(t, err, nil)
+	t.Cleanup(f.Close)
+
+	log := zerolog.Nop()
+	integration := &pb.Integration{
+		Firmatas: []*pb.Firmata{{Name: "f0", ManualConnect: true}},
+	}
+	config := &pb.Config{
+		Groups: []*pb.Group{{Name: "g0", Pins: pins}},
+	}
+	s := NewServer(ctx, &log, nil, nil, integration, config, Options{})
+	s.instances[0] = &Instance{
+		log:     &log,
+		index:   0,
+		config:  integration.Firmatas[0],
+		firmata: f,
+	}
+
+	for _, p := range pins {
+		if p.Mode == pb.Mode_OUTPUT {
+			err = f.SetMode(ctx, byte(p.GetDx()), firmata.PIN_MODE_OUTPUT)
+			gobottest.Assert(t, err, nil)
+		}
+	}
+	return s, mem
+}
+
+// waitValue waits the pin of the device to be value.
+func waitValue(t *testing.T, mem *device.MemoryIO, pin byte, value uint32, timeout time.Duration) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for mem.Value(pin) != value {
+		if time.Now().After(deadline) {
+			t.Fatalf("pin %d is %d, want %d", pin, mem.Value(pin), value)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestCheckHealth(t *testing.T) {
+	s, _ := testServer(t)
+	ctx := context.Background()
+	gobottest.Assert(t, s.CheckHealth(ctx), nil)
+
+	// a stuck loop
+	block := make(chan struct{})
+	defer close(block)
+	s.instances[0].firmata.Loop(func() { <-block })
+	ctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	gobottest.Refute(t, s.CheckHealth(ctx), nil)
+}
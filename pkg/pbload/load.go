@@ -6,6 +6,7 @@ import (
 	"path/filepath"
 	"strings"
 
+	"github.com/empirefox/firmata/pkg/dial"
 	"github.com/empirefox/firmata/pkg/pb"
 	"google.golang.org/protobuf/encoding/protojson"
 	"google.golang.org/protobuf/reflect/protoreflect"
@@ -13,6 +14,16 @@ import (
 
 var json = protojson.UnmarshalOptions{DiscardUnknown: true}
 
+// maxMovingAverage limits the window allocated for every filtered pin.
+const maxMovingAverage = 1024
+
+const (
+	// maxFailsafePwm is the largest value of a 14 bits ANALOG_MESSAGE.
+	maxFailsafePwm = 0x3FFF
+	// maxFailsafeServo accepts degrees or a pulse width in microseconds.
+	maxFailsafeServo = 3000
+)
+
 func LoadApiVersion() *pb.Version_Peer {
 	return &pb.Version_Peer{Major: 0, Minor: 0, Bugfix: 1}
 }
@@ -84,6 +95,24 @@ func CheckError(boards []*pb.Board, integration *pb.Integration, config *pb.Conf
 		if t.ConnectRetrySecond == 0 {
 			t.ConnectRetrySecond = 10
 		}
+		if b := t.Backoff; b != nil {
+			if b.InitialMs == 0 {
+				b.InitialMs = t.ConnectRetrySecond * 1000
+			}
+			if b.MaxMs == 0 {
+				b.MaxMs = 300000
+			}
+			if b.Multiplier == 0 {
+				b.Multiplier = 2
+			}
+			// jitter 1 could make a zero delay
+			if b.Multiplier < 1 || !(b.Jitter >= 0 && b.Jitter < 1) || b.MaxMs < b.InitialMs {
+				return fmt.Errorf("invalid backoff of firmata %s", t.Name)
+			}
+		}
+		if err := dial.Validate(t.Dial); err != nil {
+			return fmt.Errorf("invalid dial of firmata %s: %v", t.Name, err)
+		}
 		for _, w := range t.Wiring {
 			w.From.FirmataIndex = uint32(i)
 			if f := w.To.GetFirmata(); f != nil {
@@ -110,6 +139,57 @@ func CheckError(boards []*pb.Board, integration *pb.Integration, config *pb.Conf
 					return fmt.Errorf("group pin of firmata not found: %s", dt.Firmata)
 				}
 				dt.FirmataIndex = index
+				if dt.Id == nil {
+					return fmt.Errorf("detect pin of group %s pin %s is not set", g.Name, p.Nick)
+				}
+			}
+
+			if w := p.GetNumberWriter(); w != nil && w.Max != 0 && w.Max < w.Min {
+				return fmt.Errorf("max < min of group %s pin %s", g.Name, p.Nick)
+			}
+
+			if af := p.Filter; af != nil {
+				if p.Mode != pb.Mode_ANALOG {
+					return fmt.Errorf("filter of group %s pin %s requires analog mode", g.Name, p.Nick)
+				}
+				if af.GetMovingAverage() > maxMovingAverage {
+					return fmt.Errorf("movingAverage of group %s pin %s exceeds %d", g.Name, p.Nick, maxMovingAverage)
+				}
+				if _, ok := af.Smoothing.(*pb.Group_AnalogFilter_Ema); ok && (af.GetEma() <= 0 || af.GetEma() > 1) {
+					return fmt.Errorf("ema of group %s pin %s is not in (0, 1]", g.Name, p.Nick)
+				}
+			}
+
+			if nr := p.GetNumberReader(); nr != nil {
+				// enabled thresholds must be ascending from veryLow to veryHigh
+				var last uint32
+				for _, t := range []uint32{nr.VeryLowThreshold, nr.LittleLowThreshold,
+					nr.LittleHighThreshold, nr.VeryHighThreshold} {
+					if t == 0 {
+						continue
+					}
+					if t <= last {
+						return fmt.Errorf("thresholds of group %s pin %s are not ordered", g.Name, p.Nick)
+					}
+					last = t
+				}
+			}
+
+			if p.Failsafe != nil {
+				var max int32
+				switch p.Mode {
+				case pb.Mode_OUTPUT:
+					max = 1
+				case pb.Mode_PWM:
+					max = maxFailsafePwm
+				case pb.Mode_SERVO:
+					max = maxFailsafeServo
+				default:
+					return fmt.Errorf("failsafe of group %s pin %s requires output mode", g.Name, p.Nick)
+				}
+				if v := p.GetFailsafe(); v < 0 || v > max {
+					return fmt.Errorf("failsafe of group %s pin %s is not in [0, %d]", g.Name, p.Nick, max)
+				}
 			}
 		}
 	}
@@ -0,0 +1,95 @@
+package pbload
+
+import (
+	"math"
+	"testing"
+
+	"github.com/empirefox/firmata/pkg/pb"
+)
+
+func checkPin(p *pb.Group_Pin) error {
+	p.Firmata = "f0"
+	integration := &pb.Integration{Firmatas: []*pb.Firmata{
+		{Name: "f0", Dial: "tcp://127.0.0.1:3030"},
+	}}
+	config := &pb.Config{Groups: []*pb.Group{{Name: "g0", Pins: []*pb.Group_Pin{p}}}}
+	return CheckError(nil, integration, config)
+}
+
+func TestCheckFailsafe(t *testing.T) {
+	cases := []struct {
+		mode     pb.Mode
+		failsafe int32
+		ok       bool
+	}{
+		{pb.Mode_OUTPUT, 0, true},
+		{pb.Mode_OUTPUT, 1, true},
+		{pb.Mode_OUTPUT, 2, false},
+		{pb.Mode_OUTPUT, -1, false},
+		{pb.Mode_PWM, 16383, true},
+		{pb.Mode_PWM, 16384, false},
+		{pb.Mode_PWM, -1, false},
+		{pb.Mode_SERVO, 90, true},
+		{pb.Mode_SERVO, 1500, true},
+		{pb.Mode_SERVO, 3001, false},
+		{pb.Mode_INPUT, 0, false},
+	}
+	for _, c := range cases {
+		failsafe := c.failsafe
+		err := checkPin(&pb.Group_Pin{
+			Nick:     "p",
+			Mode:     c.mode,
+			Id:       &pb.Group_Pin_Dx{Dx: 0},
+			Failsafe: &failsafe,
+		})
+		if (err == nil) != c.ok {
+			t.Errorf("%s failsafe %d: unexpected error: %v", c.mode, c.failsafe, err)
+		}
+	}
+}
+
+func TestCheckDial(t *testing.T) {
+	cases := []struct {
+		dial string
+		ok   bool
+	}{
+		{"tcp://127.0.0.1:3030?timeout=2s", true},
+		{"fault+tcp://127.0.0.1:3030?seed=1&drop=0.1", true},
+		{"tcp://127.0.0.1:3030?timeout=x", false},
+		{"nope://127.0.0.1:3030", false},
+		{"fault+tcp://127.0.0.1:3030?drop=x", false},
+		{"usb://?baud=115200", false},
+		{"not a url", false},
+	}
+	for _, c := range cases {
+		integration := &pb.Integration{Firmatas: []*pb.Firmata{{Name: "f0", Dial: c.dial}}}
+		err := CheckError(nil, integration, &pb.Config{})
+		if (err == nil) != c.ok {
+			t.Errorf("%s: unexpected error: %v", c.dial, err)
+		}
+	}
+}
+
+func TestCheckBackoff(t *testing.T) {
+	cases := []struct {
+		backoff *pb.Firmata_Backoff
+		ok      bool
+	}{
+		{&pb.Firmata_Backoff{}, true},
+		{&pb.Firmata_Backoff{Jitter: 0.99}, true},
+		{&pb.Firmata_Backoff{Jitter: 1}, false},
+		{&pb.Firmata_Backoff{Jitter: -0.1}, false},
+		{&pb.Firmata_Backoff{Jitter: math.NaN()}, false},
+		{&pb.Firmata_Backoff{Multiplier: 0.5}, false},
+		{&pb.Firmata_Backoff{InitialMs: 2000, MaxMs: 1000}, false},
+	}
+	for i, c := range cases {
+		integration := &pb.Integration{Firmatas: []*pb.Firmata{
+			{Name: "f0", Dial: "tcp://127.0.0.1:3030", Backoff: c.backoff},
+		}}
+		err := CheckError(nil, integration, &pb.Config{})
+		if (err == nil) != c.ok {
+			t.Errorf("case %d: unexpected error: %v", i, err)
+		}
+	}
+}